@@ -0,0 +1,687 @@
+package vmtool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/api"
+	"github.com/google/uuid"
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/network"
+	"github.com/utmapp/vmtool/pkg/vm"
+	"gopkg.in/yaml.v3"
+)
+
+var createCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create a new virtual machine",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		fmt.Printf("Creating VM: %s\n", name)
+
+		cfg := &config.VMConfig{
+			Name: name,
+			UUID: uuid.New().String(),
+			System: config.SystemConfig{
+				Architecture: "x86_64",
+				Memory:       2048,
+				CPUs:         2,
+			},
+			Display: config.DisplayConfig{
+				Enabled: true,
+			},
+		}
+
+		// Save VM to store
+		store, err := newStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := store.SaveVM(cfg); err != nil {
+			fmt.Printf("Error saving VM: %v\n", err)
+			return
+		}
+		fmt.Printf("VM %s created successfully.\n", name)
+	},
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all virtual machines",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := newStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		vms := store.ListVMs()
+		fmt.Printf("%-20s %-20s %s\n", "NAME", "ARCH", "STATUS")
+		for _, v := range vms {
+			fmt.Printf("%-20s %-20s %s\n", v.Name, v.System.Architecture, "stopped") // Manager check needed for status
+		}
+	},
+}
+
+var startCmd = &cobra.Command{
+	Use:   "start [name]",
+	Short: "Start a virtual machine",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("🚀 Starting VM: %s...\n", name)
+		if err := manager.StartVM(cmd.Context(), name); err != nil {
+			fmt.Printf("❌ Error starting VM: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ VM %s is now running.\n", name)
+	},
+}
+
+var stopCmd = &cobra.Command{
+	Use:   "stop [name]",
+	Short: "Stop a virtual machine",
+	Long: `Stop a virtual machine and wait until QEMU has exited.
+
+The guest is asked to power off with the ACPI power button. If it hasn't within
+--timeout, QEMU is told to quit, and killed if even that fails. --force skips
+the guest and quits QEMU right away, like pulling the plug.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		force, _ := cmd.Flags().GetBool("force")
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+		if force {
+			fmt.Printf("🛑 Force stopping VM: %s...\n", name)
+		} else {
+			fmt.Printf("🛑 Stopping VM: %s (waiting up to %s for the guest)...\n", name, timeout)
+		}
+		res, err := manager.StopVM(ctx, name, vm.StopOptions{Timeout: timeout, Force: force})
+		if err != nil {
+			fmt.Printf("❌ Error stopping VM: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ VM %s stopped by %s after %s.\n", name, res.Method, res.Elapsed.Round(100*time.Millisecond))
+	},
+}
+
+var resetCmd = &cobra.Command{
+	Use:   "reset [name]",
+	Short: "Reset a virtual machine, like its reset button",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := manager.ResetVM(name); err != nil {
+			fmt.Printf("❌ Error resetting VM: %v\n", err)
+			return
+		}
+		fmt.Printf("🔁 VM %s reset.\n", name)
+	},
+}
+
+var rebootCmd = &cobra.Command{
+	Use:   "reboot [name]",
+	Short: "Ask the guest to reboot",
+	Long: `Ask the guest to reboot, through the guest agent when it has one, else by
+pressing ctrl-alt-delete. --force resets the machine instead.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		force, _ := cmd.Flags().GetBool("force")
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		method := "reset"
+		if force {
+			err = manager.ResetVM(name)
+		} else {
+			method, err = manager.RebootVM(name)
+		}
+		if err != nil {
+			fmt.Printf("❌ Error rebooting VM: %v\n", err)
+			return
+		}
+		fmt.Printf("🔁 VM %s rebooting (%s).\n", name, method)
+	},
+}
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause [name]",
+	Short: "Pause a running virtual machine",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("⏸️  Pausing VM: %s...\n", name)
+		if err := manager.PauseVM(name); err != nil {
+			fmt.Printf("❌ Error pausing VM: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ VM %s paused.\n", name)
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume [name]",
+	Short: "Resume a paused virtual machine",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("▶️  Resuming VM: %s...\n", name)
+		if err := manager.ResumeVM(name); err != nil {
+			fmt.Printf("❌ Error resuming VM: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ VM %s resumed.\n", name)
+	},
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start the VM management daemon and web server",
+	Run: func(cmd *cobra.Command, args []string) {
+		appCfg, err := config.LoadAppConfig()
+		if err != nil {
+			fmt.Printf("Error loading config: %v\n", err)
+			return
+		}
+
+		store, err := vm.NewStore(appCfg.Paths.VMs)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		networks, err := network.NewStore(appCfg.Paths.Networks)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		manager := vm.NewManager(store, networks, appCfg)
+		shares, err := newShareStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		recordings, err := newRecordingStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		server := api.NewServer(manager, shares, recordings, appCfg)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go manager.Autostart(ctx)
+
+		addr := fmt.Sprintf("%s:%d", appCfg.Server.Host, appCfg.Server.Port)
+		fmt.Printf("Starting VMTool server on %s...\n", addr)
+		errChan := make(chan error, 1)
+		go func() { errChan <- server.Run(addr) }()
+		select {
+		case err := <-errChan:
+			if err != nil {
+				fmt.Printf("Server error: %v\n", err)
+			}
+			return
+		case <-ctx.Done():
+		}
+		// A second signal exits right away.
+		stop()
+
+		timeout := appCfg.Shutdown.TimeoutDuration()
+		fmt.Printf("🛑 Shutting down, waiting up to %s for requests in flight...\n", timeout)
+		sctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := server.Shutdown(sctx); err != nil {
+			fmt.Printf("⚠️ Requests cut off: %v\n", err)
+		}
+		cancel()
+		if err := manager.Shutdown(context.Background(), appCfg.Shutdown, printStep); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+		} else if appCfg.Shutdown.Policy == config.ShutdownDetach {
+			fmt.Println("✅ VMs left running, the next daemon takes them over.")
+		}
+	},
+}
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete [name]",
+	Short: "Delete a virtual machine",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		store, err := newStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("🗑️ Deleting VM: %s...\n", name)
+		if err := store.DeleteVM(name); err != nil {
+			fmt.Printf("❌ Error deleting VM: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ VM %s deleted.\n", name)
+	},
+}
+
+var infoCmd = &cobra.Command{
+	Use:   "info [name]",
+	Short: "Show detailed information about a virtual machine",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		store, err := newStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		cfg, ok := store.GetVM(name)
+		if !ok {
+			fmt.Printf("❌ VM %s not found.\n", name)
+			return
+		}
+
+		fmt.Printf("VM Info: %s\n", cfg.Name)
+		fmt.Printf("  UUID:    %s\n", cfg.UUID)
+		fmt.Printf("  Arch:    %s\n", cfg.System.Architecture)
+		fmt.Printf("  Memory:  %d MB\n", cfg.System.Memory)
+		fmt.Printf("  CPUs:    %d\n", cfg.System.CPUs)
+		fmt.Printf("  Drives:  %d\n", len(cfg.Drives))
+		for _, d := range cfg.Drives {
+			fmt.Printf("    - %s (%s)\n", d.ImagePath, d.Interface)
+		}
+
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if ports, err := manager.SerialPorts(name); err == nil {
+			fmt.Printf("  Serial:  %d\n", len(ports))
+			for _, p := range ports {
+				fmt.Printf("    - %s\n", describeSerialPort(p))
+			}
+		}
+		if len(cfg.Groups) > 0 {
+			fmt.Printf("  Groups:  %s\n", strings.Join(cfg.Groups, ", "))
+		}
+		if len(cfg.DependsOn) > 0 {
+			fmt.Printf("  Depends: %d\n", len(cfg.DependsOn))
+			for _, d := range cfg.DependsOn {
+				switch d.Condition {
+				case "", config.ConditionStarted:
+					fmt.Printf("    - %s\n", d.VM)
+				case config.ConditionPort:
+					fmt.Printf("    - %s (port %d)\n", d.VM, d.Port)
+				default:
+					fmt.Printf("    - %s (%s)\n", d.VM, d.Condition)
+				}
+			}
+		}
+		if cfg.Autostart {
+			fmt.Println("  Start:   with the daemon")
+		}
+		if cfg.Restart.Policy != "" && cfg.Restart.Policy != config.RestartNo {
+			fmt.Printf("  Restart: %s\n", cfg.Restart.Policy)
+		}
+		state, err := manager.State(name)
+		if err != nil {
+			fmt.Println("  Status:  stopped")
+			return
+		}
+		fmt.Println("  Status:  running")
+		if state.PID != 0 {
+			fmt.Printf("  PID:     %d\n", state.PID)
+		}
+		if state.VNC != nil {
+			fmt.Printf("  VNC:     %s\n", state.VNC)
+		}
+		if cfg.GuestAgent {
+			if addrs, err := manager.GuestAddresses(name); err == nil && len(addrs) > 0 {
+				fmt.Printf("  IPs:     %s\n", strings.Join(addrs, ", "))
+			}
+		}
+	},
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Manage VM snapshots",
+}
+
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create [vm-name] [snapshot-name]",
+	Short: "Create a new snapshot",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName, snapName := args[0], args[1]
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("📸 Creating snapshot '%s' for VM '%s'...\n", snapName, vmName)
+		if err := manager.CreateSnapshot(vmName, snapName); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Println("✅ Snapshot created.")
+	},
+}
+
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore [vm-name] [snapshot-name]",
+	Short: "Restore a snapshot",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName, snapName := args[0], args[1]
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("⏪ Restoring snapshot '%s' for VM '%s'...\n", snapName, vmName)
+		if err := manager.RestoreSnapshot(vmName, snapName); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Println("✅ Snapshot restored.")
+	},
+}
+
+var snapshotDeleteCmd = &cobra.Command{
+	Use:   "delete [vm-name] [snapshot-name]",
+	Short: "Delete a snapshot",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName, snapName := args[0], args[1]
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("🗑️ Deleting snapshot '%s' for VM '%s'...\n", snapName, vmName)
+		if err := manager.DeleteSnapshot(vmName, snapName); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Println("✅ Snapshot deleted.")
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import [path.utm]",
+	Short: "Import a UTM bundle into vmtool",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bundlePath := args[0]
+		fmt.Printf("📥 Importing UTM bundle: %s...\n", bundlePath)
+		cfg, warnings, err := vm.ImportUTM(bundlePath)
+		if err != nil {
+			fmt.Printf("❌ Error importing UTM: %v\n", err)
+			return
+		}
+
+		fmt.Printf("✅ Imported: %s\n", cfg.Name)
+		fmt.Println("📋 Summary:")
+		fmt.Printf("   - Name: %s\n", cfg.Name)
+		fmt.Printf("   - Arch: %s\n", cfg.System.Architecture)
+		fmt.Printf("   - CPU:  %d cores\n", cfg.System.CPUs)
+		fmt.Printf("   - RAM:  %d MB\n", cfg.System.Memory)
+		fmt.Printf("   - Disks: %d\n", len(cfg.Drives))
+
+		if len(warnings) > 0 {
+			fmt.Println("\n⚠️  Warnings:")
+			for _, w := range warnings {
+				fmt.Printf("   - %s\n", w)
+			}
+		}
+
+		appCfg, err := config.LoadAppConfig()
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		store, err := vm.NewStore(appCfg.Paths.VMs)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		if err := store.SaveVM(cfg); err != nil {
+			fmt.Printf("❌ Error saving VM: %v\n", err)
+			return
+		}
+
+		fmt.Printf("\n💾 Saved to: %s\n", filepath.Join(appCfg.Paths.VMs, cfg.Name+".yaml"))
+		fmt.Printf("▶️  Start with: vmtool start %s\n", cfg.Name)
+	},
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Initialize vmtool with default directories and configuration",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("🚀 Initializing vmtool...")
+
+		configDir := config.GetDefaultConfigDir()
+		dataDir := config.GetDefaultDataDir()
+		cacheDir := config.GetDefaultCacheDir()
+		vmDir := filepath.Join(dataDir, "machines")
+		networkDir := filepath.Join(dataDir, "networks")
+
+		dirs := []string{configDir, dataDir, cacheDir, vmDir, networkDir}
+		for _, dir := range dirs {
+			fmt.Printf("📁 Creating directory: %s\n", dir)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				fmt.Printf("❌ Error creating directory %s: %v\n", dir, err)
+				return
+			}
+		}
+
+		appCfg := config.AppConfig{
+			Paths: config.PathConfig{
+				VMs:      vmDir,
+				Networks: networkDir,
+				Cache:    cacheDir,
+			},
+			QEMU: config.QEMUConfig{
+				Binary: "auto",
+			},
+			Server: config.ServerConfig{
+				Host: "127.0.0.1",
+				Port: 8080,
+			},
+			Security: config.SecurityConfig{
+				APIToken: "", // Generate later
+			},
+			VNC: config.DefaultVNCConfig,
+		}
+
+		cfgPath := filepath.Join(configDir, "config.yaml")
+		fmt.Printf("🔧 Creating default config: %s\n", cfgPath)
+		data, _ := yaml.Marshal(appCfg)
+		if err := os.WriteFile(cfgPath, data, 0644); err != nil {
+			fmt.Printf("❌ Error writing config: %v\n", err)
+			return
+		}
+
+		fmt.Println("\n✅ vmtool initialized successfully!")
+	},
+}
+
+var netcapCmd = &cobra.Command{
+	Use:   "netcap [name]",
+	Short: "Capture the network traffic of a running VM to a pcap file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		nic, _ := cmd.Flags().GetInt("nic")
+		output, _ := cmd.Flags().GetString("output")
+		duration, _ := cmd.Flags().GetDuration("duration")
+		maxSize, _ := cmd.Flags().GetString("max-size")
+		snapLen, _ := cmd.Flags().GetInt("snaplen")
+
+		maxBytes, err := config.ParseSize(maxSize)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		if output == "" {
+			output = fmt.Sprintf("%s-nic%d.pcap", name, nic)
+		}
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+		fmt.Printf("📡 Capturing nic %d of VM %s to %s (Ctrl-C to stop)...\n", nic, name, output)
+		result, err := manager.CaptureNetwork(ctx, name, vm.CaptureOptions{
+			NIC:      nic,
+			Path:     output,
+			Duration: duration,
+			MaxBytes: maxBytes,
+			SnapLen:  snapLen,
+		})
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ Captured %d bytes in %s (%s): %s\n", result.Bytes, result.Duration.Round(time.Millisecond), result.Reason, result.Path)
+	},
+}
+
+var screenshotCmd = &cobra.Command{
+	Use:   "screenshot [name]",
+	Short: "Save a PNG of a running VM's display",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		output, _ := cmd.Flags().GetString("output")
+		width, _ := cmd.Flags().GetInt("width")
+		height, _ := cmd.Flags().GetInt("height")
+		scale, _ := cmd.Flags().GetFloat64("scale")
+		thumbnail, _ := cmd.Flags().GetBool("thumbnail")
+
+		if output == "" {
+			output = name + ".png"
+		}
+		if thumbnail && width == 0 && height == 0 {
+			width = vm.ThumbnailWidth
+		}
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		data, err := manager.Screenshot(name, vm.ScreenshotOptions{Width: width, Height: height, Scale: scale})
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		if err := os.WriteFile(output, data, 0644); err != nil {
+			fmt.Printf("❌ Error writing screenshot: %v\n", err)
+			return
+		}
+		fmt.Printf("📸 Screenshot of %s saved to %s\n", name, output)
+	},
+}
+
+// newStore opens the VM configurations where config.yaml keeps them, like the server does.
+func newStore() (*vm.Store, error) {
+	appCfg, err := config.LoadAppConfig()
+	if err != nil {
+		return nil, err
+	}
+	return vm.NewStore(appCfg.Paths.VMs)
+}
+
+func newManager() (*vm.Manager, error) {
+	appCfg, err := config.LoadAppConfig()
+	if err != nil {
+		return nil, err
+	}
+	store, err := vm.NewStore(appCfg.Paths.VMs)
+	if err != nil {
+		return nil, err
+	}
+	networks, err := network.NewStore(appCfg.Paths.Networks)
+	if err != nil {
+		return nil, err
+	}
+	return vm.NewManager(store, networks, appCfg), nil
+}
+
+func init() {
+	rootCmd.AddCommand(createCmd)
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(startCmd)
+	stopCmd.Flags().Duration("timeout", vm.DefaultStopTimeout, "How long the guest gets to power off before QEMU is quit")
+	stopCmd.Flags().Bool("force", false, "Quit QEMU right away, without asking the guest")
+	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(resetCmd)
+	rebootCmd.Flags().Bool("force", false, "Reset the machine instead of asking the guest")
+	rootCmd.AddCommand(rebootCmd)
+	rootCmd.AddCommand(pauseCmd)
+	rootCmd.AddCommand(resumeCmd)
+	rootCmd.AddCommand(deleteCmd)
+	rootCmd.AddCommand(infoCmd)
+	rootCmd.AddCommand(importCmd)
+
+	netcapCmd.Flags().Int("nic", 0, "Index of the NIC to capture")
+	netcapCmd.Flags().StringP("output", "o", "", "Output pcap file (default <name>-nic<N>.pcap)")
+	netcapCmd.Flags().Duration("duration", 0, "Stop after this long (default: until Ctrl-C)")
+	netcapCmd.Flags().String("max-size", "0", "Stop once the file reaches this size, e.g. 50M (0 for no limit)")
+	netcapCmd.Flags().Int("snaplen", 0, "Bytes kept per packet (default 65536)")
+	rootCmd.AddCommand(netcapCmd)
+
+	screenshotCmd.Flags().StringP("output", "o", "", "Output PNG file (default <name>.png)")
+	screenshotCmd.Flags().Int("width", 0, "Fit the image into this width")
+	screenshotCmd.Flags().Int("height", 0, "Fit the image into this height")
+	screenshotCmd.Flags().Float64("scale", 0, "Resize by this factor, e.g. 0.5")
+	screenshotCmd.Flags().Bool("thumbnail", false, "Small image, 320 pixels wide")
+	rootCmd.AddCommand(screenshotCmd)
+
+	snapshotCmd.AddCommand(snapshotCreateCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	snapshotCmd.AddCommand(snapshotDeleteCmd)
+	rootCmd.AddCommand(snapshotCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(initCmd)
+}
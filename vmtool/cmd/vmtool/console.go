@@ -0,0 +1,117 @@
+package vmtool
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/console"
+	"github.com/utmapp/vmtool/pkg/logs"
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+var consoleCmd = &cobra.Command{
+	Use:   "console [name]",
+	Short: "Attach to a running VM's serial console",
+	Long: `Attach the terminal to a running VM's serial console.
+
+Several terminals can be attached at once; all of them see the output and can
+type. The most recent output is shown when attaching. Press the detach keys
+(ctrl-] by default) to leave the VM running.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		detachKeys, _ := cmd.Flags().GetString("detach-keys")
+
+		detach, err := console.ParseDetachKeys(detachKeys)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		conn, err := manager.Console(name)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		defer conn.Close()
+
+		fd := int(os.Stdin.Fd())
+		if console.IsTerminal(fd) {
+			restore, err := console.MakeRaw(fd)
+			if err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+				return
+			}
+			defer restore()
+			fmt.Printf("🔌 Connected to %s, press %s to detach.\r\n", name, detachKeys)
+		}
+
+		detached, err := console.Attach(conn, os.Stdin, os.Stdout, detach)
+		switch {
+		case err != nil:
+			fmt.Printf("\r\n❌ Error: %v\r\n", err)
+		case detached:
+			fmt.Printf("\r\n👋 Detached from %s.\r\n", name)
+		default:
+			fmt.Printf("\r\n🛑 Console of %s closed.\r\n", name)
+		}
+	},
+}
+
+// consoleServeCmd is the console server started by the manager next to each VM.
+var consoleServeCmd = &cobra.Command{
+	Use:    "console-serve [name]",
+	Short:  "Share a VM's serial console in the foreground",
+	Args:   cobra.ExactArgs(1),
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		appCfg, err := config.LoadAppConfig()
+		if err != nil {
+			return err
+		}
+		maxSize, maxFiles := appCfg.Logs.MaxBytes(), appCfg.Logs.MaxFiles
+		serialLog, err := logs.NewWriter(logs.Path(appCfg.DataDir(), name, logs.SourceSerial), maxSize, maxFiles)
+		if err != nil {
+			return err
+		}
+		defer serialLog.Close()
+
+		// QEMU appends to its own log for as long as the VM runs, so it is rotated from here.
+		go func() {
+			ticker := time.NewTicker(30 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := logs.CopyTruncate(logs.Path(appCfg.DataDir(), name, logs.SourceQEMU), maxSize, maxFiles); err != nil {
+						log.Printf("Failed to rotate QEMU log: %v", err)
+					}
+				}
+			}
+		}()
+
+		return console.Serve(ctx, qemu.SerialSocketPath(name), console.SocketPath(name), serialLog)
+	},
+}
+
+func init() {
+	consoleCmd.Flags().String("detach-keys", console.DefaultDetachKeys, "Key sequence that detaches, e.g. ctrl-p,ctrl-q")
+	rootCmd.AddCommand(consoleCmd)
+	rootCmd.AddCommand(consoleServeCmd)
+}
@@ -0,0 +1,214 @@
+package vmtool
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+var cpCmd = &cobra.Command{
+	Use:   "cp [vm:]src [vm:]dst",
+	Short: "Copy a file between the host and a VM",
+	Long: `Copy a single file into or out of a running VM through the guest agent.
+
+Exactly one side names a VM, as <vm>:<path>. A guest path ending in / is a
+directory the file is copied into, as is an existing local directory.
+
+Permissions are copied with stat and chmod run in the guest, which works in
+Linux guests; elsewhere a warning is shown. With --resume, an interrupted copy
+continues from the size of the partial file at the destination.`,
+	Example: `  vmtool cp ./app.conf my-ubuntu:/etc/app/
+  vmtool cp my-ubuntu:/var/log/syslog .
+  vmtool cp --resume big.iso my-ubuntu:/tmp/big.iso`,
+	Args:          cobra.ExactArgs(2),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		resume, _ := cmd.Flags().GetBool("resume")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+
+		srcVM, src := splitGuestPath(args[0])
+		dstVM, dst := splitGuestPath(args[1])
+		if (srcVM == "") == (dstVM == "") {
+			return fmt.Errorf("❌ Error: exactly one of source and destination must be <vm>:<path>")
+		}
+		manager, err := newManager()
+		if err != nil {
+			return err
+		}
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		var progress *transferProgress
+		if dstVM != "" {
+			f, err := os.Open(src)
+			if err != nil {
+				return fmt.Errorf("❌ Error: %v", err)
+			}
+			defer f.Close()
+			st, err := f.Stat()
+			if err != nil {
+				return fmt.Errorf("❌ Error: %v", err)
+			}
+			if st.IsDir() {
+				return fmt.Errorf("❌ Error: %s is a directory, only files can be copied", src)
+			}
+			if strings.HasSuffix(dst, "/") || strings.HasSuffix(dst, `\`) {
+				dst += filepath.Base(src)
+			}
+
+			opts := vm.TransferOptions{}
+			if resume {
+				size, err := manager.GuestFileSize(dstVM, dst)
+				if err != nil {
+					return fmt.Errorf("❌ Error: %v", err)
+				}
+				if size > st.Size() {
+					return fmt.Errorf("❌ Error: %s in the guest is larger than %s, cannot resume", dst, src)
+				}
+				if size > 0 {
+					if _, err := f.Seek(size, 0); err != nil {
+						return fmt.Errorf("❌ Error: %v", err)
+					}
+					opts.Offset = size
+				}
+			}
+			if !quiet {
+				progress = newTransferProgress(st.Size(), opts.Offset)
+				opts.Progress = progress.update
+			}
+			n, err := manager.UploadFile(ctx, dstVM, dst, f, opts)
+			progress.finish()
+			if err != nil {
+				return fmt.Errorf("❌ Error: %v (copied %d bytes, --resume continues)", err, n)
+			}
+			if err := manager.GuestChmod(dstVM, dst, st.Mode()); err != nil && !quiet {
+				fmt.Printf("⚠️  Permissions not copied: %v\n", err)
+			}
+			if !quiet {
+				fmt.Printf("✅ Copied %s to %s:%s\n", src, dstVM, dst)
+			}
+			return nil
+		}
+
+		if st, err := os.Stat(dst); err == nil && st.IsDir() {
+			dst = filepath.Join(dst, path.Base(strings.ReplaceAll(src, `\`, "/")))
+		}
+		size, err := manager.GuestFileSize(srcVM, src)
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		if size < 0 {
+			return fmt.Errorf("❌ Error: %s not found in %s", src, srcVM)
+		}
+		opts := vm.TransferOptions{}
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if resume {
+			if st, err := os.Stat(dst); err == nil && st.Size() > 0 {
+				if st.Size() > size {
+					return fmt.Errorf("❌ Error: %s is larger than %s in the guest, cannot resume", dst, src)
+				}
+				opts.Offset = st.Size()
+				flags = os.O_WRONLY | os.O_APPEND
+			}
+		}
+		perm := os.FileMode(0644)
+		if mode, err := manager.GuestFileMode(srcVM, src); err == nil {
+			perm = mode
+		} else if !quiet {
+			fmt.Printf("⚠️  Permissions not copied: %v\n", err)
+		}
+		f, err := os.OpenFile(dst, flags, perm)
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		if !quiet {
+			progress = newTransferProgress(size, opts.Offset)
+			opts.Progress = progress.update
+		}
+		n, err := manager.DownloadFile(ctx, srcVM, src, f, opts)
+		progress.finish()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v (copied %d bytes, --resume continues)", err, n)
+		}
+		// An existing file keeps its mode on open, so set it explicitly.
+		os.Chmod(dst, perm)
+		if !quiet {
+			fmt.Printf("✅ Copied %s:%s to %s\n", srcVM, src, dst)
+		}
+		return nil
+	},
+}
+
+// splitGuestPath splits <vm>:<path>. Anything else, including Windows paths like C:\x, is a local path.
+func splitGuestPath(arg string) (string, string) {
+	name, p, ok := strings.Cut(arg, ":")
+	if !ok || len(name) < 2 || !config.ValidName(name) {
+		return "", arg
+	}
+	return name, p
+}
+
+// transferProgress prints a progress line at most a few times a second.
+type transferProgress struct {
+	total int64
+	start time.Time
+	base  int64
+	last  time.Time
+	done  int64
+}
+
+func newTransferProgress(total, offset int64) *transferProgress {
+	return &transferProgress{total: total, base: offset, done: offset, start: time.Now()}
+}
+
+func (p *transferProgress) update(done int64) {
+	p.done = done
+	if time.Since(p.last) < 200*time.Millisecond && done < p.total {
+		return
+	}
+	p.last = time.Now()
+	rate := float64(done-p.base) / max(time.Since(p.start).Seconds(), 0.001)
+	pct := 100.0
+	if p.total > 0 {
+		pct = float64(done) * 100 / float64(p.total)
+	}
+	fmt.Fprintf(os.Stderr, "\r⏳ %s / %s (%.0f%%) %s/s   ", formatBytes(done), formatBytes(p.total), pct, formatBytes(int64(rate)))
+}
+
+func (p *transferProgress) finish() {
+	if p != nil && !p.last.IsZero() {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	cpCmd.Flags().Bool("resume", false, "Continue an interrupted copy")
+	cpCmd.Flags().BoolP("quiet", "q", false, "No progress or messages")
+	rootCmd.AddCommand(cpCmd)
+}
@@ -0,0 +1,61 @@
+package vmtool
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/logs"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Show what the daemon did on its own, like restarting crashed VMs",
+	Long: `Show events recorded by the daemon: VMs started with it, QEMU exits and
+crashes, restarts by a VM's restart policy, crash loops and restarts given up.
+Events are also noted in each VM's QEMU log.`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, _ := cmd.Flags().GetString("vm")
+		follow, _ := cmd.Flags().GetBool("follow")
+		since, _ := cmd.Flags().GetString("since")
+		tail, _ := cmd.Flags().GetInt("tail")
+
+		opts := vm.EventOptions{VM: name, Tail: tail, Follow: follow}
+		if since != "" {
+			var err error
+			if opts.Since, err = logs.ParseSince(since, time.Now()); err != nil {
+				return fmt.Errorf("❌ Error: %v", err)
+			}
+		}
+		manager, err := newManager()
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = manager.Events(ctx, opts, func(e vm.Event) error {
+			_, err := fmt.Printf("%s  %-14s %-16s %s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Type, e.VM, e.Message)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	eventsCmd.Flags().String("vm", "", "Only show events of this VM")
+	eventsCmd.Flags().BoolP("follow", "f", false, "Keep printing new events")
+	eventsCmd.Flags().String("since", "", "Only show events since a time (e.g. 2024-01-02T15:04:05Z) or for a duration (e.g. 1h)")
+	eventsCmd.Flags().IntP("tail", "n", 0, "Only show the last events, all when 0")
+	rootCmd.AddCommand(eventsCmd)
+}
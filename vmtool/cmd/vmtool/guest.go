@@ -0,0 +1,259 @@
+package vmtool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+var guestCmd = &cobra.Command{
+	Use:   "guest",
+	Short: "Talk to the QEMU guest agent inside a VM",
+	Long: `Talk to qemu-ga inside a running VM.
+
+The VM needs guest_agent: true in its configuration, which adds the
+virtio-serial channel the agent uses, and the qemu-guest-agent package
+installed and running in the guest.`,
+}
+
+// guestAgent prints why the agent can't be reached and returns nil then.
+func guestAgent(name string) *qemu.GuestAgent {
+	manager, err := newManager()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return nil
+	}
+	agent, err := manager.GuestAgent(name)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return nil
+	}
+	return agent
+}
+
+var guestPingCmd = &cobra.Command{
+	Use:   "ping [name]",
+	Short: "Check that the guest agent responds",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		agent := guestAgent(args[0])
+		if agent == nil {
+			return
+		}
+		if err := agent.Ping(); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ Guest agent of %s is responding.\n", args[0])
+	},
+}
+
+var guestInfoCmd = &cobra.Command{
+	Use:   "info [name]",
+	Short: "Show the guest's operating system",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		agent := guestAgent(args[0])
+		if agent == nil {
+			return
+		}
+		info, err := agent.OSInfo()
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		hostname, _ := agent.HostName()
+		fmt.Printf("Guest Info: %s\n", args[0])
+		fmt.Printf("  OS:       %s\n", orDefault(info.PrettyName, info.Name))
+		fmt.Printf("  Kernel:   %s %s\n", info.KernelRelease, info.KernelVersion)
+		fmt.Printf("  Machine:  %s\n", info.Machine)
+		if hostname != "" {
+			fmt.Printf("  Hostname: %s\n", hostname)
+		}
+	},
+}
+
+var guestNetworkCmd = &cobra.Command{
+	Use:   "network [name]",
+	Short: "Show the guest's network interfaces and IP addresses",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		agent := guestAgent(args[0])
+		if agent == nil {
+			return
+		}
+		ifaces, err := agent.NetworkInterfaces()
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		for _, iface := range ifaces {
+			fmt.Printf("%s", iface.Name)
+			if iface.HardwareAddress != "" {
+				fmt.Printf(" (%s)", iface.HardwareAddress)
+			}
+			fmt.Println()
+			for _, addr := range iface.IPAddresses {
+				fmt.Printf("  %s/%d\n", addr.Address, addr.Prefix)
+			}
+		}
+	},
+}
+
+var guestShutdownCmd = &cobra.Command{
+	Use:   "shutdown [name]",
+	Short: "Ask the guest OS to shut down",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		mode, _ := cmd.Flags().GetString("mode")
+		agent := guestAgent(args[0])
+		if agent == nil {
+			return
+		}
+		if err := agent.Shutdown(mode); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("🛑 Asked %s to %s.\n", args[0], mode)
+	},
+}
+
+var guestFreezeCmd = &cobra.Command{
+	Use:   "freeze [name]",
+	Short: "Flush and freeze the guest's filesystems, e.g. before a disk snapshot",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		agent := guestAgent(args[0])
+		if agent == nil {
+			return
+		}
+		n, err := agent.FSFreeze()
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("🧊 Froze %d filesystems, run \"vmtool guest thaw %s\" when done.\n", n, args[0])
+	},
+}
+
+var guestThawCmd = &cobra.Command{
+	Use:   "thaw [name]",
+	Short: "Thaw the guest's filesystems",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		agent := guestAgent(args[0])
+		if agent == nil {
+			return
+		}
+		n, err := agent.FSThaw()
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ Thawed %d filesystems.\n", n)
+	},
+}
+
+var guestSyncTimeCmd = &cobra.Command{
+	Use:   "sync-time [name]",
+	Short: "Set the guest clock to the host's",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := manager.GuestSyncTime(args[0]); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("🕒 Guest clock of %s set.\n", args[0])
+	},
+}
+
+var guestExecCmd = &cobra.Command{
+	Use:   "exec [name] -- [program] [args...]",
+	Short: "Run a program in the guest",
+	Long: `Run a program in the guest and print its output.
+
+The program is started by the guest agent without a shell, so give its full
+path or run a shell explicitly, e.g. vmtool guest exec my-vm -- /bin/sh -c 'uname -a'.
+vmtool exits with the program's exit code.`,
+	Args:          cobra.MinimumNArgs(2),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		env, _ := cmd.Flags().GetStringArray("env")
+		stdin, _ := cmd.Flags().GetBool("stdin")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		req := qemu.GuestExecRequest{Path: args[1], Args: args[2:], Env: env}
+		for _, e := range env {
+			if !strings.Contains(e, "=") {
+				return fmt.Errorf("❌ Error: --env %q must be NAME=VALUE", e)
+			}
+		}
+		if stdin {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("❌ Error reading stdin: %v", err)
+			}
+			req.Input = data
+		}
+		manager, err := newManager()
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		res, err := manager.GuestExec(ctx, name, req)
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		os.Stdout.Write(res.Stdout)
+		os.Stderr.Write(res.Stderr)
+		if res.Truncated {
+			fmt.Fprintln(os.Stderr, "⚠️  Output was truncated by the guest agent.")
+		}
+		if res.Signal != 0 {
+			fmt.Fprintf(os.Stderr, "❌ Killed by signal %d\n", res.Signal)
+			os.Exit(128 + res.Signal)
+		}
+		if res.ExitCode != 0 {
+			os.Exit(res.ExitCode)
+		}
+		return nil
+	},
+}
+
+func init() {
+	guestShutdownCmd.Flags().String("mode", "powerdown", "powerdown, halt or reboot")
+	guestExecCmd.Flags().StringArrayP("env", "e", nil, "Set an environment variable, NAME=VALUE")
+	guestExecCmd.Flags().BoolP("stdin", "i", false, "Pass standard input to the program")
+	guestExecCmd.Flags().Duration("timeout", 0, "Give up waiting after this long (default: no limit)")
+
+	guestCmd.AddCommand(guestPingCmd)
+	guestCmd.AddCommand(guestInfoCmd)
+	guestCmd.AddCommand(guestNetworkCmd)
+	guestCmd.AddCommand(guestShutdownCmd)
+	guestCmd.AddCommand(guestFreezeCmd)
+	guestCmd.AddCommand(guestThawCmd)
+	guestCmd.AddCommand(guestSyncTimeCmd)
+	guestCmd.AddCommand(guestExecCmd)
+	rootCmd.AddCommand(guestCmd)
+}
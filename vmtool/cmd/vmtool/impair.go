@@ -0,0 +1,110 @@
+package vmtool
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+var impairCmd = &cobra.Command{
+	Use:   "impair [name]",
+	Short: "Simulate latency, loss, bandwidth limits or partitions on a NIC",
+	Long: `Simulate degraded links on a NIC attached to a virtual network.
+
+Without any settings the current impairment is shown. Flags that are not given
+keep their current value. Changes apply to the running network immediately and
+last until it restarts, unless --save writes them to the VM configuration.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		nic, _ := cmd.Flags().GetInt("nic")
+		clear, _ := cmd.Flags().GetBool("clear")
+		reset, _ := cmd.Flags().GetBool("reset")
+		save, _ := cmd.Flags().GetBool("save")
+
+		if reset && save {
+			fmt.Println("❌ --reset only drops runtime changes, use --clear --save to remove a saved impairment.")
+			return
+		}
+
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		current, err := manager.GetImpairment(name, nic)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+
+		flags := cmd.Flags()
+		changed := flags.Changed("latency") || flags.Changed("jitter") || flags.Changed("loss") ||
+			flags.Changed("bandwidth") || flags.Changed("partition")
+		if !changed && !clear && !reset {
+			printImpairment(name, nic, current)
+			return
+		}
+
+		var imp *config.ImpairmentConfig
+		if !reset {
+			imp = &config.ImpairmentConfig{}
+			if current != nil && !clear {
+				*imp = *current
+			}
+			if flags.Changed("latency") {
+				imp.Latency, _ = flags.GetString("latency")
+			}
+			if flags.Changed("jitter") {
+				imp.Jitter, _ = flags.GetString("jitter")
+			}
+			if flags.Changed("loss") {
+				imp.Loss, _ = flags.GetFloat64("loss")
+			}
+			if flags.Changed("bandwidth") {
+				imp.Bandwidth, _ = flags.GetString("bandwidth")
+			}
+			if flags.Changed("partition") {
+				imp.Partition, _ = flags.GetString("partition")
+			}
+		}
+
+		if err := manager.SetImpairment(name, nic, imp, save); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		if imp, err = manager.GetImpairment(name, nic); err == nil {
+			printImpairment(name, nic, imp)
+		}
+		if save {
+			fmt.Println("💾 Saved to the VM configuration.")
+		}
+	},
+}
+
+func printImpairment(name string, nic int, imp *config.ImpairmentConfig) {
+	if imp == nil || *imp == (config.ImpairmentConfig{}) {
+		fmt.Printf("✅ %s nic %d: no impairment\n", name, nic)
+		return
+	}
+	fmt.Printf("📉 %s nic %d:\n", name, nic)
+	fmt.Printf("  Latency:   %s\n", orDefault(imp.Latency, "-"))
+	fmt.Printf("  Jitter:    %s\n", orDefault(imp.Jitter, "-"))
+	fmt.Printf("  Loss:      %g%%\n", imp.Loss)
+	fmt.Printf("  Bandwidth: %s\n", orDefault(imp.Bandwidth, "unlimited"))
+	fmt.Printf("  Partition: %s\n", orDefault(imp.Partition, "-"))
+}
+
+func init() {
+	impairCmd.Flags().Int("nic", 0, "Index of the NIC")
+	impairCmd.Flags().String("latency", "", "Added one-way delay, e.g. 50ms")
+	impairCmd.Flags().String("jitter", "", "Random variation of the delay, e.g. 10ms")
+	impairCmd.Flags().Float64("loss", 0, "Percentage of frames dropped")
+	impairCmd.Flags().String("bandwidth", "", "Rate limit, e.g. 10mbit or 512kbit")
+	impairCmd.Flags().String("partition", "", "Only NICs in the same partition can reach each other")
+	impairCmd.Flags().Bool("clear", false, "Remove all impairment")
+	impairCmd.Flags().Bool("reset", false, "Drop runtime changes and go back to the saved configuration")
+	impairCmd.Flags().Bool("save", false, "Also save the change to the VM configuration")
+	rootCmd.AddCommand(impairCmd)
+}
@@ -0,0 +1,152 @@
+package vmtool
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/keymap"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+var typeCmd = &cobra.Command{
+	Use:   "type [name] [text]",
+	Short: "Type text on a running VM's keyboard",
+	Long: `Type text on a running VM's keyboard.
+
+Characters are translated to key presses for the guest's keyboard layout
+(` + strings.Join(keymap.Layouts(), ", ") + `). Use \n in the text or --enter to press Return.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, text := args[0], strings.ReplaceAll(args[1], `\n`, "\n")
+		layout, _ := cmd.Flags().GetString("layout")
+		delay, _ := cmd.Flags().GetDuration("delay")
+		enter, _ := cmd.Flags().GetBool("enter")
+		if enter {
+			text += "\n"
+		}
+
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := manager.TypeText(name, text, layout, delay); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("⌨️  Typed %d characters on %s\n", len([]rune(text)), name)
+	},
+}
+
+var keyCmd = &cobra.Command{
+	Use:   "key [name] [keys...]",
+	Short: "Press key combinations such as ctrl-alt-del on a running VM",
+	Long: `Press key combinations on a running VM, one after the other.
+
+Keys are QEMU key names joined with - or +, e.g. ctrl-alt-del, alt-f2,
+shift-tab or ret. Common aliases like enter, esc, del and win are accepted.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		hold, _ := cmd.Flags().GetDuration("hold")
+		delay, _ := cmd.Flags().GetDuration("delay")
+
+		var combos []keymap.Combo
+		for _, arg := range args[1:] {
+			combo, err := keymap.ParseCombo(arg)
+			if err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+				return
+			}
+			combos = append(combos, combo)
+		}
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := manager.SendKeys(name, combos, hold, delay); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("⌨️  Sent %s to %s\n", strings.Join(args[1:], " "), name)
+	},
+}
+
+var mouseCmd = &cobra.Command{
+	Use:   "mouse [name] [action] [args...]",
+	Short: "Move the pointer, click or scroll on a running VM",
+	Long: `Move the pointer, click or scroll on a running VM.
+
+  vmtool mouse <vm> move X Y         move to screen pixel X,Y (needs display.tablet)
+  vmtool mouse <vm> move DX DY --relative
+  vmtool mouse <vm> click [button]   left, right, middle, side or extra
+  vmtool mouse <vm> double-click [button]
+  vmtool mouse <vm> down|up [button]
+  vmtool mouse <vm> scroll up|down
+
+--at X,Y moves the pointer before clicking.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, action := args[0], args[1]
+		relative, _ := cmd.Flags().GetBool("relative")
+		at, _ := cmd.Flags().GetString("at")
+		amount, _ := cmd.Flags().GetInt("amount")
+
+		var actions []vm.MouseAction
+		if at != "" {
+			xs, ys, ok := strings.Cut(at, ",")
+			x, errX := strconv.Atoi(xs)
+			y, errY := strconv.Atoi(ys)
+			if !ok || errX != nil || errY != nil {
+				fmt.Printf("❌ Invalid --at %q, expected X,Y\n", at)
+				return
+			}
+			actions = append(actions, vm.MouseAction{Action: "move", X: x, Y: y})
+		}
+		a := vm.MouseAction{Action: action, Relative: relative, Amount: amount}
+		if action == "move" {
+			if len(args) != 4 {
+				fmt.Println("❌ move needs X and Y")
+				return
+			}
+			var errX, errY error
+			a.X, errX = strconv.Atoi(args[2])
+			a.Y, errY = strconv.Atoi(args[3])
+			if errX != nil || errY != nil {
+				fmt.Println("❌ X and Y must be numbers")
+				return
+			}
+		} else if len(args) > 2 {
+			a.Button = args[2]
+		}
+		actions = append(actions, a)
+
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := manager.Mouse(name, actions); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("🖱️  Sent %s to %s\n", action, name)
+	},
+}
+
+func init() {
+	typeCmd.Flags().String("layout", "us", "Guest keyboard layout")
+	typeCmd.Flags().Duration("delay", vm.DefaultKeyDelay, "Pause between keystrokes")
+	typeCmd.Flags().Bool("enter", false, "Press Return after the text")
+	keyCmd.Flags().Duration("hold", 0, "How long to hold each combination (default QEMU's 100ms)")
+	keyCmd.Flags().Duration("delay", vm.DefaultKeyDelay, "Pause between combinations")
+	mouseCmd.Flags().Bool("relative", false, "Move relative to the current position")
+	mouseCmd.Flags().String("at", "", "Move to X,Y before the action")
+	mouseCmd.Flags().Int("amount", 1, "Scroll steps")
+	rootCmd.AddCommand(typeCmd)
+	rootCmd.AddCommand(keyCmd)
+	rootCmd.AddCommand(mouseCmd)
+}
@@ -0,0 +1,70 @@
+package vmtool
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/logs"
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [name]",
+	Short: "Show a VM's QEMU and serial console logs",
+	Long: `Show a VM's logs.
+
+Two logs are kept per VM: QEMU's own output, together with vmtool's notes on
+starting and stopping it, and everything the guest wrote to its serial console.
+Both are shown merged by time unless --source picks one. Logs are rotated by
+size, see logs.max_size and logs.max_files in config.yaml.`,
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		follow, _ := cmd.Flags().GetBool("follow")
+		since, _ := cmd.Flags().GetString("since")
+		tail, _ := cmd.Flags().GetInt("tail")
+		source, _ := cmd.Flags().GetString("source")
+		timestamps, _ := cmd.Flags().GetBool("timestamps")
+
+		opts := logs.Options{Tail: tail, Follow: follow}
+		var err error
+		if opts.Sources, err = logs.ParseSources(source); err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		if since != "" {
+			if opts.Since, err = logs.ParseSince(since, time.Now()); err != nil {
+				return fmt.Errorf("❌ Error: %v", err)
+			}
+		}
+		manager, err := newManager()
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		merged := len(opts.Sources) > 1
+		err = manager.Logs(ctx, name, opts, func(e logs.Entry) error {
+			_, err := fmt.Fprintln(os.Stdout, e.Format(timestamps, merged))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	logsCmd.Flags().BoolP("follow", "f", false, "Keep printing new lines as they are written")
+	logsCmd.Flags().String("since", "", "Only show lines since a time (e.g. 2024-01-02T15:04:05Z) or for a duration (e.g. 10m)")
+	logsCmd.Flags().IntP("tail", "n", 0, "Only show the last lines, all when 0")
+	logsCmd.Flags().String("source", "all", "Log to show: qemu, serial or all")
+	logsCmd.Flags().BoolP("timestamps", "t", false, "Show the time of each line")
+	rootCmd.AddCommand(logsCmd)
+}
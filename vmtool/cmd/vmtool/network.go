@@ -0,0 +1,310 @@
+package vmtool
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/network"
+)
+
+var networkCmd = &cobra.Command{
+	Use:   "network",
+	Short: "Manage virtual networks shared between VMs",
+}
+
+var networkCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create a new virtual network",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		store, err := newNetworkStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if _, ok := store.GetNetwork(name); ok {
+			fmt.Printf("❌ Network %s already exists.\n", name)
+			return
+		}
+
+		subnet, _ := cmd.Flags().GetString("subnet")
+		domain, _ := cmd.Flags().GetString("domain")
+		switch subnet {
+		case "auto":
+			subnet = pickSubnet(store)
+		case "none":
+			subnet = ""
+		}
+		cfg := &config.VirtualNetworkConfig{
+			Name:   name,
+			UUID:   uuid.New().String(),
+			Subnet: subnet,
+		}
+		if subnet != "" {
+			cfg.Gateway, _ = cmd.Flags().GetString("gateway")
+			cfg.Domain = domain
+		}
+		if err := store.SaveNetwork(cfg); err != nil {
+			fmt.Printf("❌ Error saving network: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ Network %s created.\n", name)
+		if cfg.Subnet != "" {
+			fmt.Printf("🌐 DHCP and DNS on %s, VMs resolve as <vmname>.%s\n", cfg.Subnet, orDefault(cfg.Domain, "vm"))
+		}
+		fmt.Printf("🔌 Attach VMs with `network: %s` in their configuration.\n", name)
+	},
+}
+
+var networkListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List virtual networks",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := newNetworkStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("%-20s %-10s %s\n", "NAME", "STATUS", "VMS")
+		for _, n := range store.ListNetworks() {
+			status := "inactive"
+			if network.IsRunning(n.Name) {
+				status = "active"
+			}
+			fmt.Printf("%-20s %-10s %d\n", n.Name, status, len(manager.AttachedVMs(n.Name)))
+		}
+	},
+}
+
+var networkInspectCmd = &cobra.Command{
+	Use:   "inspect [name]",
+	Short: "Show details of a virtual network",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		store, err := newNetworkStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		cfg, ok := store.GetNetwork(name)
+		if !ok {
+			fmt.Printf("❌ Network %s not found.\n", name)
+			return
+		}
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+
+		fmt.Printf("Network Info: %s\n", cfg.Name)
+		fmt.Printf("  UUID:    %s\n", cfg.UUID)
+		fmt.Printf("  Socket:  %s\n", network.SocketPath(cfg.Name))
+		if cfg.Subnet != "" {
+			fmt.Printf("  Subnet:  %s\n", cfg.Subnet)
+			fmt.Printf("  Domain:  %s\n", orDefault(cfg.Domain, "vm"))
+		} else {
+			fmt.Println("  DHCP:    disabled")
+		}
+
+		attached := manager.AttachedVMs(cfg.Name)
+		fmt.Printf("  VMs:     %d\n", len(attached))
+		for _, v := range attached {
+			for i, nic := range v.NICs() {
+				if nic.IsVirtual() && nic.Network == cfg.Name {
+					fmt.Printf("    - %s nic %d (%s) %s", v.Name, i, v.MACAddress(i), manager.GetStatus(v.Name))
+					if nic.IPAddress != "" {
+						fmt.Printf(" reserved %s", nic.IPAddress)
+					}
+					fmt.Println()
+				}
+			}
+		}
+
+		state, err := network.Status(cfg.Name)
+		if err != nil {
+			fmt.Println("  Switch:  inactive")
+			return
+		}
+		fmt.Printf("  Switch:  active (pid %d)\n", state.PID)
+		if state.Gateway != "" {
+			fmt.Printf("  Gateway: %s\n", state.Gateway)
+		}
+		fmt.Printf("  Ports:   %d\n", countVMPorts(state.Ports))
+		for _, p := range state.Ports {
+			if p.Local {
+				continue
+			}
+			fmt.Printf("    - port %d: macs=%v rx=%d tx=%d dropped=%d lost=%d since %s\n",
+				p.ID, p.MACs, p.RxFrames, p.TxFrames, p.Dropped, p.Lost, p.ConnectedAt.Format("2006-01-02 15:04:05"))
+			if imp := p.Impairment; imp != nil {
+				fmt.Printf("      impairment: latency=%s jitter=%s loss=%g%% bandwidth=%s partition=%s\n",
+					orDefault(imp.Latency, "0"), orDefault(imp.Jitter, "0"), imp.Loss, orDefault(imp.Bandwidth, "unlimited"), orDefault(imp.Partition, "-"))
+			}
+		}
+	},
+}
+
+var networkDeleteCmd = &cobra.Command{
+	Use:   "delete [name]",
+	Short: "Delete a virtual network",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		force, _ := cmd.Flags().GetBool("force")
+		store, err := newNetworkStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if _, ok := store.GetNetwork(name); !ok {
+			fmt.Printf("❌ Network %s not found.\n", name)
+			return
+		}
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if attached := manager.AttachedVMs(name); len(attached) > 0 && !force {
+			fmt.Printf("❌ Network %s is used by %d VM(s), detach them first or use --force.\n", name, len(attached))
+			return
+		}
+
+		fmt.Printf("🗑️ Deleting network: %s...\n", name)
+		if err := network.Stop(name); err != nil {
+			fmt.Printf("❌ Error stopping network: %v\n", err)
+			return
+		}
+		if err := store.DeleteNetwork(name); err != nil {
+			fmt.Printf("❌ Error deleting network: %v\n", err)
+			return
+		}
+		os.RemoveAll(network.RuntimeDir(name))
+		fmt.Printf("✅ Network %s deleted.\n", name)
+	},
+}
+
+var networkLeasesCmd = &cobra.Command{
+	Use:   "leases [name]",
+	Short: "Show DHCP leases of a virtual network",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		leases, err := network.Leases(name)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("%-18s %-16s %-20s %s\n", "MAC", "IP", "HOSTNAME", "EXPIRES")
+		for _, l := range leases {
+			expires := "-"
+			if !l.Expires.IsZero() {
+				expires = l.Expires.Format("2006-01-02 15:04:05")
+			}
+			if l.Static {
+				expires += " (static)"
+			}
+			fmt.Printf("%-18s %-16s %-20s %s\n", l.MAC, l.IP, l.Hostname, expires)
+		}
+	},
+}
+
+// networkRunCmd is the switch process started on demand by the manager.
+var networkRunCmd = &cobra.Command{
+	Use:    "run [name]",
+	Short:  "Run the switch of a virtual network in the foreground",
+	Args:   cobra.ExactArgs(1),
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		store, err := newNetworkStore()
+		if err != nil {
+			return err
+		}
+		cfg, ok := store.GetNetwork(name)
+		if !ok {
+			return fmt.Errorf("network %s not found", name)
+		}
+		// VM configs are re-read so that VMs created after the switch started get their reservations.
+		hosts := func() []network.Host {
+			manager, err := newManager()
+			if err != nil {
+				return nil
+			}
+			return manager.NetworkHosts(name)
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return network.Run(ctx, cfg, hosts)
+	},
+}
+
+// newNetworkStore opens the networks directory from config.yaml, the same one `vmtool serve` uses.
+func newNetworkStore() (*network.Store, error) {
+	appCfg, err := config.LoadAppConfig()
+	if err != nil {
+		return nil, err
+	}
+	return network.NewStore(appCfg.Paths.Networks)
+}
+
+// pickSubnet returns the first 10.77.x.0/24 not used by another network.
+func pickSubnet(store *network.Store) string {
+	used := make(map[string]bool)
+	for _, n := range store.ListNetworks() {
+		used[n.Subnet] = true
+	}
+	for i := 0; i < 256; i++ {
+		subnet := fmt.Sprintf("10.77.%d.0/24", i)
+		if !used[subnet] {
+			return subnet
+		}
+	}
+	return ""
+}
+
+func countVMPorts(ports []network.PortStatus) int {
+	n := 0
+	for _, p := range ports {
+		if !p.Local {
+			n++
+		}
+	}
+	return n
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func init() {
+	networkCreateCmd.Flags().String("subnet", "auto", "IPv4 subnet for the built-in DHCP and DNS server, or \"none\" to disable it")
+	networkCreateCmd.Flags().String("gateway", "", "Address of vmtool on the network (default: first host address)")
+	networkCreateCmd.Flags().String("domain", "", "DNS domain for VM names (default: vm)")
+	networkDeleteCmd.Flags().Bool("force", false, "Delete even if VMs are attached")
+
+	networkCmd.AddCommand(networkCreateCmd)
+	networkCmd.AddCommand(networkListCmd)
+	networkCmd.AddCommand(networkInspectCmd)
+	networkCmd.AddCommand(networkDeleteCmd)
+	networkCmd.AddCommand(networkLeasesCmd)
+	networkCmd.AddCommand(networkRunCmd)
+	rootCmd.AddCommand(networkCmd)
+}
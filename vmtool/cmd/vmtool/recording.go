@@ -0,0 +1,142 @@
+package vmtool
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/recording"
+)
+
+var recordingCmd = &cobra.Command{
+	Use:   "recording",
+	Short: "Manage recordings of VNC sessions",
+}
+
+var recordingListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recorded VNC sessions",
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName, _ := cmd.Flags().GetString("vm")
+		store, err := newRecordingStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		list, err := store.List(vmName)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("%-36s %-20s %-20s %-10s %-10s %s\n", "ID", "VM", "STARTED", "DURATION", "MODE", "VIEWER")
+		for _, r := range list {
+			duration := "recording"
+			if !r.EndedAt.IsZero() {
+				duration = r.Duration.Round(time.Second).String()
+			}
+			fmt.Printf("%-36s %-20s %-20s %-10s %-10s %s\n", r.ID, r.VM, r.StartedAt.Local().Format("2006-01-02 15:04:05"), duration, r.Mode, r.RemoteAddr)
+		}
+	},
+}
+
+var recordingExportCmd = &cobra.Command{
+	Use:   "export [recording-id]",
+	Short: "Export a recording for noVNC's playback tools or as raw data",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+		if format != "novnc" && format != "raw" {
+			fmt.Println("❌ Format must be novnc or raw.")
+			return
+		}
+		store, err := newRecordingStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		rd, err := store.Open(id)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		defer rd.Close()
+
+		if output == "" {
+			output = id + ".js"
+			if format == "raw" {
+				output = id + ".rec"
+			}
+		}
+		f, err := os.Create(output)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		defer f.Close()
+
+		if format == "raw" {
+			err = copyRecording(f, store, id)
+		} else {
+			err = recording.ExportNoVNC(f, rd)
+		}
+		if err != nil {
+			fmt.Printf("❌ Error exporting recording: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ Recording exported to %s\n", output)
+	},
+}
+
+var recordingDeleteCmd = &cobra.Command{
+	Use:   "delete [recording-id]",
+	Short: "Delete a recording",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := newRecordingStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := store.Delete(args[0]); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ Recording %s deleted.\n", args[0])
+	},
+}
+
+func copyRecording(w io.Writer, store *recording.Store, id string) error {
+	f, err := os.Open(store.DataPath(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// newRecordingStore opens the recordings in the data directory config.yaml points to, like the server does.
+func newRecordingStore() (*recording.Store, error) {
+	appCfg, err := config.LoadAppConfig()
+	if err != nil {
+		return nil, err
+	}
+	return recording.NewStore(filepath.Join(appCfg.DataDir(), "recordings"))
+}
+
+func init() {
+	recordingListCmd.Flags().String("vm", "", "Only list recordings of this VM")
+	recordingExportCmd.Flags().String("format", "novnc", "Export format: novnc (VNC_frame_data) or raw")
+	recordingExportCmd.Flags().StringP("output", "o", "", "Output file (default <id>.js or <id>.rec)")
+
+	recordingCmd.AddCommand(recordingListCmd)
+	recordingCmd.AddCommand(recordingExportCmd)
+	recordingCmd.AddCommand(recordingDeleteCmd)
+	rootCmd.AddCommand(recordingCmd)
+}
@@ -0,0 +1,56 @@
+package vmtool
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+var serialCmd = &cobra.Command{
+	Use:   "serial [name] [port]",
+	Short: "List a VM's serial ports or read a ring buffer port",
+	Long: `List a VM's serial ports and where to reach them.
+
+serial0 is the built-in console, see "vmtool console". Further ports are
+configured under serials in the VM's configuration. Given a port id, the
+output buffered by a ring backend port since the last read is printed.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if len(args) == 2 {
+			data, err := manager.ReadSerial(name, args[1])
+			if err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+				return
+			}
+			fmt.Print(data)
+			return
+		}
+		ports, err := manager.SerialPorts(name)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		for _, p := range ports {
+			fmt.Println(describeSerialPort(p))
+		}
+	},
+}
+
+func describeSerialPort(p vm.SerialPort) string {
+	target := p.Target
+	if target == "" && p.Backend == "pty" {
+		target = "allocated when the VM starts"
+	}
+	return fmt.Sprintf("%s (%s, %s): %s", p.ID, p.Device, p.Backend, target)
+}
+
+func init() {
+	rootCmd.AddCommand(serialCmd)
+}
@@ -0,0 +1,122 @@
+package vmtool
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/share"
+)
+
+var shareCmd = &cobra.Command{
+	Use:   "share [vm-name]",
+	Short: "Create a signed, expiring link to a VM's console",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		viewOnly, _ := cmd.Flags().GetBool("view-only")
+		baseURL, _ := cmd.Flags().GetString("base-url")
+
+		manager, err := newManager()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if _, ok := manager.GetVM(name); !ok {
+			fmt.Printf("❌ VM %s not found.\n", name)
+			return
+		}
+		if baseURL == "" {
+			appCfg, err := config.LoadAppConfig()
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			baseURL = fmt.Sprintf("http://%s:%d", appCfg.Server.Host, appCfg.Server.Port)
+		}
+
+		store, err := newShareStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		sh, token, err := store.Create(name, ttl, viewOnly)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		access := "full control"
+		if sh.ViewOnly {
+			access = "view only"
+		}
+		fmt.Printf("🔗 Share %s for %s (%s), expires %s:\n", sh.ID, name, access, sh.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+		fmt.Println(share.Link(baseURL, sh, token))
+	},
+}
+
+var shareListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List active share links",
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName, _ := cmd.Flags().GetString("vm")
+		store, err := newShareStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		list, err := store.List(vmName)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("%-36s %-20s %-10s %s\n", "ID", "VM", "ACCESS", "EXPIRES")
+		for _, sh := range list {
+			access := "control"
+			if sh.ViewOnly {
+				access = "view"
+			}
+			fmt.Printf("%-36s %-20s %-10s %s\n", sh.ID, sh.VM, access, sh.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+		}
+	},
+}
+
+var shareRevokeCmd = &cobra.Command{
+	Use:   "revoke [share-id]",
+	Short: "Revoke a share link",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := newShareStore()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := store.Revoke(args[0]); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ Share %s revoked.\n", args[0])
+	},
+}
+
+// newShareStore opens the share links in the data directory config.yaml points to, like the server does.
+func newShareStore() (*share.Store, error) {
+	appCfg, err := config.LoadAppConfig()
+	if err != nil {
+		return nil, err
+	}
+	return share.NewStore(filepath.Join(appCfg.DataDir(), "shares"))
+}
+
+func init() {
+	shareCmd.Flags().Duration("ttl", time.Hour, "How long the link stays valid")
+	shareCmd.Flags().Bool("view-only", false, "Only allow watching, no keyboard or mouse")
+	shareCmd.Flags().String("base-url", "", "Server URL used in the link (default from the server config)")
+	shareListCmd.Flags().String("vm", "", "Only list shares of this VM")
+
+	shareCmd.AddCommand(shareListCmd)
+	shareCmd.AddCommand(shareRevokeCmd)
+	rootCmd.AddCommand(shareCmd)
+}
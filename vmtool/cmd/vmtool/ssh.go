@@ -0,0 +1,112 @@
+package vmtool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var sshCmd = &cobra.Command{
+	Use:   "ssh [name] [-- command...]",
+	Short: "SSH into a running VM",
+	Long: `Open an SSH session to a running VM with the system ssh client.
+
+The route is found automatically: a port forward to the guest's SSH port on a
+user mode NIC, otherwise a temporary forward added for the session, or, for a
+bridged NIC, the address reported by the guest agent. The login user, key and
+guest port come from the VM's ssh configuration unless given here.`,
+	Example: `  vmtool ssh my-ubuntu
+  vmtool ssh my-ubuntu -u root -i ~/.ssh/lab -- uptime`,
+	Args:          cobra.MinimumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		user, _ := cmd.Flags().GetString("user")
+		identity, _ := cmd.Flags().GetString("identity")
+		options, _ := cmd.Flags().GetStringArray("option")
+
+		manager, err := newManager()
+		if err != nil {
+			return err
+		}
+		cfg, ok := manager.GetVM(name)
+		if !ok {
+			return fmt.Errorf("❌ VM %s not found", name)
+		}
+		if user == "" {
+			user = cfg.SSH.User
+		}
+		if identity == "" {
+			identity = cfg.SSH.IdentityFile
+		}
+		sshPath, err := exec.LookPath("ssh")
+		if err != nil {
+			return fmt.Errorf("❌ Error: no ssh client found in PATH")
+		}
+
+		route, err := manager.SSHRoute(name)
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		defer func() {
+			if err := route.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "⚠️  Failed to remove the temporary port forward: %v\n", err)
+			}
+		}()
+
+		// Forwarded ports change between sessions and VMs, so host keys are filed under the VM's name.
+		sshArgs := []string{"-p", strconv.Itoa(route.Port), "-o", "HostKeyAlias=vmtool-" + name}
+		if identity != "" {
+			sshArgs = append(sshArgs, "-i", expandHome(identity))
+		}
+		if user != "" {
+			sshArgs = append(sshArgs, "-l", user)
+		}
+		for _, o := range options {
+			sshArgs = append(sshArgs, "-o", o)
+		}
+		// ssh keeps parsing options after the host unless they end before it.
+		sshArgs = append(sshArgs, "--", route.Host)
+		sshArgs = append(sshArgs, args[1:]...)
+		fmt.Fprintf(os.Stderr, "🔐 Connecting to %s at %s:%d via %s\n", name, route.Host, route.Port, route.Via)
+
+		// ssh handles ctrl-c itself; vmtool stays to remove a temporary forward afterwards.
+		signal.Ignore(os.Interrupt)
+		ssh := exec.Command(sshPath, sshArgs...)
+		ssh.Stdin, ssh.Stdout, ssh.Stderr = os.Stdin, os.Stdout, os.Stderr
+		err = ssh.Run()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			route.Close()
+			os.Exit(exitErr.ExitCode())
+		}
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		return nil
+	},
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~"); ok && (rest == "" || rest[0] == '/' || rest[0] == filepath.Separator) {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+func init() {
+	sshCmd.Flags().StringP("user", "u", "", "Login user (default: ssh.user of the VM)")
+	sshCmd.Flags().StringP("identity", "i", "", "Private key (default: ssh.identity_file of the VM)")
+	sshCmd.Flags().StringArrayP("option", "o", nil, "Extra ssh option, e.g. StrictHostKeyChecking=accept-new")
+	rootCmd.AddCommand(sshCmd)
+}
@@ -0,0 +1,208 @@
+package vmtool
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply -f stack.yaml",
+	Short: "Create, update and delete VMs, networks and disks to match a stack file",
+	Long: `Reconcile VMs, networks and disk images with a stack file.
+
+A stack file names the stack and lists its networks, disks and vms, each
+written like the files of the machines and networks directories. What is
+missing is created, what differs is updated, and the VMs and networks created
+by an earlier apply of the stack but no longer listed are deleted. Disk images
+are created when missing and never changed. Relative paths are relative to the
+stack file.
+
+The plan is shown first and applied after confirmation. Running VMs whose
+update needs a restart are flagged, and only restarted with --restart.`,
+	Example: `  vmtool apply -f lab.yaml
+  vmtool apply -f lab.yaml --yes --restart`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, _ := cmd.Flags().GetString("file")
+		yes, _ := cmd.Flags().GetBool("yes")
+		opts := vm.StackOptions{}
+		opts.Adopt, _ = cmd.Flags().GetBool("adopt")
+		opts.Restart, _ = cmd.Flags().GetBool("restart")
+		opts.Stop.Timeout, _ = cmd.Flags().GetDuration("timeout")
+
+		stack, err := config.LoadStack(file)
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		manager, err := newManager()
+		if err != nil {
+			return err
+		}
+		plan, err := manager.PlanStack(stack, opts)
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		return applyPlan(cmd, manager, plan, opts, yes, "applied")
+	},
+}
+
+var diffCmd = &cobra.Command{
+	Use:           "diff -f stack.yaml",
+	Short:         "Show what vmtool apply would change",
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, _ := cmd.Flags().GetString("file")
+		adopt, _ := cmd.Flags().GetBool("adopt")
+		stack, err := config.LoadStack(file)
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		manager, err := newManager()
+		if err != nil {
+			return err
+		}
+		plan, err := manager.PlanStack(stack, vm.StackOptions{Adopt: adopt})
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		printPlan(plan)
+		return nil
+	},
+}
+
+var destroyCmd = &cobra.Command{
+	Use:   "destroy -f stack.yaml",
+	Short: "Delete the VMs and networks of a stack",
+	Long: `Delete every VM and network created by applying the stack, stopping running
+VMs first. Disk images are kept unless --disks is given, which deletes those
+listed in the stack file.`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, _ := cmd.Flags().GetString("file")
+		yes, _ := cmd.Flags().GetBool("yes")
+		disks, _ := cmd.Flags().GetBool("disks")
+		opts := vm.StackOptions{}
+		opts.Stop.Timeout, _ = cmd.Flags().GetDuration("timeout")
+
+		stack, err := config.LoadStack(file)
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		manager, err := newManager()
+		if err != nil {
+			return err
+		}
+		var removeDisks []config.DiskConfig
+		if disks {
+			removeDisks = stack.Disks
+		}
+		return applyPlan(cmd, manager, manager.PlanDestroy(stack.Name, removeDisks), opts, yes, "destroyed")
+	},
+}
+
+func applyPlan(cmd *cobra.Command, manager *vm.Manager, plan *vm.StackPlan, opts vm.StackOptions, yes bool, done string) error {
+	printPlan(plan)
+	if len(plan.Changes) == 0 {
+		return nil
+	}
+	if !yes {
+		fmt.Print("Apply these changes? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("Nothing changed.")
+			return nil
+		}
+	}
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := manager.ApplyStack(ctx, plan, opts, func(c vm.Change) {
+		switch {
+		case c.Action == vm.ActionDelete && c.Running:
+			fmt.Printf("🛑 Stopping and deleting %s %s...\n", c.Kind, c.Name)
+		case c.Restart && opts.Restart:
+			fmt.Printf("🔁 Updating and restarting %s %s...\n", c.Kind, c.Name)
+		default:
+			fmt.Printf("⏳ %s %s %s...\n", actionVerb(c.Action), c.Kind, c.Name)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("❌ Error: %v", err)
+	}
+	fmt.Printf("✅ Stack %s %s.\n", plan.Stack, done)
+	return nil
+}
+
+func printPlan(plan *vm.StackPlan) {
+	if len(plan.Changes) == 0 {
+		fmt.Printf("✅ Stack %s is up to date.\n", plan.Stack)
+		return
+	}
+	counts := map[string]int{}
+	restarts := 0
+	fmt.Printf("Plan for stack %s:\n", plan.Stack)
+	for _, c := range plan.Changes {
+		counts[c.Action]++
+		line := fmt.Sprintf("%s %s", c.Kind, c.Name)
+		switch c.Action {
+		case vm.ActionCreate:
+			line = "  + " + line
+		case vm.ActionUpdate:
+			line = "  ~ " + line + " (" + strings.Join(c.Fields, ", ") + ")"
+		case vm.ActionDelete:
+			line = "  - " + line
+			if c.Running {
+				line += " (running, stopped first)"
+			}
+		}
+		if c.Restart {
+			restarts++
+			line += "  ⚠️  restart needed"
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("%d to create, %d to update, %d to delete.\n", counts[vm.ActionCreate], counts[vm.ActionUpdate], counts[vm.ActionDelete])
+	if restarts > 0 {
+		fmt.Printf("%d running VM(s) or network(s) only pick up their update after a restart; apply --restart restarts the VMs.\n", restarts)
+	}
+}
+
+func actionVerb(action string) string {
+	switch action {
+	case vm.ActionCreate:
+		return "Creating"
+	case vm.ActionUpdate:
+		return "Updating"
+	}
+	return "Deleting"
+}
+
+func init() {
+	for _, c := range []*cobra.Command{applyCmd, diffCmd, destroyCmd} {
+		c.Flags().StringP("file", "f", "", "Stack file")
+		c.MarkFlagRequired("file")
+		rootCmd.AddCommand(c)
+	}
+	for _, c := range []*cobra.Command{applyCmd, diffCmd} {
+		c.Flags().Bool("adopt", false, "Manage existing VMs and networks of the same names with the stack")
+	}
+	for _, c := range []*cobra.Command{applyCmd, destroyCmd} {
+		c.Flags().BoolP("yes", "y", false, "Apply without asking")
+		c.Flags().Duration("timeout", vm.DefaultStopTimeout, "How long each guest gets to power off when stopped")
+	}
+	applyCmd.Flags().Bool("restart", false, "Restart running VMs whose update needs it")
+	destroyCmd.Flags().Bool("disks", false, "Also delete the disk images listed in the stack file")
+}
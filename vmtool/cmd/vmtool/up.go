@@ -0,0 +1,96 @@
+package vmtool
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+var upCmd = &cobra.Command{
+	Use:   "up [group|vm]...",
+	Short: "Start groups of VMs in dependency order",
+	Long: `Start every VM of the groups named, or the VMs named, together with the VMs
+they depend on (depends_on). Each VM starts once its dependencies meet their
+conditions: started, running, port or guest-agent. When a VM fails to start,
+those depending on it are skipped.`,
+	Example: `  vmtool up lab
+  vmtool up web`,
+	Args:          cobra.MinimumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manager, err := newManager()
+		if err != nil {
+			return err
+		}
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := manager.Up(ctx, args, printStep); err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		return nil
+	},
+}
+
+var downCmd = &cobra.Command{
+	Use:   "down [group|vm]...",
+	Short: "Stop groups of VMs in reverse dependency order",
+	Long: `Stop every VM of the groups named, or the VMs named, each before the VMs it
+depends on. VMs outside those named are left running. Each VM is stopped like
+with vmtool stop.`,
+	Args:          cobra.MinimumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		force, _ := cmd.Flags().GetBool("force")
+		manager, err := newManager()
+		if err != nil {
+			return err
+		}
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := manager.Down(ctx, args, vm.StopOptions{Timeout: timeout, Force: force}, printStep); err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		return nil
+	},
+}
+
+func printStep(s vm.Step) {
+	switch s.Action {
+	case "waiting":
+		fmt.Printf("⏳ Waiting for %s (%s)...\n", s.VM, s.Detail)
+	case "starting":
+		fmt.Printf("🚀 Starting %s...\n", s.VM)
+	case "started":
+		fmt.Printf("✅ %s started\n", s.VM)
+	case "running":
+		fmt.Printf("✅ %s already running\n", s.VM)
+	case "stopping":
+		fmt.Printf("🛑 Stopping %s...\n", s.VM)
+	case "stopped":
+		fmt.Printf("✅ %s stopped by %s\n", s.VM, s.Detail)
+	case "suspending":
+		fmt.Printf("💾 Saving %s to disk...\n", s.VM)
+	case "suspended":
+		fmt.Printf("✅ %s suspended\n", s.VM)
+	case "not-running":
+		fmt.Printf("✅ %s not running\n", s.VM)
+	case "skipped":
+		fmt.Printf("⏭️  %s skipped: %s\n", s.VM, s.Detail)
+	default:
+		fmt.Printf("❌ %s %s: %s\n", s.VM, s.Action, s.Detail)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(upCmd)
+	downCmd.Flags().Duration("timeout", vm.DefaultStopTimeout, "How long each guest gets to power off before QEMU is quit")
+	downCmd.Flags().Bool("force", false, "Quit QEMU right away, without asking the guests")
+	rootCmd.AddCommand(downCmd)
+}
@@ -0,0 +1,99 @@
+package vmtool
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+var waitScreenCmd = &cobra.Command{
+	Use:   "wait-screen [name]",
+	Short: "Wait until a reference image appears on a VM's display",
+	Long: `Wait until a reference image appears on a VM's display.
+
+The display is captured every --interval and searched for the --template PNG,
+which is typically cut from an earlier "vmtool screenshot". Transparent pixels
+in the template are ignored. The command exits with an error if the template
+hasn't appeared within --timeout, so it can drive installers from CI scripts
+together with "vmtool type" and "vmtool key".`,
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		templatePath, _ := cmd.Flags().GetString("template")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		tolerance, _ := cmd.Flags().GetFloat64("tolerance")
+		regionFlag, _ := cmd.Flags().GetString("region")
+
+		tmpl, err := loadPNG(templatePath)
+		if err != nil {
+			return fmt.Errorf("❌ Error reading template: %v", err)
+		}
+		var region image.Rectangle
+		if regionFlag != "" {
+			if region, err = parseRegion(regionFlag); err != nil {
+				return fmt.Errorf("❌ Error: %v", err)
+			}
+		}
+		manager, err := newManager()
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+		defer cancel()
+		fmt.Printf("⏳ Waiting up to %s for %s on %s...\n", timeout, templatePath, name)
+		match, err := manager.WaitScreen(ctx, name, tmpl, vm.MatchOptions{Region: region, Tolerance: tolerance, Interval: interval})
+		if err != nil {
+			return fmt.Errorf("❌ Error: %v", err)
+		}
+		fmt.Printf("✅ Matched at %d,%d (difference %.3f) after %s\n", match.X, match.Y, match.Score, match.Elapsed.Round(time.Millisecond))
+		return nil
+	},
+}
+
+func loadPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// parseRegion reads x,y,width,height.
+func parseRegion(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("region must be x,y,width,height")
+	}
+	var v [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 0 {
+			return image.Rectangle{}, fmt.Errorf("region must be x,y,width,height")
+		}
+		v[i] = n
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+func init() {
+	waitScreenCmd.Flags().String("template", "", "PNG to look for")
+	waitScreenCmd.Flags().Duration("timeout", 5*time.Minute, "Give up after this long")
+	waitScreenCmd.Flags().Duration("interval", vm.DefaultMatchInterval, "Time between screen captures")
+	waitScreenCmd.Flags().Float64("tolerance", vm.DefaultMatchTolerance, "Accepted mean colour difference, 0 to 1")
+	waitScreenCmd.Flags().String("region", "", "Only search x,y,width,height of the screen")
+	waitScreenCmd.MarkFlagRequired("template")
+	rootCmd.AddCommand(waitScreenCmd)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/utmapp/vmtool/pkg/logs"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+// handleEvents returns the daemon's events as a JSON array. ?vm= picks one VM's, ?since= and ?tail=
+// limit the history and ?follow=true streams them instead, one JSON object per line.
+func (s *Server) handleEvents(c *gin.Context) {
+	opts := vm.EventOptions{VM: c.Query("vm"), Follow: c.Query("follow") == "true"}
+	var err error
+	if v := c.Query("since"); v != "" {
+		if opts.Since, err = logs.ParseSince(v, time.Now()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if v := c.Query("tail"); v != "" {
+		if opts.Tail, err = strconv.Atoi(v); err != nil || opts.Tail < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tail"})
+			return
+		}
+	}
+
+	if !opts.Follow {
+		events := []vm.Event{}
+		err := s.manager.Events(c.Request.Context(), opts, func(e vm.Event) error {
+			events = append(events, e)
+			return nil
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, events)
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+	enc := json.NewEncoder(c.Writer)
+	ctx, cancel := s.streamContext(c)
+	defer cancel()
+	err = s.manager.Events(ctx, opts, func(e vm.Event) error {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		log.Printf("Event stream ended: %v", err)
+	}
+}
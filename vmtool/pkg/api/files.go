@@ -0,0 +1,129 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+// handleDownloadFile streams a file out of the guest. A "Range: bytes=N-" header resumes from N.
+// HEAD only reports the size, and X-File-Mode the permissions where the guest can tell.
+func (s *Server) handleDownloadFile(c *gin.Context) {
+	name, path := c.Param("name"), c.Query("path")
+	if path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is required"})
+		return
+	}
+	if _, ok := s.guestAgent(c); !ok {
+		return
+	}
+	size, err := s.manager.GuestFileSize(name, path)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if size < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%s not found in the guest", path)})
+		return
+	}
+
+	var offset int64
+	status := http.StatusOK
+	if r := c.GetHeader("Range"); r != "" {
+		spec, ok := strings.CutPrefix(r, "bytes=")
+		start, end, _ := strings.Cut(spec, "-")
+		offset, err = strconv.ParseInt(start, 10, 64)
+		if !ok || err != nil || end != "" || offset < 0 || offset >= size {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+			c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": "only ranges of the form bytes=N- are supported"})
+			return
+		}
+		status = http.StatusPartialContent
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, size-1, size))
+	}
+	if mode, err := s.manager.GuestFileMode(name, path); err == nil {
+		c.Header("X-File-Mode", fmt.Sprintf("%04o", mode))
+	}
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Content-Length", strconv.FormatInt(size-offset, 10))
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", lastElement(path)))
+	c.Status(status)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	if _, err := s.manager.DownloadFile(c.Request.Context(), name, path, c.Writer, vm.TransferOptions{Offset: offset}); err != nil {
+		// Headers are out; the short body tells the client to resume.
+		log.Printf("Download of %s from %s failed: %v", path, name, err)
+	}
+}
+
+// handleUploadFile streams the request body into a file in the guest. ?offset= resumes a partial
+// upload and must equal the size in the guest; ?mode= sets permissions, e.g. 0644.
+func (s *Server) handleUploadFile(c *gin.Context) {
+	name, path := c.Param("name"), c.Query("path")
+	if path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is required"})
+		return
+	}
+	var opts vm.TransferOptions
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+			return
+		}
+		opts.Offset = n
+	}
+	var mode os.FileMode
+	if v := c.Query("mode"); v != "" {
+		n, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || n > 0o7777 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid mode, use octal such as 0644"})
+			return
+		}
+		mode = os.FileMode(n)
+	}
+	if _, ok := s.guestAgent(c); !ok {
+		return
+	}
+	if opts.Offset > 0 {
+		size, err := s.manager.GuestFileSize(name, path)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+		if size != opts.Offset {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("offset %d does not match the %d bytes in the guest", opts.Offset, size), "size": size})
+			return
+		}
+	}
+
+	n, err := s.manager.UploadFile(c.Request.Context(), name, path, c.Request.Body, opts)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "size": n})
+		return
+	}
+	res := gin.H{"path": path, "size": n}
+	if mode != 0 {
+		if err := s.manager.GuestChmod(name, path, mode); err != nil {
+			res["warning"] = "permissions not set: " + err.Error()
+		}
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// lastElement is the file name of a guest path, which may use either separator.
+func lastElement(path string) string {
+	path = strings.TrimRight(path, `/\`)
+	if i := strings.LastIndexAny(path, `/\`); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
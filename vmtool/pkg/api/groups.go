@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+func (s *Server) handleListGroups(c *gin.Context) {
+	c.JSON(http.StatusOK, s.manager.Groups())
+}
+
+// handleGroupUp starts a group, or a single VM, with the VMs it depends on and returns the steps taken.
+func (s *Server) handleGroupUp(c *gin.Context) {
+	steps := []vm.Step{}
+	err := s.manager.Up(c.Request.Context(), []string{c.Param("name")}, func(step vm.Step) {
+		steps = append(steps, step)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "steps": steps})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "up", "steps": steps})
+}
+
+// handleGroupDown stops a group, or a single VM, in reverse dependency order. It takes the ?timeout=
+// and ?force= of stop for each VM.
+func (s *Server) handleGroupDown(c *gin.Context) {
+	opts, ok := stopOptions(c)
+	if !ok {
+		return
+	}
+	steps := []vm.Step{}
+	err := s.manager.Down(c.Request.Context(), []string{c.Param("name")}, opts, func(step vm.Step) {
+		steps = append(steps, step)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "steps": steps})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "down", "steps": steps})
+}
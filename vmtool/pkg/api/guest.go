@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+const (
+	defaultGuestExecTimeout = time.Minute
+	maxGuestExecTimeout     = time.Hour
+)
+
+// guestAgent answers 409 when the VM isn't running or has no agent channel.
+func (s *Server) guestAgent(c *gin.Context) (*qemu.GuestAgent, bool) {
+	agent, err := s.manager.GuestAgent(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return agent, true
+}
+
+func (s *Server) handleGuestPing(c *gin.Context) {
+	agent, ok := s.guestAgent(c)
+	if !ok {
+		return
+	}
+	if err := agent.Ping(); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (s *Server) handleGuestInfo(c *gin.Context) {
+	agent, ok := s.guestAgent(c)
+	if !ok {
+		return
+	}
+	info, err := agent.OSInfo()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	hostname, _ := agent.HostName()
+	c.JSON(http.StatusOK, gin.H{"os": info, "hostname": hostname})
+}
+
+func (s *Server) handleGuestInterfaces(c *gin.Context) {
+	agent, ok := s.guestAgent(c)
+	if !ok {
+		return
+	}
+	ifaces, err := agent.NetworkInterfaces()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ifaces)
+}
+
+// handleGuestShutdown takes {"mode": "powerdown"|"halt"|"reboot"}, powerdown when empty.
+func (s *Server) handleGuestShutdown(c *gin.Context) {
+	var req struct {
+		Mode string `json:"mode"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Mode == "" {
+		req.Mode = "powerdown"
+	}
+	agent, ok := s.guestAgent(c)
+	if !ok {
+		return
+	}
+	if err := agent.Shutdown(req.Mode); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": req.Mode})
+}
+
+func (s *Server) handleGuestFSFreezeStatus(c *gin.Context) {
+	agent, ok := s.guestAgent(c)
+	if !ok {
+		return
+	}
+	status, err := agent.FSFreezeStatus()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": status})
+}
+
+func (s *Server) handleGuestFSFreeze(c *gin.Context) {
+	agent, ok := s.guestAgent(c)
+	if !ok {
+		return
+	}
+	n, err := agent.FSFreeze()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "frozen", "filesystems": n})
+}
+
+func (s *Server) handleGuestFSThaw(c *gin.Context) {
+	agent, ok := s.guestAgent(c)
+	if !ok {
+		return
+	}
+	n, err := agent.FSThaw()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "thawed", "filesystems": n})
+}
+
+func (s *Server) handleGuestSyncTime(c *gin.Context) {
+	if _, ok := s.guestAgent(c); !ok {
+		return
+	}
+	if err := s.manager.GuestSyncTime(c.Param("name")); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+type guestExecRequest struct {
+	Path    string   `json:"path" binding:"required"`
+	Args    []string `json:"args"`
+	Env     []string `json:"env"`
+	Input   []byte   `json:"input"` // base64
+	Timeout string   `json:"timeout"`
+}
+
+// handleGuestExec runs a program in the guest and returns its exit code and base64 output.
+// It answers 408 when the program outlives the timeout, one minute by default.
+func (s *Server) handleGuestExec(c *gin.Context) {
+	var req guestExecRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	timeout := defaultGuestExecTimeout
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil || d <= 0 || d > maxGuestExecTimeout {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "timeout must be a duration of at most 1h"})
+			return
+		}
+		timeout = d
+	}
+	if _, ok := s.guestAgent(c); !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	res, err := s.manager.GuestExec(ctx, c.Param("name"), qemu.GuestExecRequest{
+		Path:  req.Path,
+		Args:  req.Args,
+		Env:   req.Env,
+		Input: req.Input,
+	})
+	if err != nil {
+		status := http.StatusBadGateway
+		if ctx.Err() != nil {
+			status = http.StatusRequestTimeout
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
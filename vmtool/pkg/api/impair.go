@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+func (s *Server) handleGetImpairment(c *gin.Context) {
+	nic, err := strconv.Atoi(c.Param("nic"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid nic"})
+		return
+	}
+	imp, err := s.manager.GetImpairment(c.Param("name"), nic)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if imp == nil {
+		imp = &config.ImpairmentConfig{}
+	}
+	c.JSON(http.StatusOK, imp)
+}
+
+// handleSetImpairment applies the impairment in the body; ?save=true also writes it to the VM configuration.
+func (s *Server) handleSetImpairment(c *gin.Context) {
+	nic, err := strconv.Atoi(c.Param("nic"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid nic"})
+		return
+	}
+	var imp config.ImpairmentConfig
+	if err := c.ShouldBindJSON(&imp); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.manager.SetImpairment(c.Param("name"), nic, &imp, c.Query("save") == "true"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, imp)
+}
+
+// handleClearImpairment removes any impairment from the NIC, or with ?reset=true only the runtime override.
+func (s *Server) handleClearImpairment(c *gin.Context) {
+	nic, err := strconv.Atoi(c.Param("nic"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid nic"})
+		return
+	}
+	var imp *config.ImpairmentConfig
+	if c.Query("reset") != "true" {
+		imp = &config.ImpairmentConfig{}
+	} else if c.Query("save") == "true" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reset only drops runtime changes, clear with save=true to remove a saved impairment"})
+		return
+	}
+	if err := s.manager.SetImpairment(c.Param("name"), nic, imp, c.Query("save") == "true"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "cleared"})
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/utmapp/vmtool/pkg/keymap"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+// Requests typing more than this are refused, they would hold the connection for minutes.
+const maxTypeLength = 4096
+
+type typeRequest struct {
+	Text    string `json:"text" binding:"required"`
+	Layout  string `json:"layout"`
+	DelayMs *int   `json:"delay_ms"`
+}
+
+type keyRequest struct {
+	Keys    []string `json:"keys" binding:"required"` // combinations such as "ctrl-alt-del", pressed in turn
+	HoldMs  int      `json:"hold_ms"`
+	DelayMs *int     `json:"delay_ms"`
+}
+
+type mouseRequest struct {
+	Actions []vm.MouseAction `json:"actions" binding:"required"`
+}
+
+func inputDelay(ms *int) time.Duration {
+	if ms == nil {
+		return vm.DefaultKeyDelay
+	}
+	return time.Duration(*ms) * time.Millisecond
+}
+
+func (s *Server) handleTypeText(c *gin.Context) {
+	var req typeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len([]rune(req.Text)) > maxTypeLength || (req.DelayMs != nil && (*req.DelayMs < 0 || *req.DelayMs > 10000)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text too long or delay out of range"})
+		return
+	}
+	layout, err := keymap.Lookup(req.Layout)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := layout.Type(req.Text); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.manager.TypeText(c.Param("name"), req.Text, req.Layout, inputDelay(req.DelayMs)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "typed", "characters": len([]rune(req.Text))})
+}
+
+func (s *Server) handleSendKeys(c *gin.Context) {
+	var req keyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.HoldMs < 0 || req.HoldMs > 10000 || (req.DelayMs != nil && (*req.DelayMs < 0 || *req.DelayMs > 10000)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hold or delay out of range"})
+		return
+	}
+	var combos []keymap.Combo
+	for _, k := range req.Keys {
+		combo, err := keymap.ParseCombo(k)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		combos = append(combos, combo)
+	}
+	hold := time.Duration(req.HoldMs) * time.Millisecond
+	if err := s.manager.SendKeys(c.Param("name"), combos, hold, inputDelay(req.DelayMs)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "sent", "keys": combos})
+}
+
+func (s *Server) handleMouse(c *gin.Context) {
+	var req mouseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.manager.Mouse(c.Param("name"), req.Actions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "sent"})
+}
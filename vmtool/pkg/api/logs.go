@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/utmapp/vmtool/pkg/logs"
+)
+
+// handleLogs returns a VM's log lines as plain text. ?source= picks qemu or serial, ?since= and ?tail=
+// limit the history and ?follow=true keeps the response open, streaming new lines as they are written.
+func (s *Server) handleLogs(c *gin.Context) {
+	var opts logs.Options
+	var err error
+	if opts.Sources, err = logs.ParseSources(c.Query("source")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if v := c.Query("since"); v != "" {
+		if opts.Since, err = logs.ParseSince(v, time.Now()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if v := c.Query("tail"); v != "" {
+		if opts.Tail, err = strconv.Atoi(v); err != nil || opts.Tail < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tail"})
+			return
+		}
+	}
+	opts.Follow = c.Query("follow") == "true"
+	timestamps := c.Query("timestamps") == "true"
+	merged := len(opts.Sources) > 1
+
+	name := c.Param("name")
+	if _, ok := s.manager.GetVM(name); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("VM %s not found", name)})
+		return
+	}
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+	ctx, cancel := s.streamContext(c)
+	defer cancel()
+	err = s.manager.Logs(ctx, name, opts, func(e logs.Entry) error {
+		if _, err := c.Writer.WriteString(e.Format(timestamps, merged) + "\n"); err != nil {
+			return err
+		}
+		if opts.Follow {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Log stream of %s ended: %v", name, err)
+	}
+}
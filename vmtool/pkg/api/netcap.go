@@ -0,0 +1,72 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+const maxCaptureDuration = time.Hour
+
+// handleNetcap captures a NIC's traffic for the requested time and returns it as a pcap download.
+// Closing the request stops the capture early.
+func (s *Server) handleNetcap(c *gin.Context) {
+	name := c.Param("name")
+	opts := vm.CaptureOptions{Duration: 30 * time.Second, MaxBytes: 100 << 20}
+	var err error
+
+	if v := c.Query("nic"); v != "" {
+		if opts.NIC, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid nic"})
+			return
+		}
+	}
+	if v := c.Query("duration"); v != "" {
+		if opts.Duration, err = time.ParseDuration(v); err != nil || opts.Duration <= 0 || opts.Duration > maxCaptureDuration {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("duration must be between 0 and %s", maxCaptureDuration)})
+			return
+		}
+	}
+	if v := c.Query("max_size"); v != "" {
+		if opts.MaxBytes, err = config.ParseSize(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if v := c.Query("snaplen"); v != "" {
+		if opts.SnapLen, err = strconv.Atoi(v); err != nil || opts.SnapLen < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid snaplen"})
+			return
+		}
+	}
+
+	if err := os.MkdirAll(s.config.Paths.Cache, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	f, err := os.CreateTemp(s.config.Paths.Cache, "netcap-*.pcap")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	f.Close()
+	opts.Path = f.Name()
+	defer os.Remove(opts.Path)
+
+	result, err := s.manager.CaptureNetwork(c.Request.Context(), name, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if result.Reason == "cancelled" {
+		return
+	}
+	c.Header("X-VMTool-Capture-Reason", result.Reason)
+	c.FileAttachment(result.Path, fmt.Sprintf("%s-nic%d.pcap", name, opts.NIC))
+}
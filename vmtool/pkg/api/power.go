@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+// Upper bound for ?timeout= on stop, so that a request cannot hang for good.
+const maxStopTimeout = 30 * time.Minute
+
+// handleStopVM stops a VM and reports which step did it: powerdown, quit or kill.
+func (s *Server) handleStopVM(c *gin.Context) {
+	opts, ok := stopOptions(c)
+	if !ok {
+		return
+	}
+	res, err := s.manager.StopVM(c.Request.Context(), c.Param("name"), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "stopped", "method": res.Method, "elapsed": res.Elapsed.Round(time.Millisecond).String()})
+}
+
+// stopOptions reads ?timeout=, how long the guest gets to power off, and ?force=true, which quits
+// QEMU right away. It has answered with an error when not ok.
+func stopOptions(c *gin.Context) (vm.StopOptions, bool) {
+	var opts vm.StopOptions
+	var err error
+	if v := c.Query("timeout"); v != "" {
+		if opts.Timeout, err = time.ParseDuration(v); err != nil || opts.Timeout <= 0 || opts.Timeout > maxStopTimeout {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid timeout, use a duration up to 30m such as 60s"})
+			return opts, false
+		}
+	}
+	if v := c.Query("force"); v != "" {
+		if opts.Force, err = strconv.ParseBool(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid force, use true or false"})
+			return opts, false
+		}
+	}
+	return opts, true
+}
+
+func (s *Server) handleResetVM(c *gin.Context) {
+	if err := s.manager.ResetVM(c.Param("name")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "reset"})
+}
+
+// handleRebootVM asks the guest to reboot and reports how: guest-agent or ctrl-alt-delete.
+func (s *Server) handleRebootVM(c *gin.Context) {
+	method, err := s.manager.RebootVM(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "rebooting", "method": method})
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/utmapp/vmtool/pkg/recording"
+)
+
+const maxPlaybackSpeed = 64
+
+func (s *Server) handleListRecordings(c *gin.Context) {
+	list, err := s.recordings.List(c.Query("vm"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// handleExportRecording downloads a recording, as noVNC VNC_frame_data with ?format=novnc.
+func (s *Server) handleExportRecording(c *gin.Context) {
+	id := c.Param("id")
+	rd, err := s.recordings.Open(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	defer rd.Close()
+
+	if c.Query("format") == "novnc" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".js"))
+		c.Header("Content-Type", "application/javascript")
+		if err := recording.ExportNoVNC(c.Writer, rd); err != nil {
+			log.Printf("Failed to export recording %s: %v", id, err)
+		}
+		return
+	}
+	info, _ := s.recordings.Get(id)
+	c.JSON(http.StatusOK, info)
+}
+
+func (s *Server) handleDeleteRecording(c *gin.Context) {
+	if err := s.recordings.Delete(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// handlePlayback replays a recording to a noVNC client at ?speed= times the original pace.
+// Whatever the client sends is read and ignored.
+func (s *Server) handlePlayback(c *gin.Context) {
+	id := c.Param("id")
+	if s.config.Security.APIToken != "" && c.Query("token") != s.config.Security.APIToken {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	speed := 1.0
+	if v := c.Query("speed"); v != "" {
+		var err error
+		if speed, err = strconv.ParseFloat(v, 64); err != nil || speed <= 0 || speed > maxPlaybackSpeed {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("speed must be between 0 and %d", maxPlaybackSpeed)})
+			return
+		}
+	}
+	rd, err := s.recordings.Open(id)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	defer rd.Close()
+
+	upgrader := s.wsUpgrader()
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade to websocket: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	for {
+		chunk, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Playback of recording %s stopped: %v", id, err)
+			return
+		}
+		at := start.Add(time.Duration(float64(chunk.Offset) / speed))
+		select {
+		case <-done:
+			return
+		case <-s.closing.Done():
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			return
+		case <-time.After(time.Until(at)):
+		}
+		if err := ws.WriteMessage(websocket.BinaryMessage, chunk.Data); err != nil {
+			return
+		}
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "end of recording")
+	ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
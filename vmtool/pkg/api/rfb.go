@@ -0,0 +1,246 @@
+package api
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// RFB client-to-server message types, including the QEMU and common extensions noVNC uses.
+const (
+	rfbSetPixelFormat         = 0
+	rfbSetEncodings           = 2
+	rfbFramebufferUpdateReq   = 3
+	rfbKeyEvent               = 4
+	rfbPointerEvent           = 5
+	rfbClientCutText          = 6
+	rfbEnableContinuousUpdate = 150
+	rfbClientFence            = 248
+	rfbSetDesktopSize         = 251
+	rfbXVP                    = 252
+	rfbQEMU                   = 255
+
+	rfbSecNone    = 1
+	rfbSecVNCAuth = 2
+
+	// Bounds how much a client can make the proxy buffer, e.g. with a huge clipboard.
+	maxRFBMessage = 16 << 20
+)
+
+type rfbPhase int
+
+const (
+	rfbVersion rfbPhase = iota
+	rfbSecurity
+	rfbAuth
+	rfbClientInit
+	rfbMessages
+	rfbPassthrough
+)
+
+// rfbFilter follows the client side of an RFB stream so that view-only sessions can't send input,
+// and rewrites the ClientInit shared flag so that only exclusive sessions make QEMU drop other clients.
+// The server side is only looked at for the security type RFB 3.3 servers pick on their own.
+type rfbFilter struct {
+	viewOnly  bool
+	exclusive bool
+
+	phase    rfbPhase
+	minor    int
+	secType  int
+	pending  []byte
+	server   []byte // first bytes from the server, enough for version and 3.3 security type
+	serverMu sync.Mutex
+}
+
+func newRFBFilter(viewOnly, exclusive bool) *rfbFilter {
+	return &rfbFilter{viewOnly: viewOnly, exclusive: exclusive}
+}
+
+// fromServer records the start of the server stream.
+func (f *rfbFilter) fromServer(p []byte) {
+	f.serverMu.Lock()
+	defer f.serverMu.Unlock()
+	if n := 16 - len(f.server); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		f.server = append(f.server, p[:n]...)
+	}
+}
+
+// fromClient returns what may be forwarded to the server. Incomplete messages are held back until the rest arrives.
+func (f *rfbFilter) fromClient(p []byte) ([]byte, error) {
+	f.pending = append(f.pending, p...)
+	var out []byte
+	for {
+		n, forward, err := f.next()
+		if err != nil && f.viewOnly {
+			return nil, err
+		}
+		if err != nil {
+			// Sessions with input have nothing to enforce beyond ClientInit, so don't break unknown extensions.
+			f.phase = rfbPassthrough
+			continue
+		}
+		if n == 0 {
+			return out, nil
+		}
+		if forward {
+			out = append(out, f.pending[:n]...)
+		}
+		f.pending = f.pending[n:]
+	}
+}
+
+// next returns the length of the next complete unit in f.pending and whether to forward it, or 0 if more data is needed.
+func (f *rfbFilter) next() (int, bool, error) {
+	buf := f.pending
+	switch f.phase {
+	case rfbVersion:
+		if len(buf) < 12 {
+			return 0, false, nil
+		}
+		var major, minor int
+		if _, err := fmt.Sscanf(string(buf[:12]), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 {
+			return 0, false, fmt.Errorf("unsupported RFB version %q", buf[:12])
+		}
+		f.minor = minor
+		if minor >= 7 {
+			f.phase = rfbSecurity
+		} else {
+			f.phase = rfbAuth
+		}
+		return 12, true, nil
+
+	case rfbSecurity:
+		if len(buf) < 1 {
+			return 0, false, nil
+		}
+		f.secType = int(buf[0])
+		f.phase = rfbAuth
+		return 1, true, nil
+
+	case rfbAuth:
+		if f.secType == 0 && f.minor < 7 {
+			// 3.3 has no list for the client to pick from: the server answers the client's version
+			// with the security type as a u32. The client only goes on once it has that, and the
+			// proxy records server data before passing it on, so until then there is nothing to do.
+			f.serverMu.Lock()
+			ready := len(f.server) >= 16
+			if ready {
+				f.secType = int(binary.BigEndian.Uint32(f.server[12:16]))
+			}
+			f.serverMu.Unlock()
+			if !ready {
+				return 0, false, nil
+			}
+		}
+		switch f.secType {
+		case rfbSecNone:
+			f.phase = rfbClientInit
+			return f.next()
+		case rfbSecVNCAuth:
+			if len(buf) < 16 {
+				return 0, false, nil
+			}
+			f.phase = rfbClientInit
+			return 16, true, nil
+		default:
+			return 0, false, fmt.Errorf("unsupported RFB security type %d", f.secType)
+		}
+
+	case rfbPassthrough:
+		return len(buf), true, nil
+
+	case rfbClientInit:
+		if len(buf) < 1 {
+			return 0, false, nil
+		}
+		buf[0] = 1
+		if f.exclusive {
+			buf[0] = 0
+		}
+		f.phase = rfbMessages
+		return 1, true, nil
+	}
+
+	if len(buf) < 1 {
+		return 0, false, nil
+	}
+	n := rfbMessageLength(buf)
+	if n < 0 {
+		return 0, false, fmt.Errorf("unknown RFB client message type %d", buf[0])
+	}
+	if n > maxRFBMessage {
+		return 0, false, fmt.Errorf("RFB client message of %d bytes is too large", n)
+	}
+	if n == 0 || len(buf) < n {
+		return 0, false, nil
+	}
+	input := buf[0] == rfbKeyEvent || buf[0] == rfbPointerEvent || buf[0] == rfbClientCutText ||
+		buf[0] == rfbSetDesktopSize || buf[0] == rfbXVP || (buf[0] == rfbQEMU && buf[1] == 0)
+	return n, !(f.viewOnly && input), nil
+}
+
+// rfbMessageLength returns the full length of the client message at the start of buf,
+// 0 if more bytes are needed to tell, or -1 for unknown messages.
+func rfbMessageLength(buf []byte) int {
+	need := func(n int) bool { return len(buf) >= n }
+	switch buf[0] {
+	case rfbSetPixelFormat:
+		return 20
+	case rfbSetEncodings:
+		if !need(4) {
+			return 0
+		}
+		return 4 + 4*int(binary.BigEndian.Uint16(buf[2:4]))
+	case rfbFramebufferUpdateReq:
+		return 10
+	case rfbKeyEvent:
+		return 8
+	case rfbPointerEvent:
+		return 6
+	case rfbClientCutText:
+		if !need(8) {
+			return 0
+		}
+		length := binary.BigEndian.Uint32(buf[4:8])
+		if length&0x80000000 != 0 {
+			// Extended clipboard: the length is negated
+			length = -length
+		}
+		return 8 + int(length)
+	case rfbEnableContinuousUpdate:
+		return 10
+	case rfbClientFence:
+		if !need(9) {
+			return 0
+		}
+		return 9 + int(buf[8])
+	case rfbSetDesktopSize:
+		if !need(8) {
+			return 0
+		}
+		return 8 + 16*int(buf[6])
+	case rfbXVP:
+		return 4
+	case rfbQEMU:
+		if !need(2) {
+			return 0
+		}
+		switch buf[1] {
+		case 0: // extended key event
+			return 12
+		case 1: // audio
+			if !need(4) {
+				return 0
+			}
+			if binary.BigEndian.Uint16(buf[2:4]) == 2 {
+				return 10
+			}
+			return 4
+		}
+	}
+	return -1
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRFBFilterViewOnly(t *testing.T) {
+	f := newRFBFilter(true, false)
+	f.fromServer([]byte("RFB 003.008\n"))
+
+	handshake := []byte("RFB 003.008\n")
+	handshake = append(handshake, rfbSecNone, 0) // security type, ClientInit exclusive
+	out, err := f.fromClient(handshake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]byte("RFB 003.008\n"), rfbSecNone, 1); !bytes.Equal(out, want) {
+		t.Fatalf("handshake forwarded as %q, want shared flag forced on", out)
+	}
+
+	update := []byte{rfbFramebufferUpdateReq, 1, 0, 0, 0, 0, 4, 0, 3, 0}
+	key := []byte{rfbKeyEvent, 1, 0, 0, 0, 0, 0, 0x61}
+	pointer := []byte{rfbPointerEvent, 1, 0, 10, 0, 10}
+	stream := append(append(append([]byte{}, key...), update...), pointer...)
+
+	// Split mid-message to check that partial messages are held back.
+	out1, err := f.fromClient(stream[:11])
+	if err != nil {
+		t.Fatal(err)
+	}
+	out2, err := f.fromClient(stream[11:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := append(out1, out2...); !bytes.Equal(got, update) {
+		t.Errorf("forwarded %x, want only the update request %x", got, update)
+	}
+
+	if _, err := f.fromClient([]byte{42}); err == nil {
+		t.Error("expected unknown messages to end a view-only session")
+	}
+}
+
+func TestRFBFilterViewOnly33(t *testing.T) {
+	f := newRFBFilter(true, false)
+	f.fromServer([]byte("RFB 003.003\n"))
+
+	// The client sends its version before the server has picked the security type.
+	out, err := f.fromClient([]byte("RFB 003.003\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, []byte("RFB 003.003\n")) {
+		t.Fatalf("version forwarded as %q", out)
+	}
+
+	f.fromServer([]byte{0, 0, 0, rfbSecNone})
+	key := []byte{rfbKeyEvent, 1, 0, 0, 0, 0, 0, 0x61}
+	out, err = f.fromClient(append([]byte{0}, key...)) // ClientInit exclusive, then a key
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, []byte{1}) {
+		t.Errorf("forwarded %x, want only ClientInit with the shared flag", out)
+	}
+
+	refused := newRFBFilter(true, false)
+	refused.fromServer(append([]byte("RFB 003.003\n"), 0, 0, 0, 0))
+	if _, err := refused.fromClient([]byte("RFB 003.003\n\x01")); err == nil {
+		t.Error("expected a refused 3.3 connection to end the session")
+	}
+}
+
+func TestVNCSessionsExclusive(t *testing.T) {
+	sessions := newVNCSessions()
+	first, _ := sessions.open("vm1", vncModeShared, "10.0.0.1")
+	var kicked string
+	sessions.attach(first, func(reason string) { kicked = reason })
+
+	if _, err := sessions.open("vm1", vncModeExclusive, "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	if kicked == "" {
+		t.Error("shared session was not disconnected by the exclusive one")
+	}
+	if _, err := sessions.open("vm1", vncModeView, "10.0.0.3"); err == nil {
+		t.Error("joining during an exclusive session should fail")
+	}
+	if list := sessions.list("vm1"); len(list) != 1 || list[0].RemoteAddr != "10.0.0.2" {
+		t.Errorf("unexpected sessions %+v", list)
+	}
+}
+
+func TestVNCSessionsExclusiveKick(t *testing.T) {
+	sessions := newVNCSessions()
+	first, _ := sessions.open("vm1", vncModeShared, "10.0.0.1")
+	codes := make(chan int, 1)
+	// Like the proxy's kick, which picks the close code from the sessions.
+	sessions.attach(first, func(reason string) { codes <- sessions.closeCode() })
+
+	done := make(chan error)
+	go func() {
+		_, err := sessions.open("vm1", vncModeExclusive, "10.0.0.2")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("exclusive open did not return while kicking a session")
+	}
+	if code := <-codes; code != websocket.ClosePolicyViolation {
+		t.Errorf("close code %d, want policy violation", code)
+	}
+}
+
+func TestVNCSessionsShutdown(t *testing.T) {
+	sessions := newVNCSessions()
+	first, _ := sessions.open("vm1", vncModeShared, "10.0.0.1")
+	second, _ := sessions.open("vm2", vncModeView, "10.0.0.2")
+	var kicked []string
+	kick := func(session *VNCSession) func(string) {
+		return func(reason string) {
+			kicked = append(kicked, session.VM)
+			if code := sessions.closeCode(); code != websocket.CloseGoingAway {
+				t.Errorf("close code %d, want going away", code)
+			}
+			// The proxy closes the session once its connections are gone.
+			go sessions.close(session)
+		}
+	}
+	sessions.attach(first, kick(first))
+	sessions.attach(second, kick(second))
+
+	sessions.shutdown("server shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sessions.wait(ctx); err != nil {
+		t.Fatalf("sessions not closed: %v", err)
+	}
+	if len(kicked) != 2 {
+		t.Errorf("kicked %v, want both sessions", kicked)
+	}
+	if _, err := sessions.open("vm1", vncModeShared, "10.0.0.3"); err != errShuttingDown {
+		t.Errorf("open during shutdown: got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+// handleScreenshot returns the VM's display as PNG. ?thumbnail=true gives a small image for the dashboard grid,
+// ?width=, ?height= fit the image into a box and ?scale= resizes by a factor.
+func (s *Server) handleScreenshot(c *gin.Context) {
+	var opts vm.ScreenshotOptions
+	var err error
+	if v := c.Query("width"); v != "" {
+		if opts.Width, err = strconv.Atoi(v); err != nil || opts.Width <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid width"})
+			return
+		}
+	}
+	if v := c.Query("height"); v != "" {
+		if opts.Height, err = strconv.Atoi(v); err != nil || opts.Height <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid height"})
+			return
+		}
+	}
+	if v := c.Query("scale"); v != "" {
+		if opts.Scale, err = strconv.ParseFloat(v, 64); err != nil || opts.Scale <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scale"})
+			return
+		}
+	}
+	if c.Query("thumbnail") == "true" && opts.Width == 0 && opts.Height == 0 {
+		opts.Width = vm.ThumbnailWidth
+	}
+
+	data, err := s.manager.Screenshot(c.Param("name"), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", data)
+}
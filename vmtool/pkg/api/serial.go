@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// handleSerialProxy bridges a WebSocket to the VM's serial console. Recent output is sent first,
+// so a terminal opened late still shows the last prompt. ?mode=view ignores input. Share links are
+// scoped to the VNC console and are refused here.
+func (s *Server) handleSerialProxy(c *gin.Context) {
+	name := c.Param("name")
+	shared, ok := s.authorizeWebSocket(c, name)
+	if !ok {
+		return
+	}
+	if shared != nil {
+		log.Printf("Rejected share link for the serial console of VM %s", name)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "share links only open the VNC console"})
+		return
+	}
+	viewOnly := c.Query("mode") == vncModeView
+
+	conn, err := s.manager.Console(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	defer conn.Close()
+
+	upgrader := s.wsUpgrader()
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade to websocket: %v", err)
+		return
+	}
+	defer ws.Close()
+	log.Printf("Serial console of VM %s opened from %s", name, c.ClientIP())
+
+	stop := context.AfterFunc(s.closing, func() {
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+		ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		conn.Close()
+	})
+	defer stop()
+
+	errChan := make(chan error, 2)
+
+	// WS -> serial
+	go func() {
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				errChan <- err
+				return
+			}
+			if viewOnly {
+				continue
+			}
+			if _, err := conn.Write(msg); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	}()
+
+	// serial -> WS
+	go func() {
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			if err := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	}()
+
+	if err := <-errChan; err != nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		log.Printf("Serial console of VM %s closed: %v", name, err)
+	}
+}
+
+// handleListSerialPorts lists the VM's serial ports; pty ports of a running VM show their device.
+func (s *Server) handleListSerialPorts(c *gin.Context) {
+	ports, err := s.manager.SerialPorts(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ports)
+}
+
+// handleReadSerialPort returns what the guest wrote to a ring buffer port since the last read.
+func (s *Server) handleReadSerialPort(c *gin.Context) {
+	data, err := s.manager.ReadSerial(c.Param("name"), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(data))
+}
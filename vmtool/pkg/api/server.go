@@ -1,11 +1,14 @@
 package api
 
 import (
+	"context"
 	"io/fs"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/recording"
+	"github.com/utmapp/vmtool/pkg/share"
 	"github.com/utmapp/vmtool/pkg/vm"
 	"github.com/utmapp/vmtool/pkg/web"
 )
@@ -14,15 +17,32 @@ type Server struct {
 	config  *config.AppConfig
 	manager *vm.Manager
 	router  *gin.Engine
+	vnc     *vncSessions
+	shares  *share.Store
+
+	recordings *recording.Store
+
+	http    *http.Server
+	closing context.Context // cancelled when shutting down, to end streams
+	cancel  context.CancelFunc
 }
 
-func NewServer(manager *vm.Manager, cfg *config.AppConfig) *Server {
+func NewServer(manager *vm.Manager, shares *share.Store, recordings *recording.Store, cfg *config.AppConfig) *Server {
 	router := gin.Default()
+	closing, cancel := context.WithCancel(context.Background())
 	s := &Server{
 		config:  cfg,
 		manager: manager,
 		router:  router,
+		vnc:     newVNCSessions(),
+		shares:  shares,
+
+		recordings: recordings,
+
+		closing: closing,
+		cancel:  cancel,
 	}
+	s.http = &http.Server{Handler: router}
 	s.setupRoutes()
 	return s
 }
@@ -42,15 +62,54 @@ func (s *Server) setupRoutes() {
 	protected.Use(s.authMiddleware())
 
 	protected.GET("/vms", s.handleListVMs)
+	protected.GET("/events", s.handleEvents)
+	protected.GET("/groups", s.handleListGroups)
+	protected.POST("/groups/:name/up", s.handleGroupUp)
+	protected.POST("/groups/:name/down", s.handleGroupDown)
 	protected.POST("/vms/:name/start", s.handleStartVM)
 	protected.POST("/vms/:name/stop", s.handleStopVM)
+	protected.POST("/vms/:name/reset", s.handleResetVM)
+	protected.POST("/vms/:name/reboot", s.handleRebootVM)
 	protected.POST("/vms/:name/pause", s.handlePauseVM)
 	protected.POST("/vms/:name/resume", s.handleResumeVM)
 	protected.GET("/vms/:name/status", s.handleStatusVM)
 	protected.POST("/vms/:name/snapshot/create", s.handleCreateSnapshot)
+	protected.POST("/vms/:name/netcap", s.handleNetcap)
+	protected.GET("/vms/:name/screenshot", s.handleScreenshot)
+	protected.POST("/vms/:name/input/type", s.handleTypeText)
+	protected.POST("/vms/:name/input/key", s.handleSendKeys)
+	protected.POST("/vms/:name/input/mouse", s.handleMouse)
+	protected.POST("/vms/:name/wait-screen", s.handleWaitScreen)
+	protected.GET("/vms/:name/logs", s.handleLogs)
+	protected.GET("/vms/:name/serials", s.handleListSerialPorts)
+	protected.POST("/vms/:name/serials/:id/read", s.handleReadSerialPort)
+	protected.GET("/vms/:name/guest/ping", s.handleGuestPing)
+	protected.GET("/vms/:name/guest/info", s.handleGuestInfo)
+	protected.GET("/vms/:name/guest/interfaces", s.handleGuestInterfaces)
+	protected.POST("/vms/:name/guest/shutdown", s.handleGuestShutdown)
+	protected.GET("/vms/:name/guest/fsfreeze", s.handleGuestFSFreezeStatus)
+	protected.POST("/vms/:name/guest/fsfreeze", s.handleGuestFSFreeze)
+	protected.POST("/vms/:name/guest/fsthaw", s.handleGuestFSThaw)
+	protected.POST("/vms/:name/guest/sync-time", s.handleGuestSyncTime)
+	protected.POST("/vms/:name/guest/exec", s.handleGuestExec)
+	protected.GET("/vms/:name/files", s.handleDownloadFile)
+	protected.HEAD("/vms/:name/files", s.handleDownloadFile)
+	protected.PUT("/vms/:name/files", s.handleUploadFile)
+	protected.GET("/vms/:name/vnc/sessions", s.handleVNCSessions)
+	protected.POST("/vms/:name/share", s.handleCreateShare)
+	protected.GET("/shares", s.handleListShares)
+	protected.GET("/recordings", s.handleListRecordings)
+	protected.GET("/recordings/:id", s.handleExportRecording)
+	protected.DELETE("/recordings/:id", s.handleDeleteRecording)
+	protected.DELETE("/shares/:id", s.handleRevokeShare)
+	protected.GET("/vms/:name/nics/:nic/impairment", s.handleGetImpairment)
+	protected.PUT("/vms/:name/nics/:nic/impairment", s.handleSetImpairment)
+	protected.DELETE("/vms/:name/nics/:nic/impairment", s.handleClearImpairment)
 	
 	// VNC WebSocket endpoint handles auth internally (since WebSocket can't use headers)
 	s.router.GET("/vms/:name/vnc", s.handleVNCProxy)
+	s.router.GET("/vms/:name/serial", s.handleSerialProxy)
+	s.router.GET("/recordings/:id/play", s.handlePlayback)
 }
 
 func (s *Server) authMiddleware() gin.HandlerFunc {
@@ -73,8 +132,40 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 	}
 }
 
+// Run serves the API on addr until Shutdown is called.
 func (s *Server) Run(addr string) error {
-	return s.router.Run(addr)
+	s.http.Addr = addr
+	if err := s.http.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections, ends event streams, closes VNC sessions with "going away"
+// and waits for the other requests in flight to finish. Those still running when ctx ends are cut off.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.cancel()
+	// Hijacked WebSocket connections are not waited for by http.Server.
+	s.vnc.shutdown("server shutting down")
+	err := s.http.Shutdown(ctx)
+	if err != nil {
+		s.http.Close()
+	}
+	if verr := s.vnc.wait(ctx); err == nil {
+		err = verr
+	}
+	return err
+}
+
+// streamContext is the context for a response that streams until the client leaves, which
+// also ends when the server shuts down.
+func (s *Server) streamContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	stop := context.AfterFunc(s.closing, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
 }
 
 func (s *Server) handleListVMs(c *gin.Context) {
@@ -99,15 +190,6 @@ func (s *Server) handleStartVM(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "started"})
 }
 
-func (s *Server) handleStopVM(c *gin.Context) {
-	name := c.Param("name")
-	if err := s.manager.StopVM(name); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"status": "stopped"})
-}
-
 func (s *Server) handlePauseVM(c *gin.Context) {
 	name := c.Param("name")
 	if err := s.manager.PauseVM(name); err != nil {
@@ -129,7 +211,11 @@ func (s *Server) handleResumeVM(c *gin.Context) {
 func (s *Server) handleStatusVM(c *gin.Context) {
 	name := c.Param("name")
 	status := s.manager.GetStatus(name)
-	c.JSON(http.StatusOK, gin.H{"name": name, "status": status})
+	resp := gin.H{"name": name, "status": status}
+	if state, err := s.manager.State(name); err == nil && state.VNC != nil {
+		resp["vnc"] = state.VNC
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 func (s *Server) handleCreateSnapshot(c *gin.Context) {
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/utmapp/vmtool/pkg/share"
+)
+
+// handleCreateShare mints a console link for one VM, valid for ?ttl= (default 1h).
+func (s *Server) handleCreateShare(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := s.manager.GetVM(name); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("VM %s not found", name)})
+		return
+	}
+	ttl := time.Hour
+	if v := c.Query("ttl"); v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ttl"})
+			return
+		}
+	}
+
+	sh, token, err := s.shares.Create(name, ttl, c.Query("view_only") == "true")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"share": sh,
+		"url":   share.Link(fmt.Sprintf("%s://%s", scheme, c.Request.Host), sh, token),
+	})
+}
+
+func (s *Server) handleListShares(c *gin.Context) {
+	list, err := s.shares.List(c.Query("vm"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func (s *Server) handleRevokeShare(c *gin.Context) {
+	if err := s.shares.Revoke(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
@@ -3,53 +3,63 @@ package api
 import (
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/utmapp/vmtool/pkg/recording"
+	"github.com/utmapp/vmtool/pkg/share"
 )
 
+const shareRecheckInterval = 10 * time.Second
+
 func (s *Server) handleVNCProxy(c *gin.Context) {
 	name := c.Param("name")
 	
-	// Check authentication if token is configured
-	// Since WebSocket upgrades from browser can't send custom headers,
-	// we need to check the token from query string for this endpoint
-	if s.config.Security.APIToken != "" {
-		token := c.Query("token")
-		if token != s.config.Security.APIToken {
-			log.Printf("Unauthorized VNC connection attempt for VM %s", name)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	shared, ok := s.authorizeWebSocket(c, name)
+	if !ok {
+		return
+	}
+
+	mode := c.DefaultQuery("mode", vncModeShared)
+	if mode != vncModeShared && mode != vncModeView && mode != vncModeExclusive {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "mode must be shared, view or exclusive"})
+		return
+	}
+	if shared != nil {
+		if mode == vncModeExclusive {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "share links can't open exclusive sessions"})
 			return
 		}
+		if shared.ViewOnly {
+			mode = vncModeView
+		}
 	}
-	
-	port := s.manager.GetVNCPort(name)
-	if port == 0 {
-		log.Printf("No VNC port configured for VM %s", name)
+
+	endpoint, err := s.manager.VNCEndpoint(name)
+	if err != nil {
+		log.Printf("No VNC endpoint for VM %s: %v", name, err)
 		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
-			"error": fmt.Sprintf("no VNC port configured for VM %s", name),
+			"error": err.Error(),
 		})
 		return
 	}
-	vncAddr := fmt.Sprintf("localhost:%d", port)
 
-	// Create upgrader with origin check specific to this server
-	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			origin := r.Header.Get("Origin")
-			// Allow same-origin requests and localhost origins
-			if origin == "" {
-				return true // Non-browser clients
-			}
-			// Check if origin matches the configured server address
-			expectedOrigin := fmt.Sprintf("http://%s:%d", s.config.Server.Host, s.config.Server.Port)
-			localhostOrigin := fmt.Sprintf("http://localhost:%d", s.config.Server.Port)
-			return origin == expectedOrigin || origin == localhostOrigin
-		},
+	upgrader := s.wsUpgrader()
+
+	session, err := s.vnc.open(name, mode, c.ClientIP())
+	if err == errShuttingDown {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
 	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	defer s.vnc.close(session)
+
+	record := s.config.VNC.Record || c.Query("record") == "true"
 
 	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -58,16 +68,40 @@ func (s *Server) handleVNCProxy(c *gin.Context) {
 	}
 	defer ws.Close()
 
-	conn, err := net.DialTimeout("tcp", vncAddr, 2*time.Second)
+	conn, err := endpoint.Dial(2*time.Second)
 	if err != nil {
-		log.Printf("Failed to connect to VNC at %s: %v", vncAddr, err)
+		log.Printf("Failed to connect to VNC at %s: %v", endpoint, err)
 		return
 	}
 	defer conn.Close()
 
+	log.Printf("VNC session %s for VM %s from %s (%s)", session.ID, name, session.RemoteAddr, mode)
+	s.vnc.attach(session, func(reason string) {
+		msg := websocket.FormatCloseMessage(s.vnc.closeCode(), reason)
+		ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		conn.Close()
+	})
+	filter := newRFBFilter(mode == vncModeView, mode == vncModeExclusive)
+
+	var rec *recording.Recorder
+	if record {
+		rec, err = s.recordings.Create(recording.Info{VM: name, Session: session.ID, Mode: mode, RemoteAddr: session.RemoteAddr})
+		if err != nil {
+			log.Printf("Failed to start recording of VNC session %s: %v", session.ID, err)
+		} else {
+			log.Printf("Recording VNC session %s as %s", session.ID, rec.ID())
+			defer rec.Close()
+		}
+	}
+
+	if shared != nil {
+		stop := s.watchShare(c.Query("share"), name, func(reason string) { s.vnc.kickSession(session, reason) })
+		defer stop()
+	}
+
 	errChan := make(chan error, 2)
 
-	// WS -> TCP
+	// WS -> VNC
 	go func() {
 		for {
 			_, msg, err := ws.ReadMessage()
@@ -75,6 +109,14 @@ func (s *Server) handleVNCProxy(c *gin.Context) {
 				errChan <- err
 				return
 			}
+			msg, err = filter.fromClient(msg)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			if len(msg) == 0 {
+				continue
+			}
 			_, err = conn.Write(msg)
 			if err != nil {
 				errChan <- err
@@ -83,7 +125,7 @@ func (s *Server) handleVNCProxy(c *gin.Context) {
 		}
 	}()
 
-	// TCP -> WS
+	// VNC -> WS
 	go func() {
 		buf := make([]byte, 4096)
 		for {
@@ -92,6 +134,10 @@ func (s *Server) handleVNCProxy(c *gin.Context) {
 				errChan <- err
 				return
 			}
+			filter.fromServer(buf[:n])
+			if rec != nil {
+				rec.Write(buf[:n])
+			}
 			err = ws.WriteMessage(websocket.BinaryMessage, buf[:n])
 			if err != nil {
 				errChan <- err
@@ -100,5 +146,72 @@ func (s *Server) handleVNCProxy(c *gin.Context) {
 		}
 	}()
 
-	<-errChan
+	if err := <-errChan; err != nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		log.Printf("VNC session %s for VM %s ended: %v", session.ID, name, err)
+	}
+}
+
+// authorizeWebSocket checks the API token or share link of a WebSocket endpoint, which browsers
+// pass in the query string since they can't set headers on the upgrade request.
+// A share link stands in for the API token, for this VM only.
+func (s *Server) authorizeWebSocket(c *gin.Context, name string) (*share.Share, bool) {
+	if token := c.Query("share"); token != "" {
+		sh, err := s.shares.Verify(token, name)
+		if err != nil {
+			log.Printf("Rejected share link for VM %s: %v", name, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		return sh, true
+	}
+	if s.config.Security.APIToken != "" && c.Query("token") != s.config.Security.APIToken {
+		log.Printf("Unauthorized %s connection attempt for VM %s", c.FullPath(), name)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+	return nil, true
+}
+
+// watchShare re-checks a share link periodically and calls kick once it has been revoked or has expired,
+// also when revoked from the CLI. The returned function stops watching.
+func (s *Server) watchShare(token, name string, kick func(reason string)) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(shareRecheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := s.shares.Verify(token, name); err != nil {
+					kick(err.Error())
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// wsUpgrader only accepts browsers on the dashboard's own origin.
+func (s *Server) wsUpgrader() websocket.Upgrader {
+	return websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			// Allow same-origin requests and localhost origins
+			if origin == "" {
+				return true // Non-browser clients
+			}
+			// Check if origin matches the configured server address
+			expectedOrigin := fmt.Sprintf("http://%s:%d", s.config.Server.Host, s.config.Server.Port)
+			localhostOrigin := fmt.Sprintf("http://localhost:%d", s.config.Server.Port)
+			return origin == expectedOrigin || origin == localhostOrigin
+		},
+	}
+}
+
+// handleVNCSessions lists who is connected to the VM's display through the proxy.
+func (s *Server) handleVNCSessions(c *gin.Context) {
+	c.JSON(http.StatusOK, s.vnc.list(c.Param("name")))
 }
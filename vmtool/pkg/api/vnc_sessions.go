@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	vncModeShared    = "shared"
+	vncModeView      = "view"
+	vncModeExclusive = "exclusive"
+)
+
+// VNCSession is one browser connected to a VM's display through the proxy.
+type VNCSession struct {
+	ID          string    `json:"id"`
+	VM          string    `json:"vm"`
+	Mode        string    `json:"mode"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+
+	kick   func(reason string)
+	kicked string // set when kicked before attach
+}
+
+var errShuttingDown = errors.New("server is shutting down")
+
+type vncSessions struct {
+	mu      sync.Mutex
+	byVM    map[string]map[string]*VNCSession
+	closing bool           // the server is shutting down
+	active  sync.WaitGroup // sessions opened and not yet closed
+}
+
+func newVNCSessions() *vncSessions {
+	return &vncSessions{byVM: make(map[string]map[string]*VNCSession)}
+}
+
+// open registers a new session. An exclusive session disconnects everybody else on the VM,
+// and nobody else can join while it lasts.
+func (v *vncSessions) open(vmName, mode, remoteAddr string) (*VNCSession, error) {
+	v.mu.Lock()
+	if v.closing {
+		v.mu.Unlock()
+		return nil, errShuttingDown
+	}
+	sessions := v.byVM[vmName]
+	if sessions == nil {
+		sessions = make(map[string]*VNCSession)
+		v.byVM[vmName] = sessions
+	}
+	reason := fmt.Sprintf("exclusive session started from %s", remoteAddr)
+	var kicks []func(string)
+	if mode != vncModeExclusive {
+		for _, other := range sessions {
+			if other.Mode == vncModeExclusive {
+				v.mu.Unlock()
+				return nil, fmt.Errorf("VM %s is in use by an exclusive session from %s", vmName, other.RemoteAddr)
+			}
+		}
+	} else {
+		for id, other := range sessions {
+			if other.kick != nil {
+				kicks = append(kicks, other.kick)
+			} else {
+				other.kicked = reason
+			}
+			delete(sessions, id)
+		}
+	}
+
+	session := &VNCSession{
+		ID:          uuid.New().String(),
+		VM:          vmName,
+		Mode:        mode,
+		RemoteAddr:  remoteAddr,
+		ConnectedAt: time.Now(),
+	}
+	sessions[session.ID] = session
+	v.active.Add(1)
+	v.mu.Unlock()
+
+	// Kicking writes to the connections, which must not happen with v.mu held.
+	for _, kick := range kicks {
+		kick(reason)
+	}
+	return session, nil
+}
+
+// attach sets how to disconnect the session, once its connections exist.
+func (v *vncSessions) attach(session *VNCSession, kick func(reason string)) {
+	v.mu.Lock()
+	session.kick = kick
+	kicked := session.kicked
+	v.mu.Unlock()
+	if kicked != "" {
+		kick(kicked)
+	}
+}
+
+func (v *vncSessions) kickSession(session *VNCSession, reason string) {
+	v.mu.Lock()
+	kick := session.kick
+	v.mu.Unlock()
+	if kick != nil {
+		kick(reason)
+	}
+}
+
+func (v *vncSessions) close(session *VNCSession) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if sessions := v.byVM[session.VM]; sessions != nil {
+		delete(sessions, session.ID)
+		if len(sessions) == 0 {
+			delete(v.byVM, session.VM)
+		}
+	}
+	v.active.Done()
+}
+
+// closeCode is the WebSocket close code for kicked sessions: going away once the server shuts down.
+func (v *vncSessions) closeCode() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closing {
+		return websocket.CloseGoingAway
+	}
+	return websocket.ClosePolicyViolation
+}
+
+// shutdown disconnects every session and refuses new ones.
+func (v *vncSessions) shutdown(reason string) {
+	v.mu.Lock()
+	v.closing = true
+	var kicks []func(string)
+	for _, sessions := range v.byVM {
+		for _, session := range sessions {
+			if session.kick != nil {
+				kicks = append(kicks, session.kick)
+			} else {
+				session.kicked = reason
+			}
+		}
+	}
+	v.mu.Unlock()
+	for _, kick := range kicks {
+		kick(reason)
+	}
+}
+
+// wait waits until every session has closed, finishing its recording, or ctx ends.
+func (v *vncSessions) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		v.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (v *vncSessions) list(vmName string) []VNCSession {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	list := []VNCSession{}
+	for _, s := range v.byVM[vmName] {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ConnectedAt.Before(list[j].ConnectedAt) })
+	return list
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/utmapp/vmtool/pkg/vm"
+)
+
+const (
+	defaultWaitScreenTimeout = 5 * time.Minute
+	maxWaitScreenTimeout     = time.Hour
+)
+
+type waitScreenRequest struct {
+	Template  []byte  `json:"template" binding:"required"` // base64 PNG
+	Region    *region `json:"region"`
+	Tolerance float64 `json:"tolerance"`
+	Timeout   string  `json:"timeout"`
+	Interval  string  `json:"interval"`
+}
+
+type region struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// handleWaitScreen polls the display until the template appears. It answers 408 when the timeout passes first.
+func (s *Server) handleWaitScreen(c *gin.Context) {
+	var req waitScreenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tmpl, err := png.Decode(bytes.NewReader(req.Template))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "template is not a PNG image: " + err.Error()})
+		return
+	}
+	opts := vm.MatchOptions{Tolerance: req.Tolerance}
+	if req.Tolerance < 0 || req.Tolerance > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tolerance must be between 0 and 1"})
+		return
+	}
+	if r := req.Region; r != nil {
+		if r.X < 0 || r.Y < 0 || r.Width <= 0 || r.Height <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid region"})
+			return
+		}
+		opts.Region = image.Rect(r.X, r.Y, r.X+r.Width, r.Y+r.Height)
+	}
+	timeout := defaultWaitScreenTimeout
+	if req.Timeout != "" {
+		if timeout, err = time.ParseDuration(req.Timeout); err != nil || timeout <= 0 || timeout > maxWaitScreenTimeout {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "timeout must be a duration up to " + maxWaitScreenTimeout.String()})
+			return
+		}
+	}
+	if req.Interval != "" {
+		if opts.Interval, err = time.ParseDuration(req.Interval); err != nil || opts.Interval < 100*time.Millisecond {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be a duration of at least 100ms"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	match, err := s.manager.WaitScreen(ctx, c.Param("name"), tmpl, opts)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if ctx.Err() != nil {
+			status = http.StatusRequestTimeout
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, match)
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 )
 
 type VMConfig struct {
@@ -16,6 +17,77 @@ type VMConfig struct {
 	Sharing        SharingConfig  `yaml:"sharing"`
 	Boot           BootConfig     `yaml:"boot"`
 	AdditionalArgs []string       `yaml:"additional_args,omitempty"`
+
+	// Extra NICs, attached after Network as net1, net2, ...
+	AdditionalNetworks []NetworkConfig `yaml:"additional_networks,omitempty"`
+
+	// Extra serial ports and consoles, attached after the built-in console as serial1, serial2, ...
+	Serials []SerialConfig `yaml:"serials,omitempty"`
+
+	// Channel for qemu-ga inside the guest, used by `vmtool guest`
+	GuestAgent bool `yaml:"guest_agent,omitempty"`
+
+	// How `vmtool ssh` logs in
+	SSH SSHConfig `yaml:"ssh,omitempty"`
+
+	// Started when `vmtool serve` comes up
+	Autostart bool `yaml:"autostart,omitempty"`
+
+	// What the daemon does when the VM exits without being stopped through vmtool
+	Restart RestartConfig `yaml:"restart,omitempty"`
+
+	// VMs `vmtool up` starts before this one, and `vmtool down` stops after it
+	DependsOn []Dependency `yaml:"depends_on,omitempty"`
+
+	// Groups `vmtool up` and `vmtool down` act on
+	Groups []string `yaml:"groups,omitempty"`
+
+	// Stack file the VM is managed by, set by `vmtool apply`
+	Stack string `yaml:"stack,omitempty"`
+}
+
+// Dependency readiness conditions
+const (
+	ConditionStarted    = "started"     // QEMU answers on QMP, the default
+	ConditionRunning    = "running"     // the guest is running, not paused or waiting for an incoming migration
+	ConditionPort       = "port"        // a forwarded guest TCP port accepts connections
+	ConditionGuestAgent = "guest-agent" // the guest agent answers
+)
+
+type Dependency struct {
+	VM        string `yaml:"vm"`
+	Condition string `yaml:"condition,omitempty"`
+	Port      int    `yaml:"port,omitempty"`    // guest port for the port condition, which must have a port forward
+	Timeout   string `yaml:"timeout,omitempty"` // to meet the condition, 2m if empty
+}
+
+// Restart policies
+const (
+	RestartNo            = "no"             // never restart, the default
+	RestartOnFailure     = "on-failure"     // restart when QEMU exits with an error
+	RestartAlways        = "always"         // restart on any exit, and start with the daemon
+	RestartUnlessStopped = "unless-stopped" // like always, but not with the daemon if last stopped through vmtool
+)
+
+type RestartConfig struct {
+	Policy     string `yaml:"policy,omitempty"`
+	MaxRetries int    `yaml:"max_retries,omitempty"` // on-failure gives up after this many restarts in a row, 0 for no limit
+	Backoff    string `yaml:"backoff,omitempty"`     // delay before the first restart, doubled for each further one up to 5m; 1s if empty
+}
+
+// BackoffDuration is Backoff as a duration; it has been validated when the configuration was loaded.
+func (r RestartConfig) BackoffDuration() time.Duration {
+	d, err := time.ParseDuration(r.Backoff)
+	if err != nil || d <= 0 {
+		return time.Second
+	}
+	return d
+}
+
+type SSHConfig struct {
+	User         string `yaml:"user,omitempty"`          // the ssh client's default if empty
+	IdentityFile string `yaml:"identity_file,omitempty"` // private key, ~ is expanded
+	Port         int    `yaml:"port,omitempty"`          // guest port sshd listens on, 22 if 0
 }
 
 type SystemConfig struct {
@@ -40,9 +112,60 @@ type DriveConfig struct {
 }
 
 type NetworkConfig struct {
-	Mode           string           `yaml:"mode"` // user (slirp), bridged
+	Mode           string           `yaml:"mode"` // user (slirp), bridged, virtual
 	Hardware       string           `yaml:"hardware"`
 	PortForwards   []PortForward    `yaml:"port_forwards,omitempty"`
+	Network        string           `yaml:"network,omitempty"`     // named vmtool network, for mode virtual
+	MACAddress     string           `yaml:"mac_address,omitempty"` // generated from the VM UUID if empty
+	IPAddress      string           `yaml:"ip_address,omitempty"`  // static DHCP reservation on a virtual network
+	Impairment     *ImpairmentConfig `yaml:"impairment,omitempty"`  // link simulation on a virtual network
+
+	// User mode (slirp) options
+	Subnet       string   `yaml:"subnet,omitempty"`        // e.g., 10.0.2.0/24
+	HostAddress  string   `yaml:"host_address,omitempty"`  // e.g., 10.0.2.2
+	DNSAddress   string   `yaml:"dns_address,omitempty"`   // e.g., 10.0.2.3
+	IPv6Prefix   string   `yaml:"ipv6_prefix,omitempty"`   // e.g., fd00::/64
+	Restrict     bool     `yaml:"restrict,omitempty"`      // isolate guest from host and outside
+	DNSSearch    []string `yaml:"dns_search,omitempty"`    // e.g., ["lab.example.com"]
+	TFTPRoot     string   `yaml:"tftp_root,omitempty"`     // directory served over TFTP
+	TFTPBootFile string   `yaml:"tftp_boot_file,omitempty"` // BOOTP filename for PXE, relative to TFTPRoot
+	SMBShare     string   `yaml:"smb_share,omitempty"`     // directory exported via the built-in SMB server
+}
+
+// ImpairmentConfig simulates a bad link on the traffic a NIC sends.
+type ImpairmentConfig struct {
+	Latency   string  `yaml:"latency,omitempty" json:"latency,omitempty"`     // e.g., 50ms
+	Jitter    string  `yaml:"jitter,omitempty" json:"jitter,omitempty"`       // e.g., 10ms, added or subtracted at random
+	Loss      float64 `yaml:"loss,omitempty" json:"loss,omitempty"`           // percent of frames dropped, 0-100
+	Bandwidth string  `yaml:"bandwidth,omitempty" json:"bandwidth,omitempty"` // e.g., 10mbit, 512kbit
+	Partition string  `yaml:"partition,omitempty" json:"partition,omitempty"` // only NICs in the same partition reach each other
+}
+
+// SerialConfig is a guest serial port and the host side it is connected to.
+type SerialConfig struct {
+	Device  string `yaml:"device,omitempty"` // serial (default, the machine's UART) or virtio (virtio console)
+	Backend string `yaml:"backend"`          // pty, file, socket, tcp or ring
+	Path    string `yaml:"path,omitempty"`   // file: where output goes; socket: unix socket, in the runtime directory if empty
+	Host    string `yaml:"host,omitempty"`   // tcp: listen address, 127.0.0.1 if empty
+	Port    int    `yaml:"port,omitempty"`   // tcp: listen port
+	Telnet  bool   `yaml:"telnet,omitempty"` // tcp: speak telnet, for telnet clients
+	Size    string `yaml:"size,omitempty"`   // ring: buffer size, a power of two such as 64K (the default)
+}
+
+type VirtualNetworkConfig struct {
+	Name string `yaml:"name"`
+	UUID string `yaml:"uuid"`
+
+	// Built-in DHCP and DNS, enabled when Subnet is set
+	Subnet    string `yaml:"subnet,omitempty"`     // e.g., 10.77.0.0/24
+	Gateway   string `yaml:"gateway,omitempty"`    // vmtool's own address, defaults to the first host address
+	DHCPStart string `yaml:"dhcp_start,omitempty"` // defaults to the first host address
+	DHCPEnd   string `yaml:"dhcp_end,omitempty"`   // defaults to the last host address
+	LeaseTime string `yaml:"lease_time,omitempty"` // e.g., 1h
+	Domain    string `yaml:"domain,omitempty"`     // VMs resolve as <vmname>.<domain>, defaults to "vm"
+
+	// Stack file the network is managed by, set by `vmtool apply`
+	Stack string `yaml:"stack,omitempty"`
 }
 
 type PortForward struct {
@@ -54,11 +177,13 @@ type PortForward struct {
 }
 
 type DisplayConfig struct {
-	Enabled bool   `yaml:"enabled"`
-	VNCAddr string `yaml:"vnc_addr"` // e.g., :0 or localhost:5901
-	VNCPort int    `yaml:"vnc_port,omitempty"` // e.g., 5900
-	Width   int    `yaml:"width,omitempty"`
-	Height  int    `yaml:"height,omitempty"`
+	Enabled   bool   `yaml:"enabled"`
+	VNCAddr   string `yaml:"vnc_addr,omitempty"`   // fixed QEMU display, e.g. localhost:1; empty allocates a free port
+	VNCPort   int    `yaml:"vnc_port,omitempty"`   // fixed TCP port, e.g. 5901
+	VNCSocket bool   `yaml:"vnc_socket,omitempty"` // serve VNC on a unix socket only, no TCP port
+	Width     int    `yaml:"width,omitempty"`
+	Height    int    `yaml:"height,omitempty"`
+	Tablet    bool   `yaml:"tablet,omitempty"` // USB tablet for absolute pointer input
 }
 
 type AppConfig struct {
@@ -66,11 +191,15 @@ type AppConfig struct {
 	QEMU     QEMUConfig     `yaml:"qemu"`
 	Server   ServerConfig   `yaml:"server"`
 	Security SecurityConfig `yaml:"security"`
+	VNC      VNCConfig      `yaml:"vnc"`
+	Logs     LogConfig      `yaml:"logs"`
+	Shutdown ShutdownConfig `yaml:"shutdown"`
 }
 
 type PathConfig struct {
-	VMs   string `yaml:"vms"`
-	Cache string `yaml:"cache"`
+	VMs      string `yaml:"vms"`
+	Networks string `yaml:"networks"`
+	Cache    string `yaml:"cache"`
 }
 
 type QEMUConfig struct {
@@ -82,6 +211,48 @@ type ServerConfig struct {
 	Port int    `yaml:"port"`
 }
 
+// LogConfig controls the per-VM QEMU and serial console logs.
+type LogConfig struct {
+	MaxSize  string `yaml:"max_size"`  // rotate a log once it grows beyond this, e.g. 10M
+	MaxFiles int    `yaml:"max_files"` // rotated files kept next to the current one
+}
+
+// MaxBytes is MaxSize in bytes; it has been validated when the configuration was loaded.
+func (l LogConfig) MaxBytes() int64 {
+	n, _ := ParseSize(l.MaxSize)
+	return n
+}
+
+// Daemon shutdown policies, what happens to running VMs when `vmtool serve` exits
+const (
+	ShutdownDetach   = "detach"   // leave them running, the next daemon takes them over
+	ShutdownPoweroff = "poweroff" // stop them like `vmtool stop`
+	ShutdownSuspend  = "suspend"  // save their state to disk and resume them with the next daemon
+)
+
+// ShutdownConfig controls what `vmtool serve` does with running VMs when it receives SIGINT or SIGTERM.
+type ShutdownConfig struct {
+	Policy  string `yaml:"policy"`
+	Timeout string `yaml:"timeout"` // for each VM to power off or be saved before QEMU is stopped harder
+}
+
+// TimeoutDuration is Timeout as a duration; it has been validated when the configuration was loaded.
+func (s ShutdownConfig) TimeoutDuration() time.Duration {
+	d, err := time.ParseDuration(s.Timeout)
+	if err != nil || d <= 0 {
+		return time.Minute
+	}
+	return d
+}
+
+// VNCConfig controls how VNC endpoints are allocated for VMs without a fixed port.
+type VNCConfig struct {
+	PortMin int  `yaml:"port_min"`
+	PortMax int  `yaml:"port_max"`
+	Socket  bool `yaml:"socket,omitempty"` // use unix sockets instead of ports for every VM without a fixed one
+	Record  bool `yaml:"record,omitempty"` // record every proxied session, not only those asking with ?record=true
+}
+
 type SecurityConfig struct {
 	APIToken string `yaml:"api_token"`
 }
@@ -106,6 +277,18 @@ func GetDefaultDataDir() string {
 	}
 }
 
+func GetDefaultRuntimeDir() string {
+	if val := os.Getenv("VMTOOL_HOME"); val != "" {
+		return filepath.Join(val, "run")
+	}
+	if runtime.GOOS == "linux" {
+		if val := os.Getenv("XDG_RUNTIME_DIR"); val != "" {
+			return filepath.Join(val, "vmtool")
+		}
+	}
+	return filepath.Join(GetDefaultDataDir(), "run")
+}
+
 func GetDefaultConfigDir() string {
 	if val := os.Getenv("VMTOOL_HOME"); val != "" {
 		return val
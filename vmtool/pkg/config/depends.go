@@ -0,0 +1,39 @@
+package config
+
+import (
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultDependencyTimeout is how long a dependency gets to meet its condition.
+const DefaultDependencyTimeout = 2 * time.Minute
+
+// UnmarshalYAML accepts the short form `depends_on: [router]` for a dependency that only has to be started.
+func (d *Dependency) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*d = Dependency{VM: value.Value}
+		return nil
+	}
+	type plain Dependency
+	return value.Decode((*plain)(d))
+}
+
+// TimeoutDuration is Timeout as a duration; it has been validated when the configuration was loaded.
+func (d Dependency) TimeoutDuration() time.Duration {
+	t, err := time.ParseDuration(d.Timeout)
+	if err != nil || t <= 0 {
+		return DefaultDependencyTimeout
+	}
+	return t
+}
+
+// InGroup reports whether the VM is a member of group.
+func (c *VMConfig) InGroup(group string) bool {
+	for _, g := range c.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
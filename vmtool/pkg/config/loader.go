@@ -4,10 +4,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+var DefaultVNCConfig = VNCConfig{PortMin: 5900, PortMax: 5999}
+
+var DefaultLogConfig = LogConfig{MaxSize: "10M", MaxFiles: 3}
+
+var DefaultShutdownConfig = ShutdownConfig{Policy: ShutdownDetach, Timeout: "60s"}
+
 func LoadAppConfig() (*AppConfig, error) {
 	configDir := GetDefaultConfigDir()
 	cfgPath := filepath.Join(configDir, "config.yaml")
@@ -15,8 +22,9 @@ func LoadAppConfig() (*AppConfig, error) {
 	// Default config
 	cfg := &AppConfig{
 		Paths: PathConfig{
-			VMs:   filepath.Join(GetDefaultDataDir(), "machines"),
-			Cache: GetDefaultCacheDir(),
+			VMs:      filepath.Join(GetDefaultDataDir(), "machines"),
+			Networks: filepath.Join(GetDefaultDataDir(), "networks"),
+			Cache:    GetDefaultCacheDir(),
 		},
 		QEMU: QEMUConfig{
 			Binary: "auto",
@@ -25,6 +33,9 @@ func LoadAppConfig() (*AppConfig, error) {
 			Host: "127.0.0.1",
 			Port: 8080,
 		},
+		VNC:      DefaultVNCConfig,
+		Logs:     DefaultLogConfig,
+		Shutdown: DefaultShutdownConfig,
 	}
 
 	// Try to load from file
@@ -46,5 +57,34 @@ func LoadAppConfig() (*AppConfig, error) {
 		cfg.Server.Port = port
 	}
 
+	if cfg.VNC.PortMin < 5900 || cfg.VNC.PortMax > 65535 || cfg.VNC.PortMin > cfg.VNC.PortMax {
+		return nil, fmt.Errorf("invalid vnc port range %d-%d, ports must be between 5900 and 65535", cfg.VNC.PortMin, cfg.VNC.PortMax)
+	}
+
+	if n, err := ParseSize(cfg.Logs.MaxSize); err != nil || n < 4096 {
+		return nil, fmt.Errorf("invalid logs.max_size %q, use e.g. 10M", cfg.Logs.MaxSize)
+	}
+	if cfg.Logs.MaxFiles < 0 {
+		return nil, fmt.Errorf("logs.max_files must not be negative")
+	}
+
+	switch cfg.Shutdown.Policy {
+	case ShutdownDetach, ShutdownPoweroff, ShutdownSuspend:
+	default:
+		return nil, fmt.Errorf("invalid shutdown.policy %q, use detach, poweroff or suspend", cfg.Shutdown.Policy)
+	}
+	if d, err := time.ParseDuration(cfg.Shutdown.Timeout); err != nil || d <= 0 || d > 30*time.Minute {
+		return nil, fmt.Errorf("invalid shutdown.timeout %q, use e.g. 60s, at most 30m", cfg.Shutdown.Timeout)
+	}
+
 	return cfg, nil
 }
+
+// DataDir is where vmtool keeps its own state, such as events, logs and shares: the directory the
+// VM configurations are in, so that moving paths.vms moves everything with it.
+func (c *AppConfig) DataDir() string {
+	if c.Paths.VMs == "" {
+		return GetDefaultDataDir()
+	}
+	return filepath.Dir(c.Paths.VMs)
+}
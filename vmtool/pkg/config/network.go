@@ -0,0 +1,48 @@
+package config
+
+import (
+	"crypto/sha1"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UnmarshalYAML accepts the short form `network: lab1` for attaching a NIC to a named virtual network.
+func (n *NetworkConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*n = NetworkConfig{Mode: "virtual", Network: value.Value}
+		return nil
+	}
+	type plain NetworkConfig
+	return value.Decode((*plain)(n))
+}
+
+func (n *NetworkConfig) IsVirtual() bool {
+	return n.Mode == "virtual" || (n.Mode == "" && n.Network != "")
+}
+
+// NICs returns all network interfaces of the VM in device order.
+func (c *VMConfig) NICs() []NetworkConfig {
+	return append([]NetworkConfig{c.Network}, c.AdditionalNetworks...)
+}
+
+// MACAddress returns the configured MAC of NIC index, or a stable one in QEMU's 52:54:00 range derived from the VM UUID.
+func (c *VMConfig) MACAddress(index int) string {
+	nics := c.NICs()
+	if index < len(nics) && nics[index].MACAddress != "" {
+		return nics[index].MACAddress
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s/%d", c.UUID, index)))
+	return fmt.Sprintf("52:54:00:%02x:%02x:%02x", sum[0], sum[1], sum[2])
+}
+
+// NIC returns the configuration of NIC index for in-place changes, or nil if there is none.
+func (c *VMConfig) NIC(index int) *NetworkConfig {
+	switch {
+	case index == 0:
+		return &c.Network
+	case index > 0 && index <= len(c.AdditionalNetworks):
+		return &c.AdditionalNetworks[index-1]
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseSize parses a byte size such as 512, 64K, 10M or 2G (binary multiples).
+func ParseSize(value string) (int64, error) {
+	s := strings.TrimSpace(strings.ToUpper(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * mult, nil
+}
+
+// ParseBandwidth parses a link rate such as 512kbit, 10mbit or 1gbit into bits per second.
+// A bare k, m or g is also taken as bits. Byte rates such as 10MB are rejected rather than guessed.
+func ParseBandwidth(value string) (int64, error) {
+	s := strings.TrimSuffix(strings.TrimSpace(strings.ToLower(value)), "/s")
+	s = strings.TrimSuffix(s, "bit")
+	if strings.HasSuffix(s, "b") || strings.HasSuffix(s, "bps") {
+		return 0, fmt.Errorf("invalid bandwidth %q, give the rate in bits such as 80mbit", value)
+	}
+	mult := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			mult = 1000
+		case 'm':
+			mult = 1000 * 1000
+		case 'g':
+			mult = 1000 * 1000 * 1000
+		}
+		if mult != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q, use e.g. 512kbit or 10mbit", value)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,25 @@
+package config
+
+import "testing"
+
+func TestParseBandwidth(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  int64
+	}{
+		{"512kbit", 512000},
+		{"10mbit", 10000000},
+		{"10Mbit/s", 10000000},
+		{"1g", 1000000000},
+		{"9600", 9600},
+	} {
+		if got, err := ParseBandwidth(tc.value); err != nil || got != tc.want {
+			t.Errorf("%s: got %d, %v, want %d", tc.value, got, err, tc.want)
+		}
+	}
+	for _, value := range []string{"10MB", "10mb", "10kbps", "10B", "fast", "0mbit"} {
+		if _, err := ParseBandwidth(value); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StackConfig describes related VMs with their networks and disks, which `vmtool apply` reconciles
+// the stores against.
+type StackConfig struct {
+	Name     string                 `yaml:"name"`
+	Networks []VirtualNetworkConfig `yaml:"networks,omitempty"`
+	Disks    []DiskConfig           `yaml:"disks,omitempty"`
+	VMs      []VMConfig             `yaml:"vms"`
+}
+
+// DiskConfig is a disk image `vmtool apply` creates when it is missing. Existing images are left alone.
+type DiskConfig struct {
+	Path   string `yaml:"path"`             // relative to the stack file
+	Size   string `yaml:"size"`             // e.g., 20G
+	Format string `yaml:"format,omitempty"` // qcow2 if empty
+}
+
+// LoadStack reads a stack file. Relative disk and drive paths are resolved against its directory,
+// and every VM and network is marked as belonging to the stack.
+func LoadStack(path string) (*StackConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s StackConfig
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	for i := range s.Disks {
+		s.Disks[i].Path = resolve(s.Disks[i].Path)
+	}
+	for i := range s.VMs {
+		s.VMs[i].Stack = s.Name
+		for j := range s.VMs[i].Drives {
+			s.VMs[i].Drives[j].ImagePath = resolve(s.VMs[i].Drives[j].ImagePath)
+		}
+	}
+	for i := range s.Networks {
+		s.Networks[i].Stack = s.Name
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &s, nil
+}
+
+func (s *StackConfig) Validate() error {
+	if !ValidName(s.Name) {
+		return fmt.Errorf("invalid stack name %q", s.Name)
+	}
+	seen := make(map[string]bool)
+	for i := range s.Networks {
+		n := &s.Networks[i]
+		if !ValidName(n.Name) || seen["network "+n.Name] {
+			return fmt.Errorf("network %d: invalid or duplicate name %q", i, n.Name)
+		}
+		seen["network "+n.Name] = true
+		if err := n.Validate(); err != nil {
+			return fmt.Errorf("network %s: %v", n.Name, err)
+		}
+	}
+	for i, d := range s.Disks {
+		if d.Path == "" || seen["disk "+d.Path] {
+			return fmt.Errorf("disk %d: missing or duplicate path", i)
+		}
+		seen["disk "+d.Path] = true
+		if n, err := ParseSize(d.Size); err != nil || n == 0 {
+			return fmt.Errorf("disk %s: invalid size %q", d.Path, d.Size)
+		}
+		switch d.Format {
+		case "", "qcow2", "raw":
+		default:
+			return fmt.Errorf("disk %s: invalid format %q, use qcow2 or raw", d.Path, d.Format)
+		}
+	}
+	for i := range s.VMs {
+		vm := &s.VMs[i]
+		if !ValidName(vm.Name) || seen["vm "+vm.Name] {
+			return fmt.Errorf("vm %d: invalid or duplicate name %q", i, vm.Name)
+		}
+		seen["vm "+vm.Name] = true
+		if err := vm.Validate(); err != nil {
+			return fmt.Errorf("vm %s: %v", vm.Name, err)
+		}
+	}
+	return nil
+}
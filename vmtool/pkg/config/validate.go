@@ -0,0 +1,413 @@
+package config
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var (
+	namePattern   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+	domainPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+)
+
+// ValidName reports whether name is usable as a VM or network name, which also ends up in file and socket paths.
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// DefaultUserSubnet is the guest subnet QEMU's user mode network uses when none is configured.
+const DefaultUserSubnet = "10.0.2.0/24"
+
+func (c *VMConfig) Validate() error {
+	macs := make(map[string]int)
+	for i, nic := range c.NICs() {
+		if err := nic.Validate(); err != nil {
+			return fmt.Errorf("nic %d: %v", i, err)
+		}
+		mac := c.MACAddress(i)
+		if prev, ok := macs[mac]; ok {
+			return fmt.Errorf("nic %d: MAC address %s already used by nic %d", i, mac, prev)
+		}
+		macs[mac] = i
+	}
+	if c.Display.VNCPort != 0 && (c.Display.VNCPort < 5900 || c.Display.VNCPort > 65535) {
+		return fmt.Errorf("vnc_port %d must be between 5900 and 65535", c.Display.VNCPort)
+	}
+	if c.Display.VNCSocket && (c.Display.VNCPort != 0 || c.Display.VNCAddr != "") {
+		return fmt.Errorf("vnc_socket can't be combined with vnc_port or vnc_addr")
+	}
+	if c.SSH.Port < 0 || c.SSH.Port > 65535 {
+		return fmt.Errorf("ssh port %d must be between 1 and 65535", c.SSH.Port)
+	}
+	for i := range c.Serials {
+		if err := c.Serials[i].Validate(); err != nil {
+			return fmt.Errorf("serial %d: %v", i+1, err)
+		}
+	}
+	if err := c.Restart.Validate(); err != nil {
+		return fmt.Errorf("restart: %v", err)
+	}
+	for i := range c.DependsOn {
+		if err := c.DependsOn[i].Validate(); err != nil {
+			return fmt.Errorf("depends_on %d: %v", i, err)
+		}
+		if c.DependsOn[i].VM == c.Name {
+			return fmt.Errorf("depends_on %d: VM %s can't depend on itself", i, c.Name)
+		}
+	}
+	for _, g := range c.Groups {
+		if !ValidName(g) {
+			return fmt.Errorf("invalid group name %q", g)
+		}
+	}
+	return nil
+}
+
+func (d *Dependency) Validate() error {
+	if !ValidName(d.VM) {
+		return fmt.Errorf("invalid VM name %q", d.VM)
+	}
+	switch d.Condition {
+	case "", ConditionStarted, ConditionRunning, ConditionGuestAgent:
+		if d.Port != 0 {
+			return fmt.Errorf("port is only used by the port condition")
+		}
+	case ConditionPort:
+		if d.Port < 1 || d.Port > 65535 {
+			return fmt.Errorf("the port condition needs a guest port between 1 and 65535")
+		}
+	default:
+		return fmt.Errorf("invalid condition %q, use started, running, port or guest-agent", d.Condition)
+	}
+	if d.Timeout != "" {
+		t, err := time.ParseDuration(d.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout %q: %v", d.Timeout, err)
+		}
+		if t <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+	}
+	return nil
+}
+
+func (r *RestartConfig) Validate() error {
+	switch r.Policy {
+	case "", RestartNo, RestartOnFailure, RestartAlways, RestartUnlessStopped:
+	default:
+		return fmt.Errorf("invalid policy %q, use no, on-failure, always or unless-stopped", r.Policy)
+	}
+	if r.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative")
+	}
+	if r.MaxRetries > 0 && r.Policy != RestartOnFailure {
+		return fmt.Errorf("max_retries is only used by the on-failure policy")
+	}
+	if r.Backoff != "" {
+		d, err := time.ParseDuration(r.Backoff)
+		if err != nil {
+			return fmt.Errorf("invalid backoff %q: %v", r.Backoff, err)
+		}
+		if d < 100*time.Millisecond || d > 5*time.Minute {
+			return fmt.Errorf("backoff must be between 100ms and 5m")
+		}
+	}
+	return nil
+}
+
+func (s *SerialConfig) Validate() error {
+	switch s.Device {
+	case "", "serial", "virtio":
+	default:
+		return fmt.Errorf("invalid device %q, use serial or virtio", s.Device)
+	}
+	if s.Path != "" && s.Backend != "file" && s.Backend != "socket" {
+		return fmt.Errorf("path is only used by the file and socket backends")
+	}
+	if s.Path != "" && !filepath.IsAbs(s.Path) {
+		return fmt.Errorf("path %q must be an absolute path", s.Path)
+	}
+	if (s.Host != "" || s.Port != 0 || s.Telnet) && s.Backend != "tcp" {
+		return fmt.Errorf("host, port and telnet are only used by the tcp backend")
+	}
+	if s.Size != "" && s.Backend != "ring" {
+		return fmt.Errorf("size is only used by the ring backend")
+	}
+	switch s.Backend {
+	case "pty", "socket":
+	case "file":
+		if s.Path == "" {
+			return fmt.Errorf("backend file requires a path")
+		}
+	case "tcp":
+		if s.Port <= 0 || s.Port > 65535 {
+			return fmt.Errorf("backend tcp requires a port between 1 and 65535")
+		}
+		if s.Host != "" && net.ParseIP(s.Host) == nil {
+			return fmt.Errorf("invalid host %q", s.Host)
+		}
+	case "ring":
+		if s.Size != "" {
+			n, err := ParseSize(s.Size)
+			if err != nil || n < 1024 || n&(n-1) != 0 {
+				return fmt.Errorf("ring size %q must be a power of two of at least 1K", s.Size)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid backend %q, use pty, file, socket, tcp or ring", s.Backend)
+	}
+	return nil
+}
+
+func (n *NetworkConfig) Validate() error {
+	if n.MACAddress != "" {
+		mac, err := net.ParseMAC(n.MACAddress)
+		if err != nil || len(mac) != 6 {
+			return fmt.Errorf("invalid mac_address %q", n.MACAddress)
+		}
+		if mac[0]&1 != 0 {
+			return fmt.Errorf("mac_address %s is a multicast address", n.MACAddress)
+		}
+	}
+
+	if n.IsVirtual() {
+		if n.Network == "" {
+			return fmt.Errorf("mode \"virtual\" requires a network name")
+		}
+		if !ValidName(n.Network) {
+			return fmt.Errorf("invalid network name %q", n.Network)
+		}
+		if len(n.PortForwards) > 0 || n.hasUserOptions() {
+			return fmt.Errorf("port forwards and user mode options are not supported on virtual network %s", n.Network)
+		}
+		if n.IPAddress != "" && net.ParseIP(n.IPAddress).To4() == nil {
+			return fmt.Errorf("invalid ip_address %q", n.IPAddress)
+		}
+		if n.Impairment != nil {
+			if err := n.Impairment.Validate(); err != nil {
+				return fmt.Errorf("impairment: %v", err)
+			}
+		}
+		return nil
+	}
+	if n.Impairment != nil {
+		return fmt.Errorf("impairment is only supported on virtual networks")
+	}
+	if n.Network != "" {
+		return fmt.Errorf("network %q requires mode \"virtual\", got %q", n.Network, n.Mode)
+	}
+	if n.IPAddress != "" {
+		return fmt.Errorf("ip_address is only supported on virtual networks")
+	}
+
+	if n.Mode != "" && n.Mode != "user" {
+		if n.hasUserOptions() {
+			return fmt.Errorf("subnet, DNS, IPv6, restrict, TFTP and SMB options require mode \"user\", got %q", n.Mode)
+		}
+		return nil
+	}
+
+	for _, fw := range n.PortForwards {
+		if fw.Protocol != "" && fw.Protocol != "tcp" && fw.Protocol != "udp" {
+			return fmt.Errorf("invalid port forward protocol %q", fw.Protocol)
+		}
+		if fw.HostPort <= 0 || fw.HostPort > 65535 || fw.GuestPort <= 0 || fw.GuestPort > 65535 {
+			return fmt.Errorf("invalid port forward %d -> %d", fw.HostPort, fw.GuestPort)
+		}
+		if fw.HostIP != "" && net.ParseIP(fw.HostIP).To4() == nil {
+			return fmt.Errorf("invalid port forward host_ip %q", fw.HostIP)
+		}
+		if fw.GuestIP != "" && net.ParseIP(fw.GuestIP).To4() == nil {
+			return fmt.Errorf("invalid port forward guest_ip %q", fw.GuestIP)
+		}
+	}
+
+	subnet := n.Subnet
+	if subnet == "" {
+		subnet = DefaultUserSubnet
+	}
+	ip, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("invalid subnet %q: must be an IPv4 CIDR such as %s", n.Subnet, DefaultUserSubnet)
+	}
+	if ones, _ := ipNet.Mask.Size(); ones > 29 {
+		return fmt.Errorf("subnet %q is too small, use a /29 or larger", n.Subnet)
+	}
+	for _, addr := range []struct{ name, value string }{
+		{"host_address", n.HostAddress},
+		{"dns_address", n.DNSAddress},
+	} {
+		if addr.value == "" {
+			continue
+		}
+		ip := net.ParseIP(addr.value)
+		if ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid %s %q", addr.name, addr.value)
+		}
+		if !ipNet.Contains(ip) {
+			return fmt.Errorf("%s %s is outside subnet %s", addr.name, addr.value, subnet)
+		}
+	}
+	if n.HostAddress != "" && n.HostAddress == n.DNSAddress {
+		return fmt.Errorf("host_address and dns_address must differ")
+	}
+
+	if n.IPv6Prefix != "" {
+		ip, ipNet, err := net.ParseCIDR(n.IPv6Prefix)
+		if err != nil || ip.To4() != nil {
+			return fmt.Errorf("invalid ipv6_prefix %q: must be an IPv6 CIDR such as fd00::/64", n.IPv6Prefix)
+		}
+		if ones, _ := ipNet.Mask.Size(); ones > 126 {
+			return fmt.Errorf("ipv6_prefix %q is too small, use a /126 or larger", n.IPv6Prefix)
+		}
+	}
+
+	for _, domain := range n.DNSSearch {
+		if domain == "" || len(domain) > 253 {
+			return fmt.Errorf("invalid dns_search domain %q", domain)
+		}
+	}
+
+	if n.TFTPBootFile != "" && n.TFTPRoot == "" {
+		return fmt.Errorf("tftp_boot_file requires tftp_root")
+	}
+	if n.TFTPRoot != "" {
+		if err := checkDir("tftp_root", n.TFTPRoot); err != nil {
+			return err
+		}
+	}
+	if n.SMBShare != "" {
+		if !filepath.IsAbs(n.SMBShare) {
+			return fmt.Errorf("smb_share %q must be an absolute path", n.SMBShare)
+		}
+		if err := checkDir("smb_share", n.SMBShare); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *NetworkConfig) hasUserOptions() bool {
+	return n.Subnet != "" || n.HostAddress != "" || n.DNSAddress != "" || n.IPv6Prefix != "" ||
+		n.Restrict || len(n.DNSSearch) > 0 || n.TFTPRoot != "" || n.TFTPBootFile != "" || n.SMBShare != ""
+}
+
+func checkDir(name, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s %s is not a directory", name, path)
+	}
+	return nil
+}
+
+func (n *VirtualNetworkConfig) Validate() error {
+	if !ValidName(n.Name) {
+		return fmt.Errorf("invalid network name %q", n.Name)
+	}
+	if n.Subnet == "" {
+		if n.Gateway != "" || n.DHCPStart != "" || n.DHCPEnd != "" || n.LeaseTime != "" || n.Domain != "" {
+			return fmt.Errorf("gateway, DHCP and DNS options require a subnet")
+		}
+		return nil
+	}
+
+	ip, ipNet, err := net.ParseCIDR(n.Subnet)
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("invalid subnet %q: must be an IPv4 CIDR such as 10.77.0.0/24", n.Subnet)
+	}
+	if ones, _ := ipNet.Mask.Size(); ones > 29 || ones < 8 {
+		return fmt.Errorf("subnet %q must be between /8 and /29", n.Subnet)
+	}
+	for _, addr := range []struct{ name, value string }{
+		{"gateway", n.Gateway},
+		{"dhcp_start", n.DHCPStart},
+		{"dhcp_end", n.DHCPEnd},
+	} {
+		if addr.value == "" {
+			continue
+		}
+		ip := net.ParseIP(addr.value)
+		if ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid %s %q", addr.name, addr.value)
+		}
+		if !ipNet.Contains(ip) {
+			return fmt.Errorf("%s %s is outside subnet %s", addr.name, addr.value, n.Subnet)
+		}
+	}
+	if n.LeaseTime != "" {
+		d, err := time.ParseDuration(n.LeaseTime)
+		if err != nil {
+			return fmt.Errorf("invalid lease_time %q: %v", n.LeaseTime, err)
+		}
+		if d < time.Minute {
+			return fmt.Errorf("lease_time must be at least 1m")
+		}
+	}
+	if n.Domain != "" && !domainPattern.MatchString(n.Domain) {
+		return fmt.Errorf("invalid domain %q", n.Domain)
+	}
+	return nil
+}
+
+// CheckReservation checks that addr can be reserved for a VM on the network: a host address of the
+// subnet other than the gateway's. The network has been validated.
+func (n *VirtualNetworkConfig) CheckReservation(addr string) error {
+	if n.Subnet == "" {
+		return fmt.Errorf("ip_address %s requires DHCP on network %s", addr, n.Name)
+	}
+	_, ipNet, _ := net.ParseCIDR(n.Subnet)
+	ip := net.ParseIP(addr).To4()
+	if ip == nil || !ipNet.Contains(ip) {
+		return fmt.Errorf("ip_address %s is outside network %s (%s)", addr, n.Name, n.Subnet)
+	}
+	network := binary.BigEndian.Uint32(ipNet.IP.To4())
+	ones, bits := ipNet.Mask.Size()
+	broadcast := network | (1<<uint(bits-ones) - 1)
+	gateway := network + 1
+	if n.Gateway != "" {
+		gateway = binary.BigEndian.Uint32(net.ParseIP(n.Gateway).To4())
+	}
+	switch binary.BigEndian.Uint32(ip) {
+	case network, broadcast:
+		return fmt.Errorf("ip_address %s is not a host address of network %s (%s)", addr, n.Name, n.Subnet)
+	case gateway:
+		return fmt.Errorf("ip_address %s is the gateway of network %s", addr, n.Name)
+	}
+	return nil
+}
+
+func (i *ImpairmentConfig) Validate() error {
+	for _, d := range []struct{ name, value string }{
+		{"latency", i.Latency},
+		{"jitter", i.Jitter},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %v", d.name, d.value, err)
+		}
+		if v < 0 || v > time.Minute {
+			return fmt.Errorf("%s must be between 0 and 1m", d.name)
+		}
+	}
+	if i.Loss < 0 || i.Loss > 100 {
+		return fmt.Errorf("loss must be a percentage between 0 and 100")
+	}
+	if i.Bandwidth != "" {
+		if _, err := ParseBandwidth(i.Bandwidth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
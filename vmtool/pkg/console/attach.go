@@ -0,0 +1,96 @@
+package console
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// DefaultDetachKeys leaves an attached console, like telnet's escape character.
+const DefaultDetachKeys = "ctrl-]"
+
+// ParseDetachKeys reads a key sequence such as "ctrl-]" or "ctrl-p,ctrl-q".
+func ParseDetachKeys(s string) ([]byte, error) {
+	var seq []byte
+	for _, key := range strings.Split(s, ",") {
+		key = strings.TrimSpace(key)
+		switch {
+		case len(key) == 1:
+			seq = append(seq, key[0])
+		case len(key) == 6 && strings.HasPrefix(strings.ToLower(key), "ctrl-"):
+			c := key[5]
+			if c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			if c < '@' || c > '_' {
+				return nil, fmt.Errorf("invalid detach key %q", key)
+			}
+			seq = append(seq, c-'@')
+		default:
+			return nil, fmt.Errorf("invalid detach key %q, expected e.g. ctrl-] or ctrl-p,ctrl-q", key)
+		}
+	}
+	return seq, nil
+}
+
+// Attach copies in to the console and its output to out until the console closes or the
+// detach sequence is typed, which is not passed on. It reports whether the user detached.
+func Attach(conn net.Conn, in io.Reader, out io.Writer, detach []byte) (bool, error) {
+	closed := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(out, conn)
+		closed <- err
+	}()
+
+	detached := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1024)
+		matched := 0
+		for {
+			n, err := in.Read(buf)
+			var send []byte
+			for _, b := range buf[:n] {
+				if len(detach) > 0 && b == detach[matched] {
+					matched++
+					if matched == len(detach) {
+						if len(send) > 0 {
+							conn.Write(send)
+						}
+						detached <- nil
+						return
+					}
+					continue
+				}
+				// A partial sequence turned out to be ordinary input.
+				send = append(send, detach[:matched]...)
+				matched = 0
+				if len(detach) > 0 && b == detach[0] {
+					matched = 1
+					continue
+				}
+				send = append(send, b)
+			}
+			if len(send) > 0 {
+				if _, werr := conn.Write(send); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				detached <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-closed:
+		return false, err
+	case err := <-detached:
+		conn.Close()
+		if err == io.EOF {
+			err = nil
+		}
+		return err == nil, err
+	}
+}
@@ -0,0 +1,186 @@
+// Package console shares a VM's serial port between any number of clients.
+// QEMU serves the port on a unix socket that takes a single connection, so a small
+// server process holds that connection, keeps recent output and fans it out.
+package console
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// HistorySize is how much recent output a newly attached client is sent first.
+const HistorySize = 64 << 10
+
+// Slow clients are dropped rather than holding up the VM's output.
+const clientWriteTimeout = 2 * time.Second
+
+// SocketPath is where clients attach to a VM's console.
+func SocketPath(name string) string {
+	return filepath.Join(qemu.RuntimeDir(name), "console.sock")
+}
+
+func logPath(name string) string {
+	return filepath.Join(qemu.RuntimeDir(name), "console.log")
+}
+
+type server struct {
+	serial  net.Conn
+	output  io.Writer  // the console log, if any
+	writeMu sync.Mutex // serializes client input to the VM
+
+	mu      sync.Mutex
+	clients map[net.Conn]struct{}
+	history []byte
+}
+
+// Serve connects to the serial socket QEMU listens on and shares it on socketPath
+// until the VM closes the port or ctx is cancelled. Output is also written to output when not nil.
+func Serve(ctx context.Context, serialPath, socketPath string, output io.Writer) error {
+	var serial net.Conn
+	var err error
+	// QEMU may still be creating its socket.
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		serial, err = net.Dial("unix", serialPath)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to serial port: %v", err)
+	}
+
+	os.Remove(socketPath)
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		serial.Close()
+		return err
+	}
+	defer os.Remove(socketPath)
+
+	s := &server{serial: serial, output: output, clients: make(map[net.Conn]struct{})}
+	defer serial.Close()
+	stop := context.AfterFunc(ctx, func() { serial.Close() })
+	defer stop()
+	go s.accept(ln)
+
+	s.pump()
+	ln.Close()
+	s.mu.Lock()
+	for c := range s.clients {
+		c.Close()
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// pump copies VM output to every client until the serial port closes.
+func (s *server) pump() {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := s.serial.Read(buf)
+		if n > 0 {
+			s.broadcast(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *server) broadcast(p []byte) {
+	if s.output != nil {
+		if _, err := s.output.Write(p); err != nil {
+			log.Printf("Failed to write console log: %v", err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = append(s.history, p...)
+	if over := len(s.history) - HistorySize; over > 0 {
+		s.history = append(s.history[:0], s.history[over:]...)
+	}
+	for c := range s.clients {
+		c.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if _, err := c.Write(p); err != nil {
+			log.Printf("Dropping console client: %v", err)
+			c.Close()
+			delete(s.clients, c)
+		}
+	}
+}
+
+func (s *server) accept(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		c.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if _, err := c.Write(s.history); err != nil {
+			s.mu.Unlock()
+			c.Close()
+			continue
+		}
+		s.clients[c] = struct{}{}
+		s.mu.Unlock()
+		go s.input(c)
+	}
+}
+
+// input forwards what a client types to the VM.
+func (s *server) input(c net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.Read(buf)
+		if n > 0 {
+			s.writeMu.Lock()
+			_, werr := s.serial.Write(buf[:n])
+			s.writeMu.Unlock()
+			if werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Dial attaches to a VM's console. The VM's recent output is received first.
+func Dial(name string) (net.Conn, error) {
+	conn, err := net.DialTimeout("unix", SocketPath(name), time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("console of VM %s is not available, is it running?", name)
+	}
+	return conn, nil
+}
+
+func IsRunning(name string) bool {
+	conn, err := net.DialTimeout("unix", SocketPath(name), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
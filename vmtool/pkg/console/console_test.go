@@ -0,0 +1,135 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readN(t *testing.T, c net.Conn, n int) string {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(buf)
+}
+
+func dialConsole(t *testing.T, path string) net.Conn {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if c, err := net.Dial("unix", path); err == nil {
+			return c
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("console socket %s never appeared", path)
+	return nil
+}
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	serialPath, socketPath := filepath.Join(dir, "serial.sock"), filepath.Join(dir, "console.sock")
+
+	// Stands in for QEMU's single-client serial socket.
+	ln, err := net.Listen("unix", serialPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var logged bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- Serve(context.Background(), serialPath, socketPath, &logged) }()
+	vm, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := dialConsole(t, socketPath)
+	defer a.Close()
+	vm.Write([]byte("login: "))
+	if got := readN(t, a, 7); got != "login: " {
+		t.Errorf("client a got %q", got)
+	}
+
+	// A client attaching later first gets the history.
+	b := dialConsole(t, socketPath)
+	defer b.Close()
+	if got := readN(t, b, 7); got != "login: " {
+		t.Errorf("client b history %q", got)
+	}
+
+	b.Write([]byte("root\r"))
+	if got := readN(t, vm, 5); got != "root\r" {
+		t.Errorf("VM got %q", got)
+	}
+	vm.Write([]byte("root\r\n"))
+	if got := readN(t, a, 6); got != "root\r\n" {
+		t.Errorf("client a got %q", got)
+	}
+	if got := readN(t, b, 6); got != "root\r\n" {
+		t.Errorf("client b got %q", got)
+	}
+
+	// When the VM goes away, clients are disconnected and the server returns.
+	vm.Close()
+	a.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := a.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF after the VM closed, got %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Serve did not return after the VM closed")
+	}
+	if logged.String() != "login: root\r\n" {
+		t.Errorf("console log %q", logged.String())
+	}
+}
+
+func TestParseDetachKeys(t *testing.T) {
+	tests := map[string][]byte{
+		"ctrl-]":        {0x1d},
+		"ctrl-p,ctrl-q": {0x10, 0x11},
+		"ctrl-A,x":      {0x01, 'x'},
+	}
+	for in, want := range tests {
+		got, err := ParseDetachKeys(in)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "ctrl-1", "alt-x"} {
+		if _, err := ParseDetachKeys(bad); err == nil {
+			t.Errorf("%q should fail", bad)
+		}
+	}
+}
+
+func TestAttachDetach(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(server)
+		received <- data
+	}()
+
+	// A lone ctrl-p is ordinary input; ctrl-p ctrl-q detaches and is not sent.
+	in := bytes.NewReader([]byte("ls\x10x\x10\x10\x11after"))
+	detached, err := Attach(client, in, io.Discard, []byte{0x10, 0x11})
+	if err != nil || !detached {
+		t.Fatalf("Attach = %v, %v; want detached", detached, err)
+	}
+	if got := <-received; string(got) != "ls\x10x\x10" {
+		t.Errorf("VM received %q", got)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package console
+
+import "syscall"
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
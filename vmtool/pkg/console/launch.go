@@ -0,0 +1,49 @@
+package console
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// EnsureRunning starts a detached `vmtool console-serve` process for the VM unless one is already running.
+// It exits by itself when the VM does.
+func EnsureRunning(name string) error {
+	if IsRunning(name) {
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(qemu.RuntimeDir(name), 0700); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(logPath(name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, "console-serve", name)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start console server for VM %s: %v", name, err)
+	}
+	cmd.Process.Release()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if IsRunning(name) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("console server for VM %s did not come up, see %s", name, logPath(name))
+}
@@ -0,0 +1,13 @@
+//go:build !windows && !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package console
+
+import "fmt"
+
+func MakeRaw(fd int) (func(), error) {
+	return nil, fmt.Errorf("raw terminal mode is not supported on this platform")
+}
+
+func IsTerminal(fd int) bool {
+	return false
+}
@@ -0,0 +1,38 @@
+package console
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// MakeRaw puts the console on fd into raw mode, so keys reach the VM as typed, and lets
+// stdout interpret the VM's escape sequences. The returned function restores both.
+func MakeRaw(fd int) (func(), error) {
+	in := windows.Handle(fd)
+	var inMode uint32
+	if err := windows.GetConsoleMode(in, &inMode); err != nil {
+		return nil, err
+	}
+	raw := inMode&^(windows.ENABLE_ECHO_INPUT|windows.ENABLE_PROCESSED_INPUT|windows.ENABLE_LINE_INPUT) | windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	if err := windows.SetConsoleMode(in, raw); err != nil {
+		return nil, err
+	}
+	out := windows.Handle(os.Stdout.Fd())
+	var outMode uint32
+	outOK := windows.GetConsoleMode(out, &outMode) == nil
+	if outOK {
+		windows.SetConsoleMode(out, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING|windows.DISABLE_NEWLINE_AUTO_RETURN)
+	}
+	return func() {
+		windows.SetConsoleMode(in, inMode)
+		if outOK {
+			windows.SetConsoleMode(out, outMode)
+		}
+	}, nil
+}
+
+func IsTerminal(fd int) bool {
+	var mode uint32
+	return windows.GetConsoleMode(windows.Handle(fd), &mode) == nil
+}
@@ -0,0 +1,215 @@
+// Package keymap translates text and key combinations into QEMU key codes (qcodes).
+// qcodes name physical keys by their position on a US keyboard, so typing text
+// needs to know which layout the guest uses.
+package keymap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Combo is a set of qcodes pressed together, modifiers first.
+type Combo []string
+
+type stroke struct {
+	key   string
+	shift bool
+	altgr bool
+	dead  bool // dead key: a space has to follow to produce the character itself
+}
+
+// Layout maps characters to the keys producing them.
+type Layout struct {
+	Name  string
+	chars map[rune]stroke
+}
+
+// keyRow lists what a key produces unshifted, with shift and with AltGr. Prefix "^" marks a dead key.
+type keyRow struct {
+	key                  string
+	normal, shift, altgr string
+}
+
+var layouts = map[string]*Layout{}
+
+func register(name string, rows []keyRow) {
+	l := &Layout{Name: name, chars: make(map[rune]stroke)}
+	add := func(s string, st stroke) {
+		if s == "" {
+			return
+		}
+		if strings.HasPrefix(s, "^") && len(s) > 1 {
+			st.dead = true
+			s = s[1:]
+		}
+		r := []rune(s)[0]
+		if _, ok := l.chars[r]; !ok {
+			l.chars[r] = st
+		}
+	}
+	for _, row := range rows {
+		add(row.normal, stroke{key: row.key})
+		add(row.shift, stroke{key: row.key, shift: true})
+		add(row.altgr, stroke{key: row.key, altgr: true})
+	}
+	add(" ", stroke{key: "spc"})
+	add("\n", stroke{key: "ret"})
+	add("\t", stroke{key: "tab"})
+	layouts[name] = l
+}
+
+// letters returns rows for a-z, with qcode keys remapped by swap (e.g. QWERTZ swaps y and z).
+func letters(swap map[string]string) []keyRow {
+	var rows []keyRow
+	for c := 'a'; c <= 'z'; c++ {
+		key := string(c)
+		if k, ok := swap[key]; ok {
+			key = k
+		}
+		rows = append(rows, keyRow{key: key, normal: string(c), shift: strings.ToUpper(string(c))})
+	}
+	return rows
+}
+
+func init() {
+	register("us", append(letters(nil), []keyRow{
+		{"1", "1", "!", ""}, {"2", "2", "@", ""}, {"3", "3", "#", ""}, {"4", "4", "$", ""}, {"5", "5", "%", ""},
+		{"6", "6", "^", ""}, {"7", "7", "&", ""}, {"8", "8", "*", ""}, {"9", "9", "(", ""}, {"0", "0", ")", ""},
+		{"minus", "-", "_", ""}, {"equal", "=", "+", ""},
+		{"bracket_left", "[", "{", ""}, {"bracket_right", "]", "}", ""}, {"backslash", "\\", "|", ""},
+		{"semicolon", ";", ":", ""}, {"apostrophe", "'", "\"", ""}, {"grave_accent", "`", "~", ""},
+		{"comma", ",", "<", ""}, {"dot", ".", ">", ""}, {"slash", "/", "?", ""},
+	}...))
+
+	register("gb", append(letters(nil), []keyRow{
+		{"1", "1", "!", ""}, {"2", "2", "\"", ""}, {"3", "3", "£", ""}, {"4", "4", "$", "€"}, {"5", "5", "%", ""},
+		{"6", "6", "^", ""}, {"7", "7", "&", ""}, {"8", "8", "*", ""}, {"9", "9", "(", ""}, {"0", "0", ")", ""},
+		{"minus", "-", "_", ""}, {"equal", "=", "+", ""},
+		{"bracket_left", "[", "{", ""}, {"bracket_right", "]", "}", ""}, {"backslash", "#", "~", ""},
+		{"semicolon", ";", ":", ""}, {"apostrophe", "'", "@", ""}, {"grave_accent", "`", "¬", "¦"},
+		{"comma", ",", "<", ""}, {"dot", ".", ">", ""}, {"slash", "/", "?", ""}, {"less", "\\", "|", ""},
+	}...))
+
+	register("de", append(letters(map[string]string{"y": "z", "z": "y"}), []keyRow{
+		{"1", "1", "!", "¹"}, {"2", "2", "\"", "²"}, {"3", "3", "§", "³"}, {"4", "4", "$", "¼"}, {"5", "5", "%", "½"},
+		{"6", "6", "&", "¬"}, {"7", "7", "/", "{"}, {"8", "8", "(", "["}, {"9", "9", ")", "]"}, {"0", "0", "=", "}"},
+		{"minus", "ß", "?", "\\"}, {"equal", "^´", "^`", ""},
+		{"bracket_left", "ü", "Ü", ""}, {"bracket_right", "+", "*", "~"}, {"backslash", "#", "'", ""},
+		{"semicolon", "ö", "Ö", ""}, {"apostrophe", "ä", "Ä", ""}, {"grave_accent", "^^", "°", ""},
+		{"comma", ",", ";", ""}, {"dot", ".", ":", ""}, {"slash", "-", "_", ""}, {"less", "<", ">", "|"},
+		{"q", "", "", "@"}, {"e", "", "", "€"}, {"m", "", "", "µ"},
+	}...))
+
+	register("fr", append(letters(map[string]string{"a": "q", "q": "a", "z": "w", "w": "z", "m": "semicolon"}), []keyRow{
+		{"1", "&", "1", ""}, {"2", "é", "2", "^~"}, {"3", "\"", "3", "#"}, {"4", "'", "4", "{"}, {"5", "(", "5", "["},
+		{"6", "-", "6", "|"}, {"7", "è", "7", "^`"}, {"8", "_", "8", "\\"}, {"9", "ç", "9", "^"}, {"0", "à", "0", "@"},
+		{"minus", ")", "°", "]"}, {"equal", "=", "+", "}"},
+		{"bracket_left", "^^", "^¨", ""}, {"bracket_right", "$", "£", "¤"}, {"backslash", "*", "µ", ""},
+		{"apostrophe", "ù", "%", ""}, {"grave_accent", "²", "", ""},
+		{"m", ",", "?", ""}, {"comma", ";", ".", ""}, {"dot", ":", "/", ""}, {"slash", "!", "§", ""},
+		{"less", "<", ">", ""}, {"e", "", "", "€"},
+	}...))
+}
+
+// Lookup returns the named layout: us, gb, de or fr.
+func Lookup(name string) (*Layout, error) {
+	if name == "" {
+		name = "us"
+	}
+	l, ok := layouts[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown keyboard layout %q, available: %s", name, strings.Join(Layouts(), ", "))
+	}
+	return l, nil
+}
+
+func Layouts() []string {
+	var names []string
+	for name := range layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Type returns the key combinations that produce text on a guest using this layout.
+func (l *Layout) Type(text string) ([]Combo, error) {
+	var combos []Combo
+	for i, r := range []rune(text) {
+		if r == '\r' {
+			continue
+		}
+		st, ok := l.chars[r]
+		if !ok {
+			return nil, fmt.Errorf("character %q at position %d can't be typed with the %s layout", r, i, l.Name)
+		}
+		var combo Combo
+		if st.shift {
+			combo = append(combo, "shift")
+		}
+		if st.altgr {
+			combo = append(combo, "alt_r")
+		}
+		combos = append(combos, append(combo, st.key))
+		if st.dead {
+			combos = append(combos, Combo{"spc"})
+		}
+	}
+	return combos, nil
+}
+
+var aliases = map[string]string{
+	"control": "ctrl", "ctl": "ctrl", "lctrl": "ctrl", "rctrl": "ctrl_r",
+	"option": "alt", "lalt": "alt", "ralt": "alt_r", "altgr": "alt_r",
+	"super": "meta_l", "win": "meta_l", "cmd": "meta_l", "meta": "meta_l",
+	"del": "delete", "enter": "ret", "return": "ret", "escape": "esc", "space": "spc",
+	"bksp": "backspace", "pageup": "pgup", "pagedown": "pgdn", "ins": "insert",
+	"capslock": "caps_lock", "printscreen": "print", "prtsc": "print",
+}
+
+// ParseCombo reads a key combination such as "ctrl-alt-del" or "shift+F10".
+func ParseCombo(s string) (Combo, error) {
+	parts := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return r == '-' || r == '+' })
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("empty key combination")
+	}
+	combo := make(Combo, 0, len(parts))
+	for _, p := range parts {
+		if a, ok := aliases[p]; ok {
+			p = a
+		}
+		if !validQcode(p) {
+			return nil, fmt.Errorf("unknown key %q in %q", p, s)
+		}
+		combo = append(combo, p)
+	}
+	return combo, nil
+}
+
+var namedKeys = map[string]bool{
+	"shift": true, "shift_r": true, "alt": true, "alt_r": true, "ctrl": true, "ctrl_r": true,
+	"meta_l": true, "meta_r": true, "menu": true, "esc": true, "tab": true, "ret": true, "spc": true,
+	"backspace": true, "delete": true, "insert": true, "home": true, "end": true, "pgup": true, "pgdn": true,
+	"up": true, "down": true, "left": true, "right": true, "caps_lock": true, "num_lock": true,
+	"scroll_lock": true, "print": true, "sysrq": true, "pause": true,
+	"minus": true, "equal": true, "bracket_left": true, "bracket_right": true, "backslash": true,
+	"semicolon": true, "apostrophe": true, "grave_accent": true, "comma": true, "dot": true, "slash": true, "less": true,
+	"kp_0": true, "kp_1": true, "kp_2": true, "kp_3": true, "kp_4": true, "kp_5": true, "kp_6": true,
+	"kp_7": true, "kp_8": true, "kp_9": true, "kp_enter": true, "kp_add": true, "kp_subtract": true,
+	"kp_multiply": true, "kp_divide": true, "kp_decimal": true,
+	"power": true, "sleep": true, "wake": true,
+}
+
+func validQcode(k string) bool {
+	if len(k) == 1 && (k[0] >= 'a' && k[0] <= 'z' || k[0] >= '0' && k[0] <= '9') {
+		return true
+	}
+	if strings.HasPrefix(k, "f") {
+		var n int
+		if _, err := fmt.Sscanf(k, "f%d", &n); err == nil && n >= 1 && n <= 24 && k == fmt.Sprintf("f%d", n) {
+			return true
+		}
+	}
+	return namedKeys[k]
+}
@@ -0,0 +1,63 @@
+package keymap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestType(t *testing.T) {
+	tests := []struct {
+		layout, text string
+		want         []Combo
+	}{
+		{"us", "Hi!\n", []Combo{{"shift", "h"}, {"i"}, {"shift", "1"}, {"ret"}}},
+		{"de", "yz@", []Combo{{"z"}, {"y"}, {"alt_r", "q"}}},
+		{"fr", "aqm", []Combo{{"q"}, {"a"}, {"semicolon"}}},
+		{"gb", "\"£", []Combo{{"shift", "2"}, {"shift", "3"}}},
+		{"de", "^", []Combo{{"grave_accent"}, {"spc"}}},
+	}
+	for _, tt := range tests {
+		l, err := Lookup(tt.layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := l.Type(tt.text)
+		if err != nil {
+			t.Fatalf("%s %q: %v", tt.layout, tt.text, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %q = %v, want %v", tt.layout, tt.text, got, tt.want)
+		}
+	}
+
+	us, _ := Lookup("us")
+	if _, err := us.Type("é"); err == nil {
+		t.Error("typing é on us should fail")
+	}
+	if _, err := Lookup("xx"); err == nil {
+		t.Error("unknown layout should fail")
+	}
+}
+
+func TestParseCombo(t *testing.T) {
+	tests := map[string]Combo{
+		"ctrl-alt-del": {"ctrl", "alt", "delete"},
+		"Shift+F10":    {"shift", "f10"},
+		"enter":        {"ret"},
+		"win-r":        {"meta_l", "r"},
+	}
+	for in, want := range tests {
+		got, err := ParseCombo(in)
+		if err != nil {
+			t.Fatalf("%s: %v", in, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", in, got, want)
+		}
+	}
+	for _, bad := range []string{"", "ctrl-foo", "f25", "f01"} {
+		if _, err := ParseCombo(bad); err == nil {
+			t.Errorf("%q should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+// Package logs keeps per-VM log files for QEMU's own output and the serial console.
+// Every line starts with a UTC timestamp, so that logs can be filtered by time and merged.
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SourceQEMU   = "qemu"
+	SourceSerial = "serial"
+
+	// TimeFormat starts each line. QEMU's -msg timestamp=on writes the same with microseconds.
+	TimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+	followInterval = 250 * time.Millisecond
+)
+
+var Sources = []string{SourceQEMU, SourceSerial}
+
+// Dir holds the log files of a VM in vmtool's data directory (see config.AppConfig.DataDir).
+// Logs are kept with the VM's data, so they survive restarts.
+func Dir(dataDir, name string) string {
+	return filepath.Join(dataDir, "logs", name)
+}
+
+func Path(dataDir, name, source string) string {
+	return filepath.Join(Dir(dataDir, name), source+".log")
+}
+
+// Rotate renames path to path.1, path.1 to path.2 and so on, dropping what is beyond keep files.
+func Rotate(path string, keep int) error {
+	os.Remove(fmt.Sprintf("%s.%d", path, keep))
+	for i := keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	if keep < 1 {
+		return os.Remove(path)
+	}
+	err := os.Rename(path, path+".1")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// CopyTruncate rotates a file another process keeps writing to in append mode:
+// its content is copied to path.1 and the file is emptied in place.
+func CopyTruncate(path string, maxSize int64, keep int) error {
+	st, err := os.Stat(path)
+	if err != nil || st.Size() <= maxSize {
+		return nil
+	}
+	os.Remove(fmt.Sprintf("%s.%d", path, keep))
+	for i := keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path + ".1")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Truncate(path, 0)
+}
+
+// Writer timestamps each line written to it and rotates the file once it grows beyond maxSize.
+type Writer struct {
+	path    string
+	maxSize int64
+	keep    int
+	now     func() time.Time
+
+	mu        sync.Mutex
+	f         *os.File
+	size      int64
+	lineStart bool
+}
+
+func NewWriter(path string, maxSize int64, keep int) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	w := &Writer{path: path, maxSize: maxSize, keep: keep, now: time.Now, lineStart: true}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size = f, st.Size()
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var buf []byte
+	for rest := p; len(rest) > 0; {
+		if w.lineStart {
+			// Only rotate between lines, so no line is split across files.
+			if w.maxSize > 0 && w.size+int64(len(buf)) >= w.maxSize {
+				if err := w.flush(buf); err != nil {
+					return 0, err
+				}
+				buf = buf[:0]
+				if err := w.rotate(); err != nil {
+					return 0, err
+				}
+			}
+			buf = append(buf, w.now().UTC().Format(TimeFormat)...)
+			buf = append(buf, ' ')
+			w.lineStart = false
+		}
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+			w.lineStart = true
+		}
+		buf = append(buf, line...)
+		rest = rest[len(line):]
+	}
+	if err := w.flush(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *Writer) flush(buf []byte) error {
+	n, err := w.f.Write(buf)
+	w.size += int64(n)
+	return err
+}
+
+func (w *Writer) rotate() error {
+	w.f.Close()
+	if err := Rotate(w.path, w.keep); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+// Entry is one log line.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Line   string    `json:"line"`
+}
+
+// Format renders the entry for display, optionally with its time and source.
+func (e Entry) Format(timestamps, source bool) string {
+	var b strings.Builder
+	if timestamps {
+		b.WriteString(e.Time.Format(TimeFormat))
+		b.WriteByte(' ')
+	}
+	if source {
+		fmt.Fprintf(&b, "%-6s | ", e.Source)
+	}
+	b.WriteString(e.Line)
+	return b.String()
+}
+
+// parseLine splits off the timestamp. Lines without one, such as QEMU output not going
+// through its error reporting, get the time of the line before.
+func parseLine(source, line string, last time.Time) Entry {
+	line = strings.TrimRight(line, "\r\n")
+	if ts, rest, ok := strings.Cut(line, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			return Entry{Time: t, Source: source, Line: rest}
+		}
+	}
+	return Entry{Time: last, Source: source, Line: line}
+}
+
+// ParseSince accepts a duration before now, such as 10m, or a time in RFC 3339 or YYYY-MM-DD form.
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use a duration like 10m or a time like 2006-01-02T15:04:05Z", s)
+}
+
+// ParseSources reads a source selection: qemu, serial, or all (or empty) for both.
+func ParseSources(s string) ([]string, error) {
+	switch s {
+	case "", "all":
+		return Sources, nil
+	case SourceQEMU, SourceSerial:
+		return []string{s}, nil
+	}
+	return nil, fmt.Errorf("unknown log source %q, use qemu, serial or all", s)
+}
+
+type Options struct {
+	Sources []string  // qemu and/or serial, both when empty
+	Since   time.Time // only lines from then on
+	Tail    int       // only the last lines, 0 for all
+	Follow  bool      // keep sending new lines until ctx ends
+}
+
+// follower reads a log file from where the last read ended, noticing rotation.
+type follower struct {
+	source, path string
+	f            *os.File
+	partial      string
+	last         time.Time
+}
+
+// read returns the complete lines added since the last call. A rotated or truncated file is started over.
+func (fl *follower) read() []Entry {
+	if fl.f == nil {
+		f, err := os.Open(fl.path)
+		if err != nil {
+			return nil
+		}
+		fl.f = f
+	} else if st, err := os.Stat(fl.path); err == nil {
+		cur, _ := fl.f.Stat()
+		off, _ := fl.f.Seek(0, io.SeekCurrent)
+		if cur != nil && !os.SameFile(cur, st) {
+			// Renamed away: finish the old file, then switch to the new one.
+			entries := fl.drain()
+			fl.f.Close()
+			fl.f = nil
+			return append(entries, fl.read()...)
+		}
+		if st.Size() < off {
+			fl.f.Seek(0, io.SeekStart)
+			fl.partial = ""
+		}
+	}
+	return fl.drain()
+}
+
+func (fl *follower) drain() []Entry {
+	data, _ := io.ReadAll(fl.f)
+	text := fl.partial + string(data)
+	var entries []Entry
+	for {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			break
+		}
+		e := parseLine(fl.source, text[:i], fl.last)
+		fl.last = e.Time
+		entries = append(entries, e)
+		text = text[i+1:]
+	}
+	fl.partial = text
+	return entries
+}
+
+func (fl *follower) close() {
+	if fl.f != nil {
+		fl.f.Close()
+	}
+}
+
+// readRotated returns the lines of the rotated files of a log, oldest first.
+func readRotated(source, path string) []Entry {
+	matches, _ := filepath.Glob(path + ".*")
+	var files []string
+	for i := len(matches); i >= 1; i-- {
+		p := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(p); err == nil {
+			files = append(files, p)
+		}
+	}
+	var entries []Entry
+	var last time.Time
+	for _, p := range files {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64<<10), 1<<20)
+		for sc.Scan() {
+			e := parseLine(source, sc.Text(), last)
+			last = e.Time
+			entries = append(entries, e)
+		}
+		f.Close()
+	}
+	return entries
+}
+
+// Tail sends the VM's log lines to fn, merged by time across sources, and with opts.Follow
+// keeps sending new lines as they are written until ctx ends.
+func Tail(ctx context.Context, dataDir, name string, opts Options, fn func(Entry) error) error {
+	sources := opts.Sources
+	if len(sources) == 0 {
+		sources = Sources
+	}
+	var followers []*follower
+	var entries []Entry
+	for _, source := range sources {
+		path := Path(dataDir, name, source)
+		old := readRotated(source, path)
+		fl := &follower{source: source, path: path}
+		if len(old) > 0 {
+			fl.last = old[len(old)-1].Time
+		}
+		entries = append(append(entries, old...), fl.read()...)
+		followers = append(followers, fl)
+	}
+	defer func() {
+		for _, fl := range followers {
+			fl.close()
+		}
+	}()
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	if !opts.Since.IsZero() {
+		i := sort.Search(len(entries), func(i int) bool { return !entries[i].Time.Before(opts.Since) })
+		entries = entries[i:]
+	}
+	if opts.Tail > 0 && len(entries) > opts.Tail {
+		entries = entries[len(entries)-opts.Tail:]
+	}
+	for _, e := range entries {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if !opts.Follow {
+		return nil
+	}
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		for _, fl := range followers {
+			for _, e := range fl.read() {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package logs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterTimestampsAndRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "serial.log")
+	w, err := NewWriter(path, 64, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	// A line split across writes gets a single timestamp.
+	w.Write([]byte("boot"))
+	w.Write([]byte("ing\nlogin: "))
+	data, _ := os.ReadFile(path)
+	want := "2024-05-01T12:00:00.000Z booting\n2024-05-01T12:00:00.000Z login: "
+	if string(data) != want {
+		t.Fatalf("log is %q, want %q", data, want)
+	}
+
+	for i := 0; i < 10; i++ {
+		w.Write([]byte("root\n"))
+	}
+	w.Close()
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatalf("expected %s: %v", p, err)
+		}
+		if !strings.HasSuffix(string(data), "\n") && p != path {
+			t.Errorf("%s ends within a line: %q", p, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("expected only 2 rotated files")
+	}
+}
+
+func writeLog(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTailMergesSources(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, Path(dir, "vm", SourceQEMU)+".1",
+		"2024-05-01T12:00:00.000Z vmtool: starting VM")
+	writeLog(t, Path(dir, "vm", SourceQEMU),
+		"2024-05-01T12:00:02.000000Z qemu-system-x86_64: warning: host doesn't support requested feature",
+		"2024-05-01T12:00:04.000Z vmtool: VM exited")
+	writeLog(t, Path(dir, "vm", SourceSerial),
+		"2024-05-01T12:00:01.000Z SeaBIOS",
+		"continued without a timestamp",
+		"2024-05-01T12:00:03.000Z login:")
+
+	collect := func(opts Options) []string {
+		var lines []string
+		err := Tail(context.Background(), dir, "vm", opts, func(e Entry) error {
+			lines = append(lines, e.Format(false, true))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lines
+	}
+
+	got := collect(Options{})
+	want := []string{
+		"qemu   | vmtool: starting VM",
+		"serial | SeaBIOS",
+		"serial | continued without a timestamp",
+		"qemu   | qemu-system-x86_64: warning: host doesn't support requested feature",
+		"serial | login:",
+		"qemu   | vmtool: VM exited",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("merged logs:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	got = collect(Options{Since: time.Date(2024, 5, 1, 12, 0, 2, 0, time.UTC), Tail: 2})
+	if len(got) != 2 || got[0] != "serial | login:" {
+		t.Errorf("since and tail gave %q", got)
+	}
+	got = collect(Options{Sources: []string{SourceSerial}})
+	if len(got) != 3 {
+		t.Errorf("serial only gave %q", got)
+	}
+}
+
+func TestTailFollows(t *testing.T) {
+	dir := t.TempDir()
+	path := Path(dir, "vm", SourceSerial)
+	writeLog(t, path, "2024-05-01T12:00:00.000Z old")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lines := make(chan string, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- Tail(ctx, dir, "vm", Options{Sources: []string{SourceSerial}, Follow: true}, func(e Entry) error {
+			lines <- e.Line
+			return nil
+		})
+	}()
+	next := func() string {
+		select {
+		case l := <-lines:
+			return l
+		case <-time.After(5 * time.Second):
+			t.Fatal("no line followed")
+			return ""
+		}
+	}
+	if l := next(); l != "old" {
+		t.Fatalf("first line %q", l)
+	}
+
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString("2024-05-01T12:00:01.000Z new\n")
+	f.Close()
+	if l := next(); l != "new" {
+		t.Errorf("followed %q", l)
+	}
+
+	// After rotation the new file is read from its start.
+	if err := Rotate(path, 1); err != nil {
+		t.Fatal(err)
+	}
+	writeLog(t, path, "2024-05-01T12:00:02.000Z rotated")
+	if l := next(); l != "rotated" {
+		t.Errorf("followed %q after rotation", l)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if got, _ := ParseSince("10m", now); !got.Equal(now.Add(-10 * time.Minute)) {
+		t.Errorf("10m gave %v", got)
+	}
+	if got, _ := ParseSince("2024-04-30T08:00:00Z", now); !got.Equal(time.Date(2024, 4, 30, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("RFC 3339 gave %v", got)
+	}
+	if _, err := ParseSince("yesterday", now); err == nil {
+		t.Error("expected an error")
+	}
+}
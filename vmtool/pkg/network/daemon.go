@@ -0,0 +1,241 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+// RuntimeDir holds the sockets and pid file of a running network switch.
+func RuntimeDir(name string) string {
+	return filepath.Join(config.GetDefaultRuntimeDir(), "networks", name)
+}
+
+// SocketPath is the unix socket QEMU stream netdevs connect to.
+func SocketPath(name string) string {
+	return filepath.Join(RuntimeDir(name), "switch.sock")
+}
+
+func controlSocketPath(name string) string {
+	return filepath.Join(RuntimeDir(name), "ctl.sock")
+}
+
+var errLocked = errors.New("locked by another process")
+
+type Request struct {
+	Command    string                   `json:"command"`
+	MAC        string                   `json:"mac,omitempty"`
+	Impairment *config.ImpairmentConfig `json:"impairment,omitempty"`
+}
+
+type Response struct {
+	Error  string  `json:"error,omitempty"`
+	State  *State  `json:"state,omitempty"`
+	Leases []Lease `json:"leases,omitempty"`
+
+	Impairment *config.ImpairmentConfig `json:"impairment,omitempty"`
+}
+
+type State struct {
+	Name    string       `json:"name"`
+	PID     int          `json:"pid"`
+	Gateway string       `json:"gateway,omitempty"`
+	Ports   []PortStatus `json:"ports"`
+}
+
+// Run serves the network in the current process until ctx is cancelled or a shutdown request arrives.
+// When the network has a subnet, the gateway serving DHCP and DNS is attached to the switch;
+// hosts lists the VMs on the network for reservations and names.
+func Run(ctx context.Context, cfg *config.VirtualNetworkConfig, hosts HostFunc) error {
+	name := cfg.Name
+	dir := RuntimeDir(name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// Two VMs starting at once may each launch a switch. The lock is held while the switch runs, so
+	// the loser never removes the sockets of the one serving the network.
+	lock, err := lockFile(filepath.Join(dir, "switch.lock"))
+	if err == errLocked {
+		return fmt.Errorf("network %s is already running", name)
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	os.Remove(SocketPath(name))
+	os.Remove(controlSocketPath(name))
+
+	dataListener, err := net.Listen("unix", SocketPath(name))
+	if err != nil {
+		return err
+	}
+	defer dataListener.Close()
+	ctlListener, err := net.Listen("unix", controlSocketPath(name))
+	if err != nil {
+		return err
+	}
+	defer ctlListener.Close()
+
+	pidFile := filepath.Join(dir, "switch.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(pidFile)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	hc := newHostCache(hosts)
+	sw := NewSwitch(name)
+	sw.hosts = hc
+	defer sw.Close()
+
+	var gw *Gateway
+	if cfg.Subnet != "" {
+		gw, err = newGateway(cfg, hc, filepath.Join(dir, "leases.json"))
+		if err != nil {
+			return err
+		}
+		gw.send = sw.AttachLocal(gw.HandleFrame)
+		fmt.Printf("network %s: serving DHCP and DNS on %s as %s\n", name, cfg.Subnet, ipString(gw.ip))
+	}
+	go sw.Serve(dataListener)
+
+	go func() {
+		for {
+			conn, err := ctlListener.Accept()
+			if err != nil {
+				return
+			}
+			go handleControl(conn, name, sw, gw, cancel)
+		}
+	}()
+
+	<-ctx.Done()
+	return nil
+}
+
+func handleControl(conn net.Conn, name string, sw *Switch, gw *Gateway, shutdown context.CancelFunc) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req Request
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("invalid request: %v", err)
+			encoder.Encode(resp)
+			continue
+		}
+		switch req.Command {
+		case "status":
+			resp.State = &State{Name: name, PID: os.Getpid(), Ports: sw.Ports()}
+			if gw != nil {
+				resp.State.Gateway = ipString(gw.ip)
+			}
+		case "leases":
+			if gw == nil {
+				resp.Error = "DHCP is not enabled on this network"
+			} else {
+				resp.Leases = gw.Leases()
+			}
+		case "set-impairment":
+			if err := sw.SetImpairment(req.MAC, req.Impairment); err != nil {
+				resp.Error = err.Error()
+			}
+		case "get-impairment":
+			imp, err := sw.Impairment(req.MAC)
+			if err != nil {
+				resp.Error = err.Error()
+			}
+			resp.Impairment = imp
+		case "shutdown":
+			encoder.Encode(resp)
+			shutdown()
+			return
+		default:
+			resp.Error = fmt.Sprintf("unknown command %q", req.Command)
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// Query sends a single request to the switch serving the named network.
+func Query(name string, req Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", controlSocketPath(name), 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("network %s is not running", name)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("network %s: %s", name, resp.Error)
+	}
+	return &resp, nil
+}
+
+func IsRunning(name string) bool {
+	conn, err := net.DialTimeout("unix", controlSocketPath(name), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func Status(name string) (*State, error) {
+	resp, err := Query(name, Request{Command: "status"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.State, nil
+}
+
+func Leases(name string) ([]Lease, error) {
+	resp, err := Query(name, Request{Command: "leases"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Leases, nil
+}
+
+// SetImpairment changes the impairment of a NIC on a running network. nil reverts to the VM's configuration.
+func SetImpairment(name, mac string, cfg *config.ImpairmentConfig) error {
+	_, err := Query(name, Request{Command: "set-impairment", MAC: mac, Impairment: cfg})
+	return err
+}
+
+func GetImpairment(name, mac string) (*config.ImpairmentConfig, error) {
+	resp, err := Query(name, Request{Command: "get-impairment", MAC: mac})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Impairment, nil
+}
+
+func Stop(name string) error {
+	if !IsRunning(name) {
+		return nil
+	}
+	_, err := Query(name, Request{Command: "shutdown"})
+	return err
+}
@@ -0,0 +1,34 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+func TestRunLocksNetwork(t *testing.T) {
+	t.Setenv("VMTOOL_HOME", t.TempDir())
+	cfg := &config.VirtualNetworkConfig{Name: "lab1"}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg, nil) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	for i := 0; !IsRunning("lab1"); i++ {
+		if i == 100 {
+			t.Fatal("switch did not come up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := Run(context.Background(), cfg, nil); err == nil {
+		t.Fatal("a second switch started on the network")
+	}
+	if !IsRunning("lab1") {
+		t.Error("the second switch removed the sockets of the first")
+	}
+}
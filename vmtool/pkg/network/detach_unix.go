@@ -0,0 +1,9 @@
+//go:build !windows
+
+package network
+
+import "syscall"
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
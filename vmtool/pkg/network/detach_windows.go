@@ -0,0 +1,10 @@
+//go:build windows
+
+package network
+
+import "syscall"
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	const detachedProcess = 0x00000008
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess}
+}
@@ -0,0 +1,479 @@
+package network
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpDecline  = 4
+	dhcpAck      = 5
+	dhcpNak      = 6
+	dhcpRelease  = 7
+	dhcpInform   = 8
+
+	optSubnetMask   = 1
+	optDNSServer    = 6
+	optHostname     = 12
+	optDomainName   = 15
+	optBroadcast    = 28
+	optRequestedIP  = 50
+	optLeaseTime    = 51
+	optMessageType  = 53
+	optServerID     = 54
+	optRenewalTime  = 58
+	optRebindTime   = 59
+	optEnd          = 255
+	dhcpHeaderSize  = 236
+	dhcpMagicCookie = 0x63825363
+
+	offerHoldTime = 30 * time.Second
+)
+
+type dhcpMessage struct {
+	op      byte
+	xid     uint32
+	flags   uint16
+	ciaddr  [4]byte
+	yiaddr  [4]byte
+	siaddr  [4]byte
+	chaddr  macAddr
+	msgType byte
+	options map[byte][]byte
+}
+
+func parseDHCP(b []byte) (*dhcpMessage, error) {
+	if len(b) < dhcpHeaderSize+4 {
+		return nil, fmt.Errorf("short DHCP message")
+	}
+	if b[0] != 1 || b[1] != 1 || b[2] != 6 {
+		return nil, fmt.Errorf("not an Ethernet BOOTREQUEST")
+	}
+	if binary.BigEndian.Uint32(b[dhcpHeaderSize:]) != dhcpMagicCookie {
+		return nil, fmt.Errorf("missing DHCP magic cookie")
+	}
+	m := &dhcpMessage{
+		op:      b[0],
+		xid:     binary.BigEndian.Uint32(b[4:8]),
+		flags:   binary.BigEndian.Uint16(b[10:12]),
+		ciaddr:  [4]byte(b[12:16]),
+		yiaddr:  [4]byte(b[16:20]),
+		siaddr:  [4]byte(b[20:24]),
+		chaddr:  macAddr(b[28:34]),
+		options: make(map[byte][]byte),
+	}
+	opts := b[dhcpHeaderSize+4:]
+	for i := 0; i < len(opts); {
+		code := opts[i]
+		if code == optEnd {
+			break
+		}
+		if code == 0 { // pad
+			i++
+			continue
+		}
+		if i+1 >= len(opts) || i+2+int(opts[i+1]) > len(opts) {
+			return nil, fmt.Errorf("truncated DHCP option %d", code)
+		}
+		m.options[code] = opts[i+2 : i+2+int(opts[i+1])]
+		i += 2 + int(opts[i+1])
+	}
+	if t := m.options[optMessageType]; len(t) == 1 {
+		m.msgType = t[0]
+	} else {
+		return nil, fmt.Errorf("missing DHCP message type")
+	}
+	return m, nil
+}
+
+func (m *dhcpMessage) marshal() []byte {
+	b := make([]byte, dhcpHeaderSize+4, 300)
+	b[0] = m.op
+	b[1], b[2] = 1, 6
+	binary.BigEndian.PutUint32(b[4:8], m.xid)
+	binary.BigEndian.PutUint16(b[10:12], m.flags)
+	copy(b[12:16], m.ciaddr[:])
+	copy(b[16:20], m.yiaddr[:])
+	copy(b[20:24], m.siaddr[:])
+	copy(b[28:34], m.chaddr[:])
+	binary.BigEndian.PutUint32(b[dhcpHeaderSize:], dhcpMagicCookie)
+
+	b = append(b, optMessageType, 1, m.msgType)
+	codes := make([]int, 0, len(m.options))
+	for code := range m.options {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		v := m.options[byte(code)]
+		b = append(b, byte(code), byte(len(v)))
+		b = append(b, v...)
+	}
+	b = append(b, optEnd)
+	// Some clients reject BOOTP replies shorter than the 300 byte minimum
+	for len(b) < 300 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+type Lease struct {
+	MAC      string    `json:"mac"`
+	IP       string    `json:"ip"`
+	Hostname string    `json:"hostname,omitempty"`
+	Expires  time.Time `json:"expires"`
+	Static   bool      `json:"static,omitempty"`
+}
+
+type lease struct {
+	ip       [4]byte
+	hostname string
+	expires  time.Time
+	offered  bool
+}
+
+// DHCPServer hands out addresses on a virtual network. VMs with an ip_address get it as a
+// static reservation keyed by their MAC, everyone else gets a dynamic lease from the range.
+type DHCPServer struct {
+	cfg    *gatewayConfig
+	hosts  *hostCache
+	path   string
+	leases map[macAddr]*lease
+	mu     sync.Mutex
+}
+
+func newDHCPServer(cfg *gatewayConfig, hosts *hostCache, path string) *DHCPServer {
+	d := &DHCPServer{
+		cfg:    cfg,
+		hosts:  hosts,
+		path:   path,
+		leases: make(map[macAddr]*lease),
+	}
+	d.load()
+	return d
+}
+
+func (d *DHCPServer) Handle(req *dhcpMessage) *dhcpMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if id, ok := req.options[optServerID]; ok && len(id) == 4 && [4]byte(id) != d.cfg.ip {
+		// Addressed to another server; drop any offer we made.
+		if l, ok := d.leases[req.chaddr]; ok && l.offered {
+			delete(d.leases, req.chaddr)
+		}
+		return nil
+	}
+
+	switch req.msgType {
+	case dhcpDiscover:
+		ip, err := d.allocate(req.chaddr, req.options[optRequestedIP])
+		if err != nil {
+			fmt.Printf("DHCP: no address for %s: %v\n", req.chaddr, err)
+			return nil
+		}
+		d.leases[req.chaddr] = &lease{ip: ip, hostname: d.hostname(req), expires: time.Now().Add(offerHoldTime), offered: true}
+		return d.reply(req, dhcpOffer, ip)
+
+	case dhcpRequest:
+		requested := req.options[optRequestedIP]
+		if len(requested) != 4 {
+			requested = req.ciaddr[:]
+		}
+		ip, err := d.allocate(req.chaddr, requested)
+		if err != nil || !ipEqual(ip, requested) {
+			return d.reply(req, dhcpNak, [4]byte{})
+		}
+		d.leases[req.chaddr] = &lease{ip: ip, hostname: d.hostname(req), expires: time.Now().Add(d.cfg.leaseTime)}
+		d.save()
+		fmt.Printf("DHCP: %s -> %s (%s)\n", req.chaddr, ipString(ip), d.leases[req.chaddr].hostname)
+		return d.reply(req, dhcpAck, ip)
+
+	case dhcpInform:
+		return d.reply(req, dhcpAck, [4]byte{})
+
+	case dhcpRelease, dhcpDecline:
+		if _, ok := d.leases[req.chaddr]; ok {
+			delete(d.leases, req.chaddr)
+			d.save()
+		}
+	}
+	return nil
+}
+
+func (d *DHCPServer) reply(req *dhcpMessage, msgType byte, ip [4]byte) *dhcpMessage {
+	m := &dhcpMessage{
+		op:      2,
+		xid:     req.xid,
+		flags:   req.flags,
+		ciaddr:  req.ciaddr,
+		yiaddr:  ip,
+		siaddr:  d.cfg.ip,
+		chaddr:  req.chaddr,
+		msgType: msgType,
+		options: map[byte][]byte{optServerID: d.cfg.ip[:]},
+	}
+	if msgType == dhcpNak {
+		return m
+	}
+
+	mask := d.cfg.subnet.Mask
+	network := ipToUint(d.cfg.subnet.IP)
+	broadcast := uintToIP(network | ^binary.BigEndian.Uint32(mask))
+	m.options[optSubnetMask] = []byte(mask)
+	m.options[optBroadcast] = broadcast[:]
+	// No router option: the gateway does not forward traffic off the segment.
+	m.options[optDNSServer] = d.cfg.ip[:]
+	m.options[optDomainName] = []byte(d.cfg.domain)
+	if msgType == dhcpInform {
+		return m
+	}
+
+	secs := uint32(d.cfg.leaseTime / time.Second)
+	m.options[optLeaseTime] = binary.BigEndian.AppendUint32(nil, secs)
+	m.options[optRenewalTime] = binary.BigEndian.AppendUint32(nil, secs/2)
+	m.options[optRebindTime] = binary.BigEndian.AppendUint32(nil, secs/8*7)
+	if name := d.leases[req.chaddr].hostname; name != "" {
+		m.options[optHostname] = []byte(name)
+	}
+	return m
+}
+
+// allocate picks the address for mac: its reservation, its current lease, the requested address, or the first free one.
+// Must be called with d.mu held.
+func (d *DHCPServer) allocate(mac macAddr, requested []byte) ([4]byte, error) {
+	reserved := make(map[[4]byte]macAddr)
+	for _, h := range d.hosts.get() {
+		hmac, err := net.ParseMAC(h.MAC)
+		ip := net.ParseIP(h.IP).To4()
+		if err != nil || ip == nil {
+			continue
+		}
+		reserved[[4]byte(ip)] = macAddr(hmac)
+		if macAddr(hmac) == mac {
+			if !d.cfg.subnet.Contains(ip) {
+				return [4]byte{}, fmt.Errorf("reserved address %s is outside %s", ip, d.cfg.subnet)
+			}
+			if !d.hostAddress([4]byte(ip)) {
+				return [4]byte{}, fmt.Errorf("reserved address %s is the gateway's or not a host address of %s", ip, d.cfg.subnet)
+			}
+			d.evict(mac, [4]byte(ip))
+			return [4]byte(ip), nil
+		}
+	}
+
+	now := time.Now()
+	free := func(ip [4]byte) bool {
+		if ip == d.cfg.ip || !d.cfg.subnet.Contains(ip[:]) {
+			return false
+		}
+		if owner, ok := reserved[ip]; ok && owner != mac {
+			return false
+		}
+		for owner, l := range d.leases {
+			if owner != mac && l.ip == ip && l.expires.After(now) {
+				return false
+			}
+		}
+		return true
+	}
+	inRange := func(ip [4]byte) bool {
+		v := ipToUint(ip[:])
+		return v >= d.cfg.rangeStart && v <= d.cfg.rangeEnd
+	}
+
+	if l, ok := d.leases[mac]; ok && free(l.ip) && inRange(l.ip) {
+		return l.ip, nil
+	}
+	if len(requested) == 4 && free([4]byte(requested)) && inRange([4]byte(requested)) {
+		return [4]byte(requested), nil
+	}
+	for v := d.cfg.rangeStart; v <= d.cfg.rangeEnd; v++ {
+		if ip := uintToIP(v); free(ip) {
+			return ip, nil
+		}
+	}
+	return [4]byte{}, fmt.Errorf("address pool exhausted")
+}
+
+// hostAddress reports whether ip can be handed out: a host address of the subnet other than the gateway's.
+func (d *DHCPServer) hostAddress(ip [4]byte) bool {
+	network := ipToUint(d.cfg.subnet.IP)
+	broadcast := network | ^binary.BigEndian.Uint32(d.cfg.subnet.Mask)
+	v := ipToUint(ip[:])
+	return ip != d.cfg.ip && v != network && v != broadcast
+}
+
+// evict drops the leases other clients hold on ip, which is reserved for mac. Their next
+// renewal is refused, so they pick another address. Must be called with d.mu held.
+func (d *DHCPServer) evict(mac macAddr, ip [4]byte) {
+	evicted := false
+	for owner, l := range d.leases {
+		if owner != mac && l.ip == ip {
+			fmt.Printf("DHCP: %s is reserved for %s, dropping the lease of %s\n", ipString(ip), mac, owner)
+			delete(d.leases, owner)
+			evicted = true
+		}
+	}
+	if evicted {
+		d.save()
+	}
+}
+
+// hostname prefers the name of the VM owning the MAC over what the client sends. Other clients
+// don't get to use a VM's name.
+func (d *DHCPServer) hostname(req *dhcpMessage) string {
+	name := sanitizeHostname(string(req.options[optHostname]))
+	for _, h := range d.hosts.get() {
+		if strings.EqualFold(h.MAC, req.chaddr.String()) {
+			return h.Name
+		}
+		if strings.EqualFold(h.Name, name) {
+			name = ""
+		}
+	}
+	return name
+}
+
+// Leases returns active dynamic leases plus the static reservations.
+func (d *DHCPServer) Leases() []Lease {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var list []Lease
+	seen := make(map[string]bool)
+	for _, h := range d.hosts.get() {
+		if h.IP == "" {
+			continue
+		}
+		mac := strings.ToLower(h.MAC)
+		l := Lease{MAC: mac, IP: h.IP, Hostname: h.Name, Static: true}
+		if active, ok := d.leaseFor(mac); ok {
+			l.Expires = active.expires
+		}
+		list = append(list, l)
+		seen[mac] = true
+	}
+	now := time.Now()
+	for mac, l := range d.leases {
+		if seen[mac.String()] || l.offered || l.expires.Before(now) {
+			continue
+		}
+		list = append(list, Lease{MAC: mac.String(), IP: ipString(l.ip), Hostname: l.hostname, Expires: l.expires})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return ipToUint(net.ParseIP(list[i].IP)) < ipToUint(net.ParseIP(list[j].IP))
+	})
+	return list
+}
+
+func (d *DHCPServer) leaseFor(mac string) (*lease, bool) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, false
+	}
+	l, ok := d.leases[macAddr(hw)]
+	return l, ok && !l.offered
+}
+
+// lookupName resolves a hostname to the address of an active lease or reservation. A VM's name only
+// resolves to its reservation or its own lease, whatever hostname other clients announce.
+func (d *DHCPServer) lookupName(name string) ([4]byte, bool) {
+	for _, h := range d.hosts.get() {
+		if !strings.EqualFold(h.Name, name) {
+			continue
+		}
+		if ip := net.ParseIP(h.IP).To4(); ip != nil {
+			return [4]byte(ip), true
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if l, ok := d.leaseFor(h.MAC); ok && l.expires.After(time.Now()) {
+			return l.ip, true
+		}
+		return [4]byte{}, false
+	}
+	for _, l := range d.Leases() {
+		if strings.EqualFold(l.Hostname, name) {
+			return [4]byte(net.ParseIP(l.IP).To4()), true
+		}
+	}
+	return [4]byte{}, false
+}
+
+func (d *DHCPServer) lookupIP(ip [4]byte) (string, bool) {
+	for _, l := range d.Leases() {
+		if l.IP == ipString(ip) && l.Hostname != "" {
+			return l.Hostname, true
+		}
+	}
+	return "", false
+}
+
+// load and save keep dynamic leases across switch restarts. Must be called with d.mu held, or before serving.
+func (d *DHCPServer) load() {
+	if d.path == "" {
+		return
+	}
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return
+	}
+	var list []Lease
+	if err := json.Unmarshal(data, &list); err != nil {
+		fmt.Printf("Warning: ignoring leases file %s: %v\n", d.path, err)
+		return
+	}
+	for _, l := range list {
+		mac, err := net.ParseMAC(l.MAC)
+		ip := net.ParseIP(l.IP).To4()
+		if err != nil || ip == nil {
+			continue
+		}
+		d.leases[macAddr(mac)] = &lease{ip: [4]byte(ip), hostname: l.Hostname, expires: l.Expires}
+	}
+}
+
+func (d *DHCPServer) save() {
+	if d.path == "" {
+		return
+	}
+	var list []Lease
+	for mac, l := range d.leases {
+		if l.offered {
+			continue
+		}
+		list = append(list, Lease{MAC: mac.String(), IP: ipString(l.ip), Hostname: l.hostname, Expires: l.expires})
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(d.path, data, 0644); err != nil {
+		fmt.Printf("Warning: failed to save leases: %v\n", err)
+	}
+}
+
+func ipEqual(ip [4]byte, b []byte) bool {
+	return len(b) == 4 && [4]byte(b) == ip
+}
+
+func sanitizeHostname(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
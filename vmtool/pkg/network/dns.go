@@ -0,0 +1,165 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsClassIN = 1
+
+	dnsRcodeNoError  = 0
+	dnsRcodeFormErr  = 1
+	dnsRcodeNXDomain = 3
+	dnsRcodeNotImp   = 4
+	dnsRcodeRefused  = 5
+
+	dnsTTL = 60
+)
+
+// DNSServer answers A and PTR queries for <vmname>.<domain> from the DHCP leases and reservations.
+// Names outside the domain are refused so that guests fall back to their other resolvers.
+type DNSServer struct {
+	domain string
+	dhcp   *DHCPServer
+}
+
+func newDNSServer(domain string, dhcp *DHCPServer) *DNSServer {
+	return &DNSServer{domain: strings.ToLower(domain), dhcp: dhcp}
+}
+
+// Handle returns the response to a DNS query, or nil if it should be dropped.
+func (s *DNSServer) Handle(query []byte) []byte {
+	if len(query) < 12 || query[2]&0x80 != 0 {
+		return nil
+	}
+	flags := binary.BigEndian.Uint16(query[2:4])
+	opcode := (flags >> 11) & 0xf
+	if opcode != 0 {
+		return dnsResponse(query, nil, nil, dnsRcodeNotImp, false)
+	}
+	if binary.BigEndian.Uint16(query[4:6]) != 1 {
+		return dnsResponse(query, nil, nil, dnsRcodeFormErr, false)
+	}
+	name, end, err := parseDNSName(query, 12)
+	if err != nil || end+4 > len(query) {
+		return dnsResponse(query, nil, nil, dnsRcodeFormErr, false)
+	}
+	qtype := binary.BigEndian.Uint16(query[end : end+2])
+	qclass := binary.BigEndian.Uint16(query[end+2 : end+4])
+	question := query[12 : end+4]
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	if qclass != dnsClassIN {
+		return dnsResponse(query, question, nil, dnsRcodeRefused, false)
+	}
+
+	if strings.HasSuffix(name, ".in-addr.arpa") {
+		ip, ok := parseReverseName(name)
+		if !ok || !s.dhcp.cfg.subnet.Contains(ip[:]) {
+			return dnsResponse(query, question, nil, dnsRcodeRefused, false)
+		}
+		host, ok := s.dhcp.lookupIP(ip)
+		if !ok {
+			return dnsResponse(query, question, nil, dnsRcodeNXDomain, true)
+		}
+		if qtype != dnsTypePTR {
+			return dnsResponse(query, question, nil, dnsRcodeNoError, true)
+		}
+		return dnsResponse(query, question, answer(dnsTypePTR, encodeDNSName(host+"."+s.domain)), dnsRcodeNoError, true)
+	}
+
+	if name == s.domain {
+		return dnsResponse(query, question, nil, dnsRcodeNoError, true)
+	}
+	if !strings.HasSuffix(name, "."+s.domain) {
+		return dnsResponse(query, question, nil, dnsRcodeRefused, false)
+	}
+	host := strings.TrimSuffix(name, "."+s.domain)
+	ip, ok := s.dhcp.lookupName(host)
+	if !ok {
+		return dnsResponse(query, question, nil, dnsRcodeNXDomain, true)
+	}
+	if qtype != dnsTypeA {
+		return dnsResponse(query, question, nil, dnsRcodeNoError, true)
+	}
+	return dnsResponse(query, question, answer(dnsTypeA, ip[:]), dnsRcodeNoError, true)
+}
+
+// answer encodes a resource record for the question name, referenced by a pointer to offset 12.
+func answer(rtype uint16, rdata []byte) []byte {
+	rr := []byte{0xc0, 12}
+	rr = binary.BigEndian.AppendUint16(rr, rtype)
+	rr = binary.BigEndian.AppendUint16(rr, dnsClassIN)
+	rr = binary.BigEndian.AppendUint32(rr, dnsTTL)
+	rr = binary.BigEndian.AppendUint16(rr, uint16(len(rdata)))
+	return append(rr, rdata...)
+}
+
+func dnsResponse(query, question, rr []byte, rcode uint16, authoritative bool) []byte {
+	resp := make([]byte, 12, 12+len(question)+len(rr))
+	copy(resp[0:2], query[0:2])
+	flags := binary.BigEndian.Uint16(query[2:4])
+	flags = 0x8000 | flags&0x7900 | rcode // QR, keep opcode and RD
+	if authoritative {
+		flags |= 0x0400
+	}
+	binary.BigEndian.PutUint16(resp[2:4], flags)
+	if question != nil {
+		binary.BigEndian.PutUint16(resp[4:6], 1)
+		resp = append(resp, question...)
+	}
+	if rr != nil {
+		binary.BigEndian.PutUint16(resp[6:8], 1)
+		resp = append(resp, rr...)
+	}
+	return resp
+}
+
+// parseDNSName reads an uncompressed name, as found in the question of a query.
+func parseDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	for {
+		if off >= len(msg) {
+			return "", 0, fmt.Errorf("truncated name")
+		}
+		n := int(msg[off])
+		if n == 0 {
+			return strings.Join(labels, ".") + ".", off + 1, nil
+		}
+		if n&0xc0 != 0 || off+1+n > len(msg) {
+			return "", 0, fmt.Errorf("invalid label")
+		}
+		labels = append(labels, string(msg[off+1:off+1+n]))
+		off += 1 + n
+	}
+}
+
+func encodeDNSName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func parseReverseName(name string) ([4]byte, bool) {
+	parts := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa"), ".")
+	if len(parts) != 4 {
+		return [4]byte{}, false
+	}
+	var ip [4]byte
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 || v > 255 {
+			return [4]byte{}, false
+		}
+		ip[3-i] = byte(v)
+	}
+	return ip, true
+}
@@ -0,0 +1,286 @@
+package network
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeARP  = 0x0806
+
+	protoICMP = 1
+	protoUDP  = 17
+
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+	dnsPort        = 53
+
+	defaultLeaseTime = time.Hour
+	defaultDomain    = "vm"
+)
+
+var broadcastMAC = macAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// Gateway is vmtool's own host on a virtual network. It answers ARP and ping for its
+// address and serves DHCP and DNS to the VMs on the segment. It does not route.
+type Gateway struct {
+	mac    macAddr
+	ip     [4]byte
+	subnet *net.IPNet
+	send   func(frame []byte)
+	dhcp   *DHCPServer
+	dns    *DNSServer
+}
+
+type gatewayConfig struct {
+	ip         [4]byte
+	subnet     *net.IPNet
+	rangeStart uint32
+	rangeEnd   uint32
+	leaseTime  time.Duration
+	domain     string
+}
+
+func newGateway(cfg *config.VirtualNetworkConfig, hosts *hostCache, leasesPath string) (*Gateway, error) {
+	gc, err := parseGatewayConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Locally administered MAC, so it never collides with the 52:54:00 range used by VMs.
+	sum := sha1.Sum([]byte("gateway/" + cfg.UUID))
+	mac := macAddr{0x02, sum[0], sum[1], sum[2], sum[3], sum[4]}
+
+	dhcp := newDHCPServer(gc, hosts, leasesPath)
+	return &Gateway{
+		mac:    mac,
+		ip:     gc.ip,
+		subnet: gc.subnet,
+		dhcp:   dhcp,
+		dns:    newDNSServer(gc.domain, dhcp),
+	}, nil
+}
+
+func parseGatewayConfig(cfg *config.VirtualNetworkConfig) (*gatewayConfig, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	_, subnet, err := net.ParseCIDR(cfg.Subnet)
+	if err != nil {
+		return nil, err
+	}
+	network := ipToUint(subnet.IP.To4())
+	ones, bits := subnet.Mask.Size()
+	broadcast := network | (1<<uint(bits-ones) - 1)
+
+	gc := &gatewayConfig{
+		subnet:    subnet,
+		leaseTime: defaultLeaseTime,
+		domain:    defaultDomain,
+	}
+	gw := network + 1
+	if cfg.Gateway != "" {
+		gw = ipToUint(net.ParseIP(cfg.Gateway).To4())
+	}
+	if gw == network || gw == broadcast {
+		return nil, fmt.Errorf("gateway %s is not a host address of %s", uintToIP(gw), cfg.Subnet)
+	}
+	gc.ip = uintToIP(gw)
+
+	// The allocator skips the gateway, so the default range can cover every host address.
+	gc.rangeStart, gc.rangeEnd = network+1, broadcast-1
+	if cfg.DHCPStart != "" {
+		gc.rangeStart = ipToUint(net.ParseIP(cfg.DHCPStart).To4())
+	}
+	if cfg.DHCPEnd != "" {
+		gc.rangeEnd = ipToUint(net.ParseIP(cfg.DHCPEnd).To4())
+	}
+	if gc.rangeStart <= network || gc.rangeEnd >= broadcast || gc.rangeStart > gc.rangeEnd {
+		return nil, fmt.Errorf("invalid DHCP range %s - %s", uintToIP(gc.rangeStart), uintToIP(gc.rangeEnd))
+	}
+
+	if cfg.LeaseTime != "" {
+		gc.leaseTime, _ = time.ParseDuration(cfg.LeaseTime)
+	}
+	if cfg.Domain != "" {
+		gc.domain = cfg.Domain
+	}
+	return gc, nil
+}
+
+func (g *Gateway) Leases() []Lease {
+	return g.dhcp.Leases()
+}
+
+// HandleFrame processes one Ethernet frame delivered by the switch.
+func (g *Gateway) HandleFrame(frame []byte) {
+	if len(frame) < 14 {
+		return
+	}
+	var src macAddr
+	copy(src[:], frame[6:12])
+	payload := frame[14:]
+
+	switch binary.BigEndian.Uint16(frame[12:14]) {
+	case etherTypeARP:
+		g.handleARP(payload)
+	case etherTypeIPv4:
+		g.handleIPv4(src, payload)
+	}
+}
+
+func (g *Gateway) handleARP(pkt []byte) {
+	if len(pkt) < 28 || binary.BigEndian.Uint16(pkt[0:2]) != 1 || binary.BigEndian.Uint16(pkt[2:4]) != etherTypeIPv4 {
+		return
+	}
+	// Only requests for our own address
+	if binary.BigEndian.Uint16(pkt[6:8]) != 1 || [4]byte(pkt[24:28]) != g.ip {
+		return
+	}
+	var sender macAddr
+	copy(sender[:], pkt[8:14])
+
+	reply := make([]byte, 28)
+	copy(reply[0:6], pkt[0:6]) // htype, ptype, hlen, plen
+	binary.BigEndian.PutUint16(reply[6:8], 2)
+	copy(reply[8:14], g.mac[:])
+	copy(reply[14:18], g.ip[:])
+	copy(reply[18:24], pkt[8:14])
+	copy(reply[24:28], pkt[14:18])
+	g.send(ethernetFrame(sender, g.mac, etherTypeARP, reply))
+}
+
+func (g *Gateway) handleIPv4(src macAddr, pkt []byte) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 {
+		return
+	}
+	ihl := int(pkt[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(pkt[2:4]))
+	if ihl < 20 || total < ihl || total > len(pkt) {
+		return
+	}
+	// Fragments are not reassembled
+	if binary.BigEndian.Uint16(pkt[6:8])&0x3fff != 0 {
+		return
+	}
+	srcIP, dstIP := [4]byte(pkt[12:16]), [4]byte(pkt[16:20])
+	body := pkt[ihl:total]
+
+	switch pkt[9] {
+	case protoICMP:
+		if dstIP == g.ip && len(body) >= 8 && body[0] == 8 {
+			reply := append([]byte{}, body...)
+			reply[0] = 0 // echo reply
+			reply[2], reply[3] = 0, 0
+			binary.BigEndian.PutUint16(reply[2:4], checksum(reply))
+			g.send(ethernetFrame(src, g.mac, etherTypeIPv4, ipv4Packet(g.ip, srcIP, protoICMP, reply)))
+		}
+	case protoUDP:
+		if len(body) < 8 {
+			return
+		}
+		srcPort := binary.BigEndian.Uint16(body[0:2])
+		dstPort := binary.BigEndian.Uint16(body[2:4])
+		udpLen := int(binary.BigEndian.Uint16(body[4:6]))
+		if udpLen < 8 || udpLen > len(body) {
+			return
+		}
+		data := body[8:udpLen]
+
+		switch {
+		case dstPort == dhcpServerPort && (dstIP == g.ip || dstIP == [4]byte{255, 255, 255, 255}):
+			g.handleDHCP(data)
+		case dstPort == dnsPort && dstIP == g.ip:
+			if reply := g.dns.Handle(data); reply != nil {
+				g.send(ethernetFrame(src, g.mac, etherTypeIPv4, udpPacket(g.ip, srcIP, dnsPort, srcPort, reply)))
+			}
+		}
+	}
+}
+
+func (g *Gateway) handleDHCP(data []byte) {
+	req, err := parseDHCP(data)
+	if err != nil {
+		return
+	}
+	reply := g.dhcp.Handle(req)
+	if reply == nil {
+		return
+	}
+
+	dstMAC, dstIP := req.chaddr, reply.yiaddr
+	switch {
+	case req.ciaddr != [4]byte{}:
+		dstIP = req.ciaddr
+	case req.flags&0x8000 != 0 || reply.msgType == dhcpNak:
+		dstMAC, dstIP = broadcastMAC, [4]byte{255, 255, 255, 255}
+	}
+	g.send(ethernetFrame(dstMAC, g.mac, etherTypeIPv4,
+		udpPacket(g.ip, dstIP, dhcpServerPort, dhcpClientPort, reply.marshal())))
+}
+
+func ethernetFrame(dst, src macAddr, etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 14+len(payload))
+	copy(frame[0:6], dst[:])
+	copy(frame[6:12], src[:])
+	binary.BigEndian.PutUint16(frame[12:14], etherType)
+	copy(frame[14:], payload)
+	return frame
+}
+
+func ipv4Packet(src, dst [4]byte, proto byte, payload []byte) []byte {
+	pkt := make([]byte, 20+len(payload))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	pkt[8] = 64 // TTL
+	pkt[9] = proto
+	copy(pkt[12:16], src[:])
+	copy(pkt[16:20], dst[:])
+	binary.BigEndian.PutUint16(pkt[10:12], checksum(pkt[:20]))
+	copy(pkt[20:], payload)
+	return pkt
+}
+
+// udpPacket builds an IPv4 UDP datagram. The UDP checksum is optional over IPv4 and left zero.
+func udpPacket(src, dst [4]byte, srcPort, dstPort uint16, payload []byte) []byte {
+	udp := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], srcPort)
+	binary.BigEndian.PutUint16(udp[2:4], dstPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[8:], payload)
+	return ipv4Packet(src, dst, protoUDP, udp)
+}
+
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+func ipToUint(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uintToIP(v uint32) [4]byte {
+	var ip [4]byte
+	binary.BigEndian.PutUint32(ip[:], v)
+	return ip
+}
+
+func ipString(ip [4]byte) string {
+	return net.IP(ip[:]).String()
+}
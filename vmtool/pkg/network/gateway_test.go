@@ -0,0 +1,184 @@
+package network
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+func TestGatewayDHCPAndDNS(t *testing.T) {
+	cfg := &config.VirtualNetworkConfig{Name: "lab1", UUID: "net-uuid", Subnet: "10.77.0.0/24"}
+	hosts := func() []Host {
+		return []Host{
+			{Name: "web", MAC: "52:54:00:00:00:01"},
+			{Name: "db", MAC: "52:54:00:00:00:02", IP: "10.77.0.50"},
+		}
+	}
+	gw, err := newGateway(cfg, newHostCache(hosts), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent [][]byte
+	gw.send = func(frame []byte) { sent = append(sent, frame) }
+
+	web := macAddr{0x52, 0x54, 0x00, 0, 0, 1}
+	db := macAddr{0x52, 0x54, 0x00, 0, 0, 2}
+
+	// Dynamic lease from the start of the range, skipping the gateway at .1
+	offer := dhcpExchange(t, gw, &sent, web, dhcpDiscover, nil)
+	if offer.msgType != dhcpOffer || ipString(offer.yiaddr) != "10.77.0.2" {
+		t.Fatalf("unexpected offer %d for %s", offer.msgType, ipString(offer.yiaddr))
+	}
+	ack := dhcpExchange(t, gw, &sent, web, dhcpRequest, offer.yiaddr[:])
+	if ack.msgType != dhcpAck || string(ack.options[optHostname]) != "web" {
+		t.Fatalf("unexpected ack %d with hostname %q", ack.msgType, ack.options[optHostname])
+	}
+
+	// Static reservation keyed by MAC
+	offer = dhcpExchange(t, gw, &sent, db, dhcpDiscover, nil)
+	if ipString(offer.yiaddr) != "10.77.0.50" {
+		t.Fatalf("expected reserved address, got %s", ipString(offer.yiaddr))
+	}
+	// Requesting somebody else's address is refused
+	nak := dhcpExchange(t, gw, &sent, db, dhcpRequest, []byte{10, 77, 0, 2})
+	if nak.msgType != dhcpNak {
+		t.Fatalf("expected NAK, got %d", nak.msgType)
+	}
+
+	leases := gw.Leases()
+	if len(leases) != 2 || leases[0].Hostname != "web" || !leases[1].Static {
+		t.Fatalf("unexpected leases %+v", leases)
+	}
+
+	// <vmname>.vm resolves from leases and reservations
+	for name, want := range map[string]string{"web.vm.": "10.77.0.2", "DB.vm.": "10.77.0.50"} {
+		resp := gw.dns.Handle(dnsQuery(name, dnsTypeA))
+		if rcode := resp[3] & 0x0f; rcode != dnsRcodeNoError || binary.BigEndian.Uint16(resp[6:8]) != 1 {
+			t.Fatalf("%s: rcode %d, answers %d", name, rcode, binary.BigEndian.Uint16(resp[6:8]))
+		}
+		if got := net.IP(resp[len(resp)-4:]).String(); got != want {
+			t.Errorf("%s resolved to %s, want %s", name, got, want)
+		}
+	}
+	if rcode := gw.dns.Handle(dnsQuery("missing.vm.", dnsTypeA))[3] & 0x0f; rcode != dnsRcodeNXDomain {
+		t.Errorf("expected NXDOMAIN, got %d", rcode)
+	}
+	if rcode := gw.dns.Handle(dnsQuery("example.com.", dnsTypeA))[3] & 0x0f; rcode != dnsRcodeRefused {
+		t.Errorf("expected REFUSED for names outside the domain, got %d", rcode)
+	}
+}
+
+func TestDHCPReservationConflicts(t *testing.T) {
+	cfg := &config.VirtualNetworkConfig{Name: "lab1", UUID: "net-uuid", Subnet: "10.77.0.0/24"}
+	var hosts []Host
+	cache := newHostCache(func() []Host { return hosts })
+	gw, err := newGateway(cfg, cache, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent [][]byte
+	gw.send = func(frame []byte) { sent = append(sent, frame) }
+	web := macAddr{0x52, 0x54, 0x00, 0, 0, 1}
+	db := macAddr{0x52, 0x54, 0x00, 0, 0, 2}
+	router := macAddr{0x52, 0x54, 0x00, 0, 0, 3}
+
+	offer := dhcpExchange(t, gw, &sent, web, dhcpDiscover, nil)
+	dhcpExchange(t, gw, &sent, web, dhcpRequest, offer.yiaddr[:])
+
+	// db gets a reservation on the address web leased, router one on the gateway's.
+	hosts = []Host{
+		{Name: "db", MAC: db.String(), IP: ipString(offer.yiaddr)},
+		{Name: "router", MAC: router.String(), IP: "10.77.0.1"},
+	}
+	cache.fetched = time.Time{}
+	if got := dhcpExchange(t, gw, &sent, db, dhcpDiscover, nil); got.yiaddr != offer.yiaddr {
+		t.Fatalf("reserved address not offered, got %s", ipString(got.yiaddr))
+	}
+	if nak := dhcpExchange(t, gw, &sent, web, dhcpRequest, offer.yiaddr[:]); nak.msgType != dhcpNak {
+		t.Errorf("renewing an address reserved for another VM: got %d, want NAK", nak.msgType)
+	}
+	req := &dhcpMessage{op: 1, xid: 42, chaddr: router, msgType: dhcpDiscover, options: map[byte][]byte{}}
+	if reply := gw.dhcp.Handle(req); reply != nil {
+		t.Errorf("the gateway's address was offered as %s", ipString(reply.yiaddr))
+	}
+
+	for addr, ok := range map[string]bool{"10.77.0.10": true, "10.77.0.1": false, "10.77.0.0": false, "10.77.0.255": false, "10.78.0.10": false} {
+		if err := cfg.CheckReservation(addr); (err == nil) != ok {
+			t.Errorf("reservation %s: got %v", addr, err)
+		}
+	}
+}
+
+func TestDNSNamesOfVMs(t *testing.T) {
+	cfg := &config.VirtualNetworkConfig{Name: "lab1", UUID: "net-uuid", Subnet: "10.77.0.0/24"}
+	db := macAddr{0x52, 0x54, 0x00, 0, 0, 2}
+	rogue := macAddr{0x52, 0x54, 0x00, 0, 0, 9}
+	hosts := []Host{{Name: "db", MAC: db.String()}, {Name: "web", MAC: "52:54:00:00:00:01", IP: "10.77.0.20"}}
+	gw, err := newGateway(cfg, newHostCache(func() []Host { return hosts }), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease := func(mac macAddr, hostname string) [4]byte {
+		t.Helper()
+		req := &dhcpMessage{op: 1, xid: 42, chaddr: mac, msgType: dhcpDiscover, options: map[byte][]byte{optHostname: []byte(hostname)}}
+		offer := gw.dhcp.Handle(req)
+		if offer == nil {
+			t.Fatalf("no offer for %s", mac)
+		}
+		req.msgType = dhcpRequest
+		req.options[optRequestedIP] = offer.yiaddr[:]
+		if ack := gw.dhcp.Handle(req); ack == nil || ack.msgType != dhcpAck {
+			t.Fatalf("no lease for %s", mac)
+		}
+		return offer.yiaddr
+	}
+
+	// A client announcing the names of VMs gets neither.
+	lease(rogue, "db")
+	if ip, ok := gw.dhcp.lookupName("db"); ok {
+		t.Errorf("db resolved to %s before it had a lease", ipString(ip))
+	}
+	if ip, ok := gw.dhcp.lookupName("web"); !ok || ipString(ip) != "10.77.0.20" {
+		t.Errorf("web resolved to %s, want its reservation", ipString(ip))
+	}
+	want := lease(db, "")
+	if ip, ok := gw.dhcp.lookupName("db"); !ok || ip != want {
+		t.Errorf("db resolved to %s, want %s", ipString(ip), ipString(want))
+	}
+	if name, ok := gw.dhcp.lookupIP(lease(rogue, "db")); ok {
+		t.Errorf("the rogue client is known as %s", name)
+	}
+}
+
+func dhcpExchange(t *testing.T, gw *Gateway, sent *[][]byte, mac macAddr, msgType byte, requested []byte) *dhcpMessage {
+	t.Helper()
+	req := &dhcpMessage{op: 1, xid: 42, chaddr: mac, msgType: msgType, options: map[byte][]byte{}}
+	if requested != nil {
+		req.options[optRequestedIP] = requested
+	}
+	*sent = nil
+	gw.HandleFrame(ethernetFrame(broadcastMAC, mac, etherTypeIPv4,
+		udpPacket([4]byte{}, [4]byte{255, 255, 255, 255}, dhcpClientPort, dhcpServerPort, req.marshal())))
+	if len(*sent) != 1 {
+		t.Fatalf("expected one reply, got %d", len(*sent))
+	}
+	frame := (*sent)[0]
+	// Ethernet (14) + IPv4 (20) + UDP (8); BOOTREPLY has op 2, so patch it for the request parser.
+	payload := append([]byte{}, frame[42:]...)
+	payload[0] = 1
+	reply, err := parseDHCP(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func dnsQuery(name string, qtype uint16) []byte {
+	q := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	q = append(q, encodeDNSName(name)...)
+	q = binary.BigEndian.AppendUint16(q, qtype)
+	return binary.BigEndian.AppendUint16(q, dnsClassIN)
+}
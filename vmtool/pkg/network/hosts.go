@@ -0,0 +1,54 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+// Host is a VM NIC attached to a network, used for DHCP reservations, DNS names and link impairment.
+type Host struct {
+	Name string
+	MAC  string
+	IP   string // static reservation, optional
+
+	Impairment *config.ImpairmentConfig
+}
+
+type HostFunc func() []Host
+
+// hostCache avoids re-reading every VM config for each DHCP or DNS packet.
+type hostCache struct {
+	fn      HostFunc
+	hosts   []Host
+	fetched time.Time
+	mu      sync.Mutex
+}
+
+func newHostCache(fn HostFunc) *hostCache {
+	return &hostCache{fn: fn}
+}
+
+func (c *hostCache) get() []Host {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fn == nil {
+		return nil
+	}
+	if time.Since(c.fetched) > 5*time.Second {
+		c.hosts = c.fn()
+		c.fetched = time.Now()
+	}
+	return c.hosts
+}
+
+func (c *hostCache) impairment(mac macAddr) *config.ImpairmentConfig {
+	for _, h := range c.get() {
+		if hw, err := net.ParseMAC(h.MAC); err == nil && macAddr(hw) == mac {
+			return h.Impairment
+		}
+	}
+	return nil
+}
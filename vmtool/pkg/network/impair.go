@@ -0,0 +1,179 @@
+package network
+
+import (
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+// Frames held back by latency or bandwidth limits, per port. Beyond this they are dropped like a full router queue.
+const impairQueueDepth = 1000
+
+// impairment is the parsed form of a validated config.ImpairmentConfig.
+type impairment struct {
+	cfg       config.ImpairmentConfig
+	latency   time.Duration
+	jitter    time.Duration
+	loss      float64
+	bandwidth int64 // bits per second
+}
+
+type delayedFrame struct {
+	frame []byte
+	at    time.Time
+}
+
+func newImpairment(cfg *config.ImpairmentConfig) *impairment {
+	if cfg == nil {
+		return nil
+	}
+	imp := &impairment{cfg: *cfg, loss: cfg.Loss}
+	imp.latency, _ = time.ParseDuration(cfg.Latency)
+	imp.jitter, _ = time.ParseDuration(cfg.Jitter)
+	if cfg.Bandwidth != "" {
+		imp.bandwidth, _ = config.ParseBandwidth(cfg.Bandwidth)
+	}
+	return imp
+}
+
+func (i *impairment) partition() string {
+	if i == nil {
+		return ""
+	}
+	return i.cfg.Partition
+}
+
+// SetImpairment overrides the impairment of the NIC with the given MAC until the switch restarts.
+// nil goes back to what the VM's configuration says.
+func (s *Switch) SetImpairment(mac string, cfg *config.ImpairmentConfig) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+	if cfg != nil {
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+	}
+
+	var imp *impairment
+	if cfg != nil {
+		saved := *cfg
+		cfg = &saved
+		imp = newImpairment(cfg)
+	} else if s.hosts != nil {
+		imp = newImpairment(s.hosts.impairment(macAddr(hw)))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cfg == nil {
+		delete(s.overrides, macAddr(hw))
+	} else {
+		s.overrides[macAddr(hw)] = cfg
+	}
+	if p, ok := s.macs[macAddr(hw)]; ok {
+		p.imp = imp
+	}
+	return nil
+}
+
+// Impairment returns the impairment in effect for the NIC with the given MAC.
+func (s *Switch) Impairment(mac string) (*config.ImpairmentConfig, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+	if imp := s.resolveImpairment(macAddr(hw)); imp != nil {
+		cfg := imp.cfg
+		return &cfg, nil
+	}
+	return nil, nil
+}
+
+// resolveImpairment must be called without s.mu held: the VM configurations it falls back to
+// may be read from disk, which frames passing the switch must not wait for.
+func (s *Switch) resolveImpairment(mac macAddr) *impairment {
+	s.mu.Lock()
+	cfg, ok := s.overrides[mac]
+	s.mu.Unlock()
+	if ok {
+		return newImpairment(cfg)
+	}
+	if s.hosts != nil {
+		return newImpairment(s.hosts.impairment(mac))
+	}
+	return nil
+}
+
+// ingress applies the sending NIC's impairment before a frame enters the switch.
+func (s *Switch) ingress(p *port, frame []byte) {
+	var src macAddr
+	copy(src[:], frame[6:12])
+	s.mu.Lock()
+	known := p.local || s.macs[src] == p
+	s.mu.Unlock()
+	var resolved *impairment
+	if !known {
+		resolved = s.resolveImpairment(src)
+	}
+
+	s.mu.Lock()
+	s.learn(p, src, resolved)
+	imp := p.imp
+	if imp != nil && imp.loss > 0 && rand.Float64()*100 < imp.loss {
+		p.lost++
+		imp = nil
+		frame = nil
+	}
+	s.mu.Unlock()
+
+	if frame == nil {
+		return
+	}
+	if imp == nil || (imp.latency == 0 && imp.jitter == 0 && imp.bandwidth == 0) {
+		s.forward(p, frame)
+		return
+	}
+
+	// Only readLoop touches linkFree and lastRelease.
+	now := time.Now()
+	at := now
+	linkFree := p.linkFree
+	if imp.bandwidth > 0 {
+		if linkFree.Before(now) {
+			linkFree = now
+		}
+		linkFree = linkFree.Add(time.Duration(int64(len(frame)) * 8 * int64(time.Second) / imp.bandwidth))
+		at = linkFree
+	}
+	at = at.Add(imp.latency)
+	if imp.jitter > 0 {
+		at = at.Add(time.Duration((rand.Float64()*2 - 1) * float64(imp.jitter)))
+	}
+	// Keep frames in order, jitter must not reorder a TCP stream into retransmits.
+	if at.Before(p.lastRelease) {
+		at = p.lastRelease
+	}
+
+	select {
+	case p.delayed <- delayedFrame{frame: frame, at: at}:
+		p.linkFree = linkFree
+		p.lastRelease = at
+	default:
+		s.mu.Lock()
+		p.lost++
+		s.mu.Unlock()
+	}
+}
+
+func (s *Switch) delayLoop(p *port) {
+	for d := range p.delayed {
+		if wait := time.Until(d.at); wait > 0 {
+			time.Sleep(wait)
+		}
+		s.forward(p, d.frame)
+	}
+}
@@ -0,0 +1,49 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// EnsureRunning starts a detached `vmtool network run` process for the named network unless one is already serving it.
+// The switch outlives the process that started it, like the QEMU processes connected to it.
+func EnsureRunning(name string) error {
+	if IsRunning(name) {
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir := RuntimeDir(name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(filepath.Join(dir, "switch.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, "network", "run", name)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start network %s: %v", name, err)
+	}
+	cmd.Process.Release()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if IsRunning(name) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("network %s did not come up, see %s", name, filepath.Join(dir, "switch.log"))
+}
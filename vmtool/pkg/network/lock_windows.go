@@ -0,0 +1,27 @@
+//go:build windows
+
+package network
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile opens path without sharing, which keeps other processes out until the file is closed or
+// the process exits. It returns errLocked right away if another switch has it open.
+func lockFile(path string) (*os.File, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		const errorSharingViolation syscall.Errno = 32
+		if errors.Is(err, errorSharingViolation) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
@@ -0,0 +1,114 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/utmapp/vmtool/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+type Store struct {
+	baseDir  string
+	networks map[string]*config.VirtualNetworkConfig
+	mu       sync.RWMutex
+}
+
+func NewStore(baseDir string) (*Store, error) {
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		baseDir:  baseDir,
+		networks: make(map[string]*config.VirtualNetworkConfig),
+	}
+	if err := s.LoadAll(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) LoadAll() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := os.ReadDir(s.baseDir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if filepath.Ext(f.Name()) == ".yaml" {
+			cfg, err := s.loadNetwork(filepath.Join(s.baseDir, f.Name()))
+			if err != nil {
+				fmt.Printf("Warning: failed to load network config %s: %v\n", f.Name(), err)
+				continue
+			}
+			s.networks[cfg.Name] = cfg
+		}
+	}
+	return nil
+}
+
+func (s *Store) loadNetwork(path string) (*config.VirtualNetworkConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg config.VirtualNetworkConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (s *Store) SaveNetwork(cfg *config.VirtualNetworkConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.baseDir, cfg.Name+".yaml")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	s.networks[cfg.Name] = cfg
+	return nil
+}
+
+func (s *Store) GetNetwork(name string) (*config.VirtualNetworkConfig, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cfg, ok := s.networks[name]
+	return cfg, ok
+}
+
+func (s *Store) ListNetworks() []*config.VirtualNetworkConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var list []*config.VirtualNetworkConfig
+	for _, n := range s.networks {
+		list = append(list, n)
+	}
+	return list
+}
+
+func (s *Store) DeleteNetwork(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := filepath.Join(s.baseDir, name+".yaml")
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(s.networks, name)
+	return nil
+}
@@ -0,0 +1,309 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+// Frames on a QEMU socket/stream netdev are prefixed with their length as a 4-byte big-endian integer.
+const (
+	maxFrameSize   = 1 << 17
+	portQueueDepth = 256
+)
+
+type macAddr [6]byte
+
+func (m macAddr) String() string {
+	return net.HardwareAddr(m[:]).String()
+}
+
+// Switch is a learning Ethernet switch that connects the QEMU stream netdevs of every VM attached to a virtual network.
+type Switch struct {
+	name      string
+	ports     map[int]*port
+	macs      map[macAddr]*port
+	overrides map[macAddr]*config.ImpairmentConfig
+	hosts     *hostCache
+	nextID    int
+	mu        sync.Mutex
+}
+
+type port struct {
+	id          int
+	conn        net.Conn // nil for in-process endpoints
+	local       bool
+	out         chan []byte
+	closed      bool
+	connectedAt time.Time
+	rxFrames    uint64
+	txFrames    uint64
+	dropped     uint64
+
+	// Link impairment of the NIC behind this port, resolved from the MAC it sends with
+	imp         *impairment
+	delayed     chan delayedFrame
+	linkFree    time.Time
+	lastRelease time.Time
+	lost        uint64
+}
+
+type PortStatus struct {
+	ID          int       `json:"id"`
+	Local       bool      `json:"local,omitempty"`
+	MACs        []string  `json:"macs"`
+	ConnectedAt time.Time `json:"connected_at"`
+	RxFrames    uint64    `json:"rx_frames"`
+	TxFrames    uint64    `json:"tx_frames"`
+	Dropped     uint64    `json:"dropped"`
+	Lost        uint64    `json:"lost"` // dropped by impairment
+
+	Impairment *config.ImpairmentConfig `json:"impairment,omitempty"`
+}
+
+func NewSwitch(name string) *Switch {
+	return &Switch{
+		name:      name,
+		ports:     make(map[int]*port),
+		macs:      make(map[macAddr]*port),
+		overrides: make(map[macAddr]*config.ImpairmentConfig),
+	}
+}
+
+// Serve accepts QEMU connections on l until it is closed.
+func (s *Switch) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		p := s.addPort(conn)
+		go s.writeLoop(p)
+		go s.delayLoop(p)
+		go s.readLoop(p)
+	}
+}
+
+func (s *Switch) Ports() []PortStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []PortStatus
+	for _, p := range s.ports {
+		st := PortStatus{
+			ID:          p.id,
+			Local:       p.local,
+			ConnectedAt: p.connectedAt,
+			RxFrames:    p.rxFrames,
+			TxFrames:    p.txFrames,
+			Dropped:     p.dropped,
+			Lost:        p.lost,
+		}
+		if p.imp != nil {
+			cfg := p.imp.cfg
+			st.Impairment = &cfg
+		}
+		for mac, owner := range s.macs {
+			if owner == p {
+				st.MACs = append(st.MACs, mac.String())
+			}
+		}
+		list = append(list, st)
+	}
+	return list
+}
+
+// Close disconnects every port.
+func (s *Switch) Close() {
+	s.mu.Lock()
+	var conns []net.Conn
+	for _, p := range s.ports {
+		if p.conn != nil {
+			conns = append(conns, p.conn)
+		}
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// AttachLocal connects an in-process endpoint such as the gateway. Frames for it are passed
+// to handle one at a time, and it sends frames with the returned function.
+func (s *Switch) AttachLocal(handle func(frame []byte)) func(frame []byte) {
+	p := s.addPort(nil)
+	s.mu.Lock()
+	p.local = true
+	s.mu.Unlock()
+	go func() {
+		for frame := range p.out {
+			handle(frame)
+		}
+	}()
+	return func(frame []byte) {
+		s.ingress(p, frame)
+	}
+}
+
+func (s *Switch) addPort(conn net.Conn) *port {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	p := &port{
+		id:          s.nextID,
+		conn:        conn,
+		out:         make(chan []byte, portQueueDepth),
+		delayed:     make(chan delayedFrame, impairQueueDepth),
+		connectedAt: time.Now(),
+	}
+	s.ports[p.id] = p
+	if conn != nil {
+		log.Printf("network %s: port %d connected", s.name, p.id)
+	}
+	return p
+}
+
+func (s *Switch) removePort(p *port) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.out)
+	delete(s.ports, p.id)
+	for mac, owner := range s.macs {
+		if owner == p {
+			delete(s.macs, mac)
+		}
+	}
+	log.Printf("network %s: port %d disconnected", s.name, p.id)
+}
+
+func (s *Switch) readLoop(p *port) {
+	defer func() {
+		close(p.delayed)
+		p.conn.Close()
+		s.removePort(p)
+	}()
+	for {
+		frame, err := readFrame(p.conn)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("network %s: port %d: %v", s.name, p.id, err)
+			}
+			return
+		}
+		if len(frame) < 14 {
+			continue
+		}
+		s.ingress(p, frame)
+	}
+}
+
+func (s *Switch) writeLoop(p *port) {
+	for frame := range p.out {
+		if err := writeFrame(p.conn, frame); err != nil {
+			p.conn.Close()
+			break
+		}
+	}
+	// Drain so that forward never blocks on a dead port.
+	for range p.out {
+	}
+}
+
+func (s *Switch) forward(from *port, frame []byte) {
+	var dst, src macAddr
+	copy(dst[:], frame[0:6])
+	copy(src[:], frame[6:12])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if from.closed {
+		return
+	}
+	from.rxFrames++
+	s.learn(from, src, from.imp)
+
+	if dst[0]&1 == 0 {
+		if to, ok := s.macs[dst]; ok {
+			if to != from && reachable(from, to) {
+				s.enqueue(to, frame)
+			}
+			return
+		}
+	}
+	// Broadcast, multicast or unknown unicast: flood.
+	for _, to := range s.ports {
+		if to != from && reachable(from, to) {
+			s.enqueue(to, frame)
+		}
+	}
+}
+
+// learn records which port a source MAC lives behind, and imp as the impairment of the NIC
+// unless an override was set meanwhile. Must be called with s.mu held.
+func (s *Switch) learn(p *port, src macAddr, imp *impairment) {
+	if src[0]&1 != 0 || p.closed || s.macs[src] == p {
+		return
+	}
+	s.macs[src] = p
+	if !p.local {
+		if cfg, ok := s.overrides[src]; ok {
+			imp = newImpairment(cfg)
+		}
+		p.imp = imp
+	}
+}
+
+// reachable applies partitions. In-process endpoints like the gateway stay reachable from every partition.
+func reachable(from, to *port) bool {
+	return from.local || to.local || from.imp.partition() == to.imp.partition()
+}
+
+// enqueue must be called with s.mu held.
+func (s *Switch) enqueue(p *port, frame []byte) {
+	if p.closed {
+		return
+	}
+	select {
+	case p.out <- frame:
+		p.txFrames++
+	default:
+		p.dropped++
+	}
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxFrameSize {
+		return nil, fmt.Errorf("frame too large (%d bytes)", n)
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func writeFrame(w io.Writer, frame []byte) error {
+	buf := make([]byte, 4+len(frame))
+	binary.BigEndian.PutUint32(buf, uint32(len(frame)))
+	copy(buf[4:], frame)
+	_, err := w.Write(buf)
+	return err
+}
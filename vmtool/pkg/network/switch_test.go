@@ -0,0 +1,140 @@
+package network
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+func TestSwitchForwarding(t *testing.T) {
+	sw := NewSwitch("test")
+	a, b, c := connectPort(sw), connectPort(sw), connectPort(sw)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	macA := []byte{0x52, 0x54, 0x00, 0, 0, 0xa}
+	macB := []byte{0x52, 0x54, 0x00, 0, 0, 0xb}
+	broadcast := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	// A broadcast from A is flooded to B and C, and teaches the switch where A is.
+	hello := ethFrame(broadcast, macA, "hello")
+	writeFrame(a, hello)
+	expectFrame(t, b, hello)
+	expectFrame(t, c, hello)
+
+	// B learns too, after which unicast between A and B no longer reaches C.
+	reply := ethFrame(macA, macB, "reply")
+	writeFrame(b, reply)
+	expectFrame(t, a, reply)
+
+	unicast := ethFrame(macB, macA, "unicast")
+	writeFrame(a, unicast)
+	expectFrame(t, b, unicast)
+
+	c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if frame, err := readFrame(c); err == nil {
+		t.Errorf("unexpected frame on port c: %x", frame)
+	}
+}
+
+func TestSwitchImpairment(t *testing.T) {
+	sw := NewSwitch("test")
+	a, b, c := connectPort(sw), connectPort(sw), connectPort(sw)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	macA := []byte{0x52, 0x54, 0x00, 0, 0, 0xa}
+	macB := []byte{0x52, 0x54, 0x00, 0, 0, 0xb}
+	macC := []byte{0x52, 0x54, 0x00, 0, 0, 0xc}
+	broadcast := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	sw.SetImpairment(net.HardwareAddr(macA).String(), &config.ImpairmentConfig{Latency: "150ms", Partition: "left"})
+	sw.SetImpairment(net.HardwareAddr(macB).String(), &config.ImpairmentConfig{Partition: "left"})
+	sw.SetImpairment(net.HardwareAddr(macC).String(), &config.ImpairmentConfig{Partition: "right"})
+
+	// B and C announce themselves so the switch knows their partitions.
+	writeFrame(b, ethFrame(broadcast, macB, "b"))
+	writeFrame(c, ethFrame(broadcast, macC, "c"))
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	hello := ethFrame(broadcast, macA, "hello")
+	writeFrame(a, hello)
+	expectFrame(t, b, hello)
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("frame arrived after %s, want at least 150ms", elapsed)
+	}
+
+	// The partition also hides C from A's broadcasts.
+	c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if frame, err := readFrame(c); err == nil {
+		t.Errorf("frame crossed the partition: %x", frame)
+	}
+}
+
+func TestSwitchImpairmentFromHosts(t *testing.T) {
+	sw := NewSwitch("test")
+	macA := []byte{0x52, 0x54, 0x00, 0, 0, 0xa}
+	var underLock atomic.Bool
+	sw.hosts = newHostCache(func() []Host {
+		// Reading VM configurations must not hold up every frame on the switch.
+		if sw.mu.TryLock() {
+			sw.mu.Unlock()
+		} else {
+			underLock.Store(true)
+		}
+		return []Host{{Name: "a", MAC: net.HardwareAddr(macA).String(), Impairment: &config.ImpairmentConfig{Latency: "10ms"}}}
+	})
+	a, b := connectPort(sw), connectPort(sw)
+	defer a.Close()
+	defer b.Close()
+
+	hello := ethFrame([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, macA, "hello")
+	writeFrame(a, hello)
+	expectFrame(t, b, hello)
+	if underLock.Load() {
+		t.Error("hosts were read with the switch locked")
+	}
+	if imp, _ := sw.Impairment(net.HardwareAddr(macA).String()); imp == nil || imp.Latency != "10ms" {
+		t.Errorf("impairment from the VM configuration: got %+v", imp)
+	}
+
+	// The switch keeps its own copy of an override.
+	override := &config.ImpairmentConfig{Latency: "20ms"}
+	sw.SetImpairment(net.HardwareAddr(macA).String(), override)
+	override.Latency = "1s"
+	if imp, _ := sw.Impairment(net.HardwareAddr(macA).String()); imp == nil || imp.Latency != "20ms" {
+		t.Errorf("override: got %+v", imp)
+	}
+}
+
+func connectPort(sw *Switch) net.Conn {
+	client, server := net.Pipe()
+	p := sw.addPort(server)
+	go sw.writeLoop(p)
+	go sw.delayLoop(p)
+	go sw.readLoop(p)
+	return client
+}
+
+func ethFrame(dst, src []byte, payload string) []byte {
+	frame := append(append(append([]byte{}, dst...), src...), 0x88, 0xb5)
+	return append(frame, payload...)
+}
+
+func expectFrame(t *testing.T, conn net.Conn, want []byte) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	got, err := readFrame(conn)
+	if err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got frame %x, want %x", got, want)
+	}
+}
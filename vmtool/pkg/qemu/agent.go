@@ -0,0 +1,295 @@
+package qemu
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// GuestAgentChannel is the virtio-serial port name qemu-ga looks for in the guest.
+const GuestAgentChannel = "org.qemu.guest_agent.0"
+
+// DefaultAgentTimeout bounds a single guest agent command.
+const DefaultAgentTimeout = 10 * time.Second
+
+// GuestAgentSocketPath is the unix socket QEMU connects the guest agent's channel to.
+func GuestAgentSocketPath(name string) string {
+	return filepath.Join(RuntimeDir(name), "qga.sock")
+}
+
+func guestAgentArgs(name string) []string {
+	return []string{
+		"-chardev", "socket,id=qga0,path=" + GuestAgentSocketPath(name) + ",server=on,wait=off",
+		"-device", "virtserialport,bus=virtio-serial0.0,chardev=qga0,name=" + GuestAgentChannel,
+	}
+}
+
+// GuestAgent talks to qemu-ga running inside a guest. Unlike QMP there is no greeting, and the channel
+// outlives connections, so every command is preceded by a guest-sync-delimited exchange that skips
+// whatever an earlier, abandoned command left behind.
+type GuestAgent struct {
+	socketPath string
+	Timeout    time.Duration
+}
+
+func NewGuestAgent(socketPath string) *GuestAgent {
+	return &GuestAgent{socketPath: socketPath, Timeout: DefaultAgentTimeout}
+}
+
+// agentLocks holds a mutex per agent socket. QEMU's chardev takes one client at a time, and a second
+// connection sits in the listen backlog, its timeout running, until the first goes away.
+var agentLocks sync.Map
+
+func agentLock(socketPath string) *sync.Mutex {
+	mu, _ := agentLocks.LoadOrStore(socketPath, new(sync.Mutex))
+	return mu.(*sync.Mutex)
+}
+
+type agentResponse struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+}
+
+// execute runs a command and decodes its return value into result, if not nil.
+// Commands such as guest-shutdown don't answer on success; for those noReply skips waiting.
+func (a *GuestAgent) execute(command string, args interface{}, result interface{}, noReply bool) error {
+	mu := agentLock(a.socketPath)
+	mu.Lock()
+	defer mu.Unlock()
+
+	conn, err := net.DialTimeout("unix", a.socketPath, 2*time.Second)
+	if err != nil {
+		return fmt.Errorf("guest agent is not available: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(a.Timeout))
+
+	// 0xFF makes the agent drop any partial command it is still parsing.
+	id := rand.Int63n(1 << 31)
+	if _, err := conn.Write([]byte{0xFF}); err != nil {
+		return err
+	}
+	sync := map[string]interface{}{"execute": "guest-sync-delimited", "arguments": map[string]int64{"id": id}}
+	if err := json.NewEncoder(conn).Encode(sync); err != nil {
+		return err
+	}
+	// The reply to guest-sync-delimited starts with 0xFF; anything before it is stale output.
+	// Every reply ends with a newline.
+	r := bufio.NewReader(conn)
+	for {
+		if _, err := r.ReadBytes(0xFF); err != nil {
+			return fmt.Errorf("guest agent did not respond: %v", err)
+		}
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return fmt.Errorf("guest agent did not respond: %v", err)
+		}
+		var res agentResponse
+		var got int64
+		if json.Unmarshal(line, &res) == nil && json.Unmarshal(res.Return, &got) == nil && got == id {
+			break
+		}
+	}
+
+	cmd := map[string]interface{}{"execute": command}
+	if args != nil {
+		cmd["arguments"] = args
+	}
+	if err := json.NewEncoder(conn).Encode(cmd); err != nil {
+		return err
+	}
+	if noReply {
+		return nil
+	}
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("guest agent did not respond to %s: %v", command, err)
+	}
+	var res agentResponse
+	if err := json.Unmarshal(line, &res); err != nil {
+		return fmt.Errorf("invalid guest agent response: %v", err)
+	}
+	if res.Error != nil {
+		return fmt.Errorf("guest agent error: %s", res.Error.Desc)
+	}
+	if result != nil {
+		return json.Unmarshal(res.Return, result)
+	}
+	return nil
+}
+
+func (a *GuestAgent) Ping() error {
+	return a.execute("guest-ping", nil, nil, false)
+}
+
+type GuestOSInfo struct {
+	ID            string `json:"id,omitempty"`
+	Name          string `json:"name,omitempty"`
+	PrettyName    string `json:"pretty-name,omitempty"`
+	Version       string `json:"version,omitempty"`
+	VersionID     string `json:"version-id,omitempty"`
+	KernelRelease string `json:"kernel-release,omitempty"`
+	KernelVersion string `json:"kernel-version,omitempty"`
+	Machine       string `json:"machine,omitempty"`
+}
+
+func (a *GuestAgent) OSInfo() (*GuestOSInfo, error) {
+	var info GuestOSInfo
+	if err := a.execute("guest-get-osinfo", nil, &info, false); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (a *GuestAgent) HostName() (string, error) {
+	var res struct {
+		HostName string `json:"host-name"`
+	}
+	err := a.execute("guest-get-host-name", nil, &res, false)
+	return res.HostName, err
+}
+
+type GuestInterface struct {
+	Name            string           `json:"name"`
+	HardwareAddress string           `json:"hardware-address,omitempty"`
+	IPAddresses     []GuestIPAddress `json:"ip-addresses,omitempty"`
+}
+
+type GuestIPAddress struct {
+	Type    string `json:"ip-address-type"` // ipv4 or ipv6
+	Address string `json:"ip-address"`
+	Prefix  int    `json:"prefix"`
+}
+
+func (a *GuestAgent) NetworkInterfaces() ([]GuestInterface, error) {
+	var ifaces []GuestInterface
+	if err := a.execute("guest-network-get-interfaces", nil, &ifaces, false); err != nil {
+		return nil, err
+	}
+	return ifaces, nil
+}
+
+// Shutdown asks the guest OS to powerdown, halt or reboot. The agent doesn't answer when it succeeds.
+func (a *GuestAgent) Shutdown(mode string) error {
+	switch mode {
+	case "powerdown", "halt", "reboot":
+	default:
+		return fmt.Errorf("invalid shutdown mode %q, use powerdown, halt or reboot", mode)
+	}
+	return a.execute("guest-shutdown", map[string]string{"mode": mode}, nil, true)
+}
+
+// FSFreeze flushes and freezes the guest's filesystems, for consistent disk snapshots,
+// and returns how many were frozen. They stay frozen until FSThaw.
+func (a *GuestAgent) FSFreeze() (int, error) {
+	var n int
+	err := a.execute("guest-fsfreeze-freeze", nil, &n, false)
+	return n, err
+}
+
+func (a *GuestAgent) FSThaw() (int, error) {
+	var n int
+	err := a.execute("guest-fsfreeze-thaw", nil, &n, false)
+	return n, err
+}
+
+// FSFreezeStatus is "frozen" or "thawed".
+func (a *GuestAgent) FSFreezeStatus() (string, error) {
+	var status string
+	err := a.execute("guest-fsfreeze-status", nil, &status, false)
+	return status, err
+}
+
+// SetTime sets the guest clock to t, e.g. after the VM was paused or restored from a snapshot.
+func (a *GuestAgent) SetTime(t time.Time) error {
+	return a.execute("guest-set-time", map[string]int64{"time": t.UnixNano()}, nil, false)
+}
+
+// GuestExecRequest starts a program in the guest. Output is captured when CaptureOutput is set;
+// Input is base64 encoded by encoding/json, as the agent expects.
+type GuestExecRequest struct {
+	Path          string   `json:"path"`
+	Args          []string `json:"arg,omitempty"`
+	Env           []string `json:"env,omitempty"`
+	Input         []byte   `json:"input-data,omitempty"`
+	CaptureOutput bool     `json:"capture-output,omitempty"`
+}
+
+// GuestExecStatus is the state of a program started with Exec. Output is only complete once Exited.
+type GuestExecStatus struct {
+	Exited       bool   `json:"exited"`
+	ExitCode     int    `json:"exitcode"`
+	Signal       int    `json:"signal"`
+	Stdout       []byte `json:"out-data"`
+	Stderr       []byte `json:"err-data"`
+	OutTruncated bool   `json:"out-truncated"`
+	ErrTruncated bool   `json:"err-truncated"`
+}
+
+// Exec starts a program in the guest and returns its PID, to be passed to ExecStatus.
+func (a *GuestAgent) Exec(req GuestExecRequest) (int, error) {
+	var res struct {
+		PID int `json:"pid"`
+	}
+	if err := a.execute("guest-exec", req, &res, false); err != nil {
+		return 0, err
+	}
+	return res.PID, nil
+}
+
+func (a *GuestAgent) ExecStatus(pid int) (*GuestExecStatus, error) {
+	var st GuestExecStatus
+	if err := a.execute("guest-exec-status", map[string]int{"pid": pid}, &st, false); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// FileOpen opens a file in the guest with an fopen mode such as "r", "w" or "a" and returns its handle.
+func (a *GuestAgent) FileOpen(path, mode string) (int64, error) {
+	var handle int64
+	err := a.execute("guest-file-open", map[string]string{"path": path, "mode": mode}, &handle, false)
+	return handle, err
+}
+
+func (a *GuestAgent) FileClose(handle int64) error {
+	return a.execute("guest-file-close", map[string]int64{"handle": handle}, nil, false)
+}
+
+// FileRead reads up to count bytes and reports whether the end of the file was reached.
+func (a *GuestAgent) FileRead(handle int64, count int) ([]byte, bool, error) {
+	var res struct {
+		Data []byte `json:"buf-b64"`
+		EOF  bool   `json:"eof"`
+	}
+	err := a.execute("guest-file-read", map[string]int64{"handle": handle, "count": int64(count)}, &res, false)
+	return res.Data, res.EOF, err
+}
+
+// FileWrite writes data and returns how much of it was written.
+func (a *GuestAgent) FileWrite(handle int64, data []byte) (int, error) {
+	var res struct {
+		Count int `json:"count"`
+	}
+	args := map[string]interface{}{"handle": handle, "buf-b64": data, "count": len(data)}
+	err := a.execute("guest-file-write", args, &res, false)
+	return res.Count, err
+}
+
+// FileSeek moves to offset relative to whence, which is "set", "cur" or "end", and returns the new position.
+func (a *GuestAgent) FileSeek(handle, offset int64, whence string) (int64, error) {
+	var res struct {
+		Position int64 `json:"position"`
+	}
+	args := map[string]interface{}{"handle": handle, "offset": offset, "whence": whence}
+	err := a.execute("guest-file-seek", args, &res, false)
+	return res.Position, err
+}
@@ -0,0 +1,297 @@
+package qemu
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAgent answers guest agent commands on a unix socket the way qemu-ga does through QEMU's chardev.
+type fakeAgent struct {
+	t        *testing.T
+	commands chan string
+	handlers map[string]func(args json.RawMessage) interface{}
+}
+
+func startFakeAgent(t *testing.T, handlers map[string]func(args json.RawMessage) interface{}) (string, *fakeAgent) {
+	path := filepath.Join(t.TempDir(), "qga.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	fa := &fakeAgent{t: t, commands: make(chan string, 100), handlers: handlers}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fa.serve(conn)
+		}
+	}()
+	return path, fa
+}
+
+func (fa *fakeAgent) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var cmd struct {
+			Execute   string          `json:"execute"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(bytes.TrimLeft(line, "\xff"), &cmd); err != nil {
+			fa.t.Errorf("invalid command %q: %v", line, err)
+			return
+		}
+		fa.commands <- cmd.Execute
+		if cmd.Execute == "guest-sync-delimited" {
+			var args struct {
+				ID int64 `json:"id"`
+			}
+			json.Unmarshal(cmd.Arguments, &args)
+			// Output of an earlier command nobody read anymore comes first.
+			conn.Write([]byte("{\"return\": {}}\n"))
+			conn.Write([]byte{0xFF})
+			json.NewEncoder(conn).Encode(map[string]int64{"return": args.ID})
+			continue
+		}
+		h, ok := fa.handlers[cmd.Execute]
+		if !ok {
+			json.NewEncoder(conn).Encode(map[string]interface{}{
+				"error": map[string]string{"class": "CommandNotFound", "desc": "The command " + cmd.Execute + " has not been found"},
+			})
+			continue
+		}
+		if res := h(cmd.Arguments); res != nil {
+			json.NewEncoder(conn).Encode(map[string]interface{}{"return": res})
+		}
+	}
+}
+
+func TestGuestAgent(t *testing.T) {
+	var execArgs GuestExecRequest
+	path, fa := startFakeAgent(t, map[string]func(json.RawMessage) interface{}{
+		"guest-ping": func(json.RawMessage) interface{} { return map[string]string{} },
+		"guest-get-osinfo": func(json.RawMessage) interface{} {
+			return map[string]string{"id": "debian", "pretty-name": "Debian GNU/Linux 12 (bookworm)", "kernel-release": "6.1.0-18-amd64"}
+		},
+		"guest-network-get-interfaces": func(json.RawMessage) interface{} {
+			return []map[string]interface{}{{
+				"name": "eth0", "hardware-address": "52:54:00:12:34:56",
+				"ip-addresses": []map[string]interface{}{{"ip-address-type": "ipv4", "ip-address": "10.0.2.15", "prefix": 24}},
+			}}
+		},
+		"guest-exec": func(args json.RawMessage) interface{} {
+			json.Unmarshal(args, &execArgs)
+			return map[string]int{"pid": 42}
+		},
+		"guest-exec-status": func(json.RawMessage) interface{} {
+			return map[string]interface{}{"exited": true, "exitcode": 3, "out-data": "aGVsbG8K"}
+		},
+		"guest-shutdown": func(json.RawMessage) interface{} { return nil },
+	})
+	agent := NewGuestAgent(path)
+	agent.Timeout = 5 * time.Second
+
+	if err := agent.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := agent.OSInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != "debian" || info.KernelRelease != "6.1.0-18-amd64" {
+		t.Errorf("os info %+v", info)
+	}
+	ifaces, err := agent.NetworkInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ifaces) != 1 || ifaces[0].IPAddresses[0].Address != "10.0.2.15" || ifaces[0].IPAddresses[0].Prefix != 24 {
+		t.Errorf("interfaces %+v", ifaces)
+	}
+
+	pid, err := agent.Exec(GuestExecRequest{Path: "/bin/sh", Args: []string{"-c", "echo hello"}, Input: []byte("in"), CaptureOutput: true})
+	if err != nil || pid != 42 {
+		t.Fatalf("exec gave %d, %v", pid, err)
+	}
+	if execArgs.Path != "/bin/sh" || string(execArgs.Input) != "in" || !execArgs.CaptureOutput {
+		t.Errorf("agent got %+v", execArgs)
+	}
+	st, err := agent.ExecStatus(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Exited || st.ExitCode != 3 || string(st.Stdout) != "hello\n" {
+		t.Errorf("exec status %+v", st)
+	}
+
+	if _, err := agent.FSFreeze(); err == nil || !strings.Contains(err.Error(), "has not been found") {
+		t.Errorf("expected the agent's error, got %v", err)
+	}
+	// No reply is expected when the guest goes down.
+	if err := agent.Shutdown("powerdown"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for len(got) < 7 {
+		select {
+		case c := <-fa.commands:
+			if c != "guest-sync-delimited" {
+				got = append(got, c)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("agent only got %v", got)
+		}
+	}
+	if strings.Join(got, ",") != "guest-ping,guest-get-osinfo,guest-network-get-interfaces,guest-exec,guest-exec-status,guest-fsfreeze-freeze,guest-shutdown" {
+		t.Errorf("agent got %v", got)
+	}
+}
+
+func TestGuestAgentTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qga.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// QEMU accepts the connection even when no agent runs in the guest.
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+	agent := NewGuestAgent(path)
+	agent.Timeout = 100 * time.Millisecond
+	if err := agent.Ping(); err == nil || !strings.Contains(err.Error(), "did not respond") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestGuestAgentOneClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qga.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	fa := &fakeAgent{t: t, commands: make(chan string, 100), handlers: map[string]func(json.RawMessage) interface{}{
+		"guest-ping": func(json.RawMessage) interface{} {
+			time.Sleep(50 * time.Millisecond)
+			return map[string]interface{}{}
+		},
+	}}
+	// Like QEMU's chardev, the next client is only accepted once the current one has gone.
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			fa.serve(conn)
+		}
+	}()
+
+	errs := make(chan error, 6)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			agent := NewGuestAgent(path)
+			agent.Timeout = 150 * time.Millisecond
+			errs <- agent.Ping()
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("concurrent ping: %v", err)
+		}
+	}
+}
+
+func TestGuestAgentFiles(t *testing.T) {
+	var file []byte
+	pos := 0
+	path, _ := startFakeAgent(t, map[string]func(json.RawMessage) interface{}{
+		"guest-file-open": func(args json.RawMessage) interface{} {
+			var a struct{ Mode string }
+			json.Unmarshal(args, &a)
+			switch a.Mode {
+			case "wb":
+				file, pos = nil, 0
+			case "ab":
+				pos = len(file)
+			default:
+				pos = 0
+			}
+			return 1000
+		},
+		"guest-file-write": func(args json.RawMessage) interface{} {
+			var a struct {
+				Data []byte `json:"buf-b64"`
+			}
+			json.Unmarshal(args, &a)
+			file = append(file[:pos], a.Data...)
+			pos = len(file)
+			return map[string]interface{}{"count": len(a.Data), "eof": false}
+		},
+		"guest-file-seek": func(args json.RawMessage) interface{} {
+			var a struct {
+				Offset int
+				Whence string
+			}
+			json.Unmarshal(args, &a)
+			if a.Whence == "end" {
+				pos = len(file) + a.Offset
+			} else {
+				pos = a.Offset
+			}
+			return map[string]interface{}{"position": pos, "eof": false}
+		},
+		"guest-file-read": func(args json.RawMessage) interface{} {
+			var a struct{ Count int }
+			json.Unmarshal(args, &a)
+			end := min(len(file), pos+a.Count)
+			data := file[pos:end]
+			pos = end
+			return map[string]interface{}{"count": len(data), "buf-b64": data, "eof": pos == len(file)}
+		},
+		"guest-file-close": func(json.RawMessage) interface{} { return map[string]string{} },
+	})
+	agent := NewGuestAgent(path)
+
+	h, err := agent.FileOpen("/etc/motd", "wb")
+	if err != nil || h != 1000 {
+		t.Fatalf("open gave %d, %v", h, err)
+	}
+	if n, err := agent.FileWrite(h, []byte("hello ")); err != nil || n != 6 {
+		t.Fatalf("write gave %d, %v", n, err)
+	}
+	agent.FileClose(h)
+	h, _ = agent.FileOpen("/etc/motd", "ab")
+	agent.FileWrite(h, []byte("world\n"))
+	agent.FileClose(h)
+
+	h, _ = agent.FileOpen("/etc/motd", "rb")
+	if size, err := agent.FileSeek(h, 0, "end"); err != nil || size != 12 {
+		t.Fatalf("seek gave %d, %v", size, err)
+	}
+	agent.FileSeek(h, 6, "set")
+	data, eof, err := agent.FileRead(h, 100)
+	if err != nil || string(data) != "world\n" || !eof {
+		t.Errorf("read gave %q, %v, %v", data, eof, err)
+	}
+}
@@ -3,12 +3,15 @@ package qemu
 import (
 	"fmt"
 	"runtime"
+	"strings"
 
 	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/network"
 )
 
 type Builder struct {
 	config *config.VMConfig
+	vnc    *VNCEndpoint // allocated by the manager, overrides the display configuration
 }
 
 func NewBuilder(cfg *config.VMConfig) *Builder {
@@ -51,15 +54,30 @@ func (b *Builder) BuildArgs() []string {
 
 	// Display (VNC)
 	if b.config.Display.Enabled {
-		vncAddr := b.config.Display.VNCAddr
-		if b.config.Display.VNCPort != 0 {
-			vncAddr = fmt.Sprintf("127.0.0.1:%d", b.config.Display.VNCPort-5900)
+		vnc := b.vnc
+		if vnc == nil {
+			vnc, _ = FixedVNCEndpoint(b.config.Display)
+		}
+		if vnc == nil && b.config.Display.VNCSocket {
+			vnc = &VNCEndpoint{Socket: VNCSocketPath(b.config.Name)}
+		}
+		if vnc == nil {
+			vnc = &VNCEndpoint{Host: "127.0.0.1", Port: vncBasePort}
+		}
+		args = append(args, "-vnc", vnc.arg())
+		if b.config.Display.Tablet {
+			args = append(args, "-device", "qemu-xhci,id=input-usb", "-device", "usb-tablet,bus=input-usb.0")
 		}
-		args = append(args, "-vnc", vncAddr)
 	} else {
-		args = append(args, "-nographic")
+		args = append(args, "-display", "none")
 	}
 
+	// Serial console, attached to with `vmtool console`
+	args = append(args, serialArgs(b.config)...)
+
+	// Timestamped error messages, so the QEMU log can be merged with the console log
+	args = append(args, "-msg", "timestamp=on")
+
 	// Additional arguments
 	args = append(args, b.config.AdditionalArgs...)
 
@@ -115,18 +133,77 @@ func (b *Builder) getDeviceType(iface, imageType string) string {
 
 func (b *Builder) buildNetworkArgs() []string {
 	var args []string
-	// Default to user mode slirp
-	netdev := "user,id=net0"
-	for _, fw := range b.config.Network.PortForwards {
-		netdev += fmt.Sprintf(",hostfwd=%s::%d-:%d", fw.Protocol, fw.HostPort, fw.GuestPort)
+	for i, nic := range b.config.NICs() {
+		id := NetdevID(i)
+		device := nic.Hardware
+		if device == "" {
+			device = "virtio-net-pci"
+		}
+		device += ",netdev=" + id
+
+		if nic.IsVirtual() {
+			// Shared L2 segment through the vmtool network switch
+			args = append(args, "-netdev", fmt.Sprintf("stream,id=%s,server=off,addr.type=unix,addr.path=%s",
+				id, escapeOptValue(network.SocketPath(nic.Network))))
+			// Every VM on the segment needs a distinct MAC, QEMU's default is the same for all of them
+			device += ",mac=" + b.config.MACAddress(i)
+		} else {
+			// Default to user mode slirp
+			args = append(args, "-netdev", b.buildUserNetdev(id, nic))
+			if nic.MACAddress != "" {
+				device += ",mac=" + nic.MACAddress
+			}
+		}
+		args = append(args, "-device", device)
 	}
-	args = append(args, "-netdev", netdev)
+	return args
+}
 
-	hardware := b.config.Network.Hardware
-	if hardware == "" {
-		hardware = "virtio-net-pci"
+func (b *Builder) buildUserNetdev(id string, n config.NetworkConfig) string {
+	netdev := "user,id=" + id
+	if n.Subnet != "" {
+		netdev += ",net=" + n.Subnet
+	}
+	if n.HostAddress != "" {
+		netdev += ",host=" + n.HostAddress
+	}
+	if n.DNSAddress != "" {
+		netdev += ",dns=" + n.DNSAddress
+	}
+	if n.IPv6Prefix != "" {
+		netdev += ",ipv6=on,ipv6-net=" + n.IPv6Prefix
 	}
-	args = append(args, "-device", fmt.Sprintf("%s,netdev=net0", hardware))
+	if n.Restrict {
+		netdev += ",restrict=on"
+	}
+	for _, domain := range n.DNSSearch {
+		netdev += ",dnssearch=" + escapeOptValue(domain)
+	}
+	if n.TFTPRoot != "" {
+		netdev += ",tftp=" + escapeOptValue(n.TFTPRoot)
+		if n.TFTPBootFile != "" {
+			netdev += ",bootfile=" + escapeOptValue(n.TFTPBootFile)
+		}
+	}
+	if n.SMBShare != "" {
+		netdev += ",smb=" + escapeOptValue(n.SMBShare)
+	}
+	for _, fw := range n.PortForwards {
+		proto := fw.Protocol
+		if proto == "" {
+			proto = "tcp"
+		}
+		netdev += fmt.Sprintf(",hostfwd=%s:%s:%d-%s:%d", proto, fw.HostIP, fw.HostPort, fw.GuestIP, fw.GuestPort)
+	}
+	return netdev
+}
 
-	return args
+// escapeOptValue escapes commas in a QEMU option value, which QEMU expects doubled.
+func escapeOptValue(v string) string {
+	return strings.ReplaceAll(v, ",", ",,")
+}
+
+// NetdevID is the id of the netdev backing NIC index.
+func NetdevID(index int) string {
+	return fmt.Sprintf("net%d", index)
 }
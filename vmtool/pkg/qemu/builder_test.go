@@ -1,10 +1,12 @@
 package qemu
 
 import (
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/network"
 )
 
 func TestBuildArgs(t *testing.T) {
@@ -33,3 +35,175 @@ func TestBuildArgs(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildArgsUserNetwork(t *testing.T) {
+	cfg := &config.VMConfig{
+		Name: "net-vm",
+		UUID: "5678",
+		System: config.SystemConfig{
+			Memory: 512,
+			CPUs:   1,
+		},
+		Network: config.NetworkConfig{
+			Mode:         "user",
+			Subnet:       "192.168.76.0/24",
+			HostAddress:  "192.168.76.1",
+			DNSAddress:   "192.168.76.53",
+			IPv6Prefix:   "fd00:76::/64",
+			Restrict:     true,
+			DNSSearch:    []string{"lab.example.com"},
+			TFTPRoot:     "/srv/tftp,boot",
+			TFTPBootFile: "pxelinux.0",
+			PortForwards: []config.PortForward{
+				{HostPort: 2222, GuestPort: 22},
+				{Protocol: "udp", HostIP: "127.0.0.1", HostPort: 5353, GuestPort: 53},
+			},
+		},
+	}
+	args := NewBuilder(cfg).BuildArgs()
+
+	var netdev string
+	for i, a := range args {
+		if a == "-netdev" && i+1 < len(args) {
+			netdev = args[i+1]
+		}
+	}
+	expected := "user,id=net0,net=192.168.76.0/24,host=192.168.76.1,dns=192.168.76.53," +
+		"ipv6=on,ipv6-net=fd00:76::/64,restrict=on,dnssearch=lab.example.com," +
+		"tftp=/srv/tftp,,boot,bootfile=pxelinux.0," +
+		"hostfwd=tcp::2222-:22,hostfwd=udp:127.0.0.1:5353-:53"
+	if netdev != expected {
+		t.Errorf("unexpected netdev:\n got: %s\nwant: %s", netdev, expected)
+	}
+}
+
+func TestBuildArgsVirtualNetwork(t *testing.T) {
+	cfg := &config.VMConfig{
+		Name: "lab-vm",
+		UUID: "9abc",
+		System: config.SystemConfig{
+			Memory: 512,
+			CPUs:   1,
+		},
+		AdditionalNetworks: []config.NetworkConfig{
+			{Mode: "virtual", Network: "lab1", Hardware: "e1000"},
+		},
+	}
+	args := NewBuilder(cfg).BuildArgs()
+	joined := strings.Join(args, " ")
+
+	expected := []string{
+		"-netdev user,id=net0",
+		"-device virtio-net-pci,netdev=net0 ",
+		"-netdev stream,id=net1,server=off,addr.type=unix,addr.path=" + network.SocketPath("lab1"),
+		"-device e1000,netdev=net1,mac=" + cfg.MACAddress(1),
+	}
+	for _, exp := range expected {
+		if !strings.Contains(joined, exp) {
+			t.Errorf("expected argument %s not found in %s", exp, joined)
+		}
+	}
+	if cfg.MACAddress(1) == cfg.MACAddress(0) {
+		t.Errorf("generated MAC addresses of nic 0 and nic 1 are equal")
+	}
+}
+
+func TestBuildArgsVNC(t *testing.T) {
+	cfg := &config.VMConfig{
+		Name:    "vnc-vm",
+		UUID:    "9abc",
+		Display: config.DisplayConfig{Enabled: true, VNCPort: 5905},
+	}
+	builder := NewBuilder(cfg)
+	if joined := strings.Join(builder.BuildArgs(), " "); !strings.Contains(joined, "-vnc 127.0.0.1:5") {
+		t.Errorf("fixed vnc_port not mapped to display 5: %s", joined)
+	}
+
+	builder.vnc = &VNCEndpoint{Host: "127.0.0.1", Port: 5917}
+	if joined := strings.Join(builder.BuildArgs(), " "); !strings.Contains(joined, "-vnc 127.0.0.1:17") {
+		t.Errorf("allocated endpoint not used: %s", joined)
+	}
+
+	ep, err := FixedVNCEndpoint(config.DisplayConfig{Enabled: true, VNCAddr: "localhost:3,password=on"})
+	if err != nil || ep.Port != 5903 || ep.Options != "password=on" || ep.Address() != "localhost:5903" {
+		t.Errorf("unexpected endpoint %+v (%v)", ep, err)
+	}
+	if ep, _ := FixedVNCEndpoint(config.DisplayConfig{Enabled: true, VNCAddr: ":0"}); ep != nil {
+		t.Errorf("legacy :0 should allocate, got %+v", ep)
+	}
+}
+
+func TestBuildArgsVNCSocket(t *testing.T) {
+	cfg := &config.VMConfig{
+		Name:    "private-vm",
+		UUID:    "def0",
+		Display: config.DisplayConfig{Enabled: true, VNCSocket: true},
+	}
+	joined := strings.Join(NewBuilder(cfg).BuildArgs(), " ")
+	if !strings.Contains(joined, "-vnc unix:"+VNCSocketPath("private-vm")) {
+		t.Errorf("expected unix socket VNC in %s", joined)
+	}
+}
+
+func TestBuildArgsTablet(t *testing.T) {
+	cfg := &config.VMConfig{
+		Name:    "desktop",
+		UUID:    "abc1",
+		Display: config.DisplayConfig{Enabled: true, Tablet: true},
+	}
+	joined := strings.Join(NewBuilder(cfg).BuildArgs(), " ")
+	if !strings.Contains(joined, "-device usb-tablet,bus=input-usb.0") {
+		t.Errorf("expected a usb tablet in %s", joined)
+	}
+}
+
+func TestBuildArgsSerial(t *testing.T) {
+	cfg := &config.VMConfig{Name: "headless", UUID: "abc2"}
+	joined := strings.Join(NewBuilder(cfg).BuildArgs(), " ")
+	if strings.Contains(joined, "-nographic") || !strings.Contains(joined, "-display none") {
+		t.Errorf("expected no display and no stdio console in %s", joined)
+	}
+	if !strings.Contains(joined, "path="+SerialSocketPath("headless")+",server=on,wait=off") ||
+		!strings.Contains(joined, "-serial chardev:serial0") {
+		t.Errorf("expected the serial port on a unix socket in %s", joined)
+	}
+	if !strings.Contains(joined, "-msg timestamp=on") {
+		t.Errorf("expected timestamped QEMU messages in %s", joined)
+	}
+}
+
+func TestBuildArgsSerialPorts(t *testing.T) {
+	cfg := &config.VMConfig{Name: "ports", UUID: "abc3", Serials: []config.SerialConfig{
+		{Backend: "pty"},
+		{Backend: "tcp", Port: 4555, Telnet: true},
+		{Device: "virtio", Backend: "ring", Size: "4K"},
+		{Device: "virtio", Backend: "socket"},
+	}}
+	joined := strings.Join(NewBuilder(cfg).BuildArgs(), " ")
+	for _, want := range []string{
+		"-chardev pty,id=serial1 -serial chardev:serial1",
+		"-chardev socket,id=serial2,host=127.0.0.1,port=4555,server=on,wait=off,telnet=on -serial chardev:serial2",
+		"-chardev ringbuf,id=serial3,size=4096 -device virtio-serial-pci,id=virtio-serial0 -device virtconsole,bus=virtio-serial0.0,chardev=serial3,id=console3",
+		"-chardev socket,id=serial4,path=" + filepath.Join(RuntimeDir("ports"), "serial4.sock") + ",server=on,wait=off -device virtconsole,bus=virtio-serial0.0,chardev=serial4,id=console4",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected %q in %s", want, joined)
+		}
+	}
+	if strings.Count(joined, "virtio-serial-pci") != 1 {
+		t.Errorf("expected a single virtio-serial controller in %s", joined)
+	}
+}
+
+func TestBuildArgsGuestAgent(t *testing.T) {
+	cfg := &config.VMConfig{Name: "agent", UUID: "abc4", GuestAgent: true,
+		Serials: []config.SerialConfig{{Device: "virtio", Backend: "pty"}}}
+	joined := strings.Join(NewBuilder(cfg).BuildArgs(), " ")
+	if !strings.Contains(joined, "-chardev socket,id=qga0,path="+GuestAgentSocketPath("agent")+",server=on,wait=off") ||
+		!strings.Contains(joined, "-device virtserialport,bus=virtio-serial0.0,chardev=qga0,name=org.qemu.guest_agent.0") {
+		t.Errorf("expected a guest agent channel in %s", joined)
+	}
+	if strings.Count(joined, "virtio-serial-pci") != 1 {
+		t.Errorf("expected the agent to share the virtio-serial controller in %s", joined)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package qemu
+
+import "syscall"
+
+// detachedProcAttr puts QEMU in a session of its own, so that it outlives the daemon and a Ctrl-C
+// in the daemon's terminal doesn't reach it.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package qemu
+
+import "syscall"
+
+// detachedProcAttr puts QEMU in a process group of its own, so that it outlives the daemon and a
+// Ctrl-C in the daemon's console doesn't reach it.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
@@ -1,7 +1,9 @@
 package qemu
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"time"
@@ -58,6 +60,67 @@ func (c *QMPClient) execute(command string, args interface{}) (map[string]interf
 	return res, nil
 }
 
+// ErrQMPClosed means QEMU closed the connection while events were awaited, as it does when exiting.
+var ErrQMPClosed = errors.New("QMP connection closed")
+
+// CommandAndWait runs a command and keeps the connection open until QEMU sends one of events,
+// or exits, which is reported as ErrQMPClosed. It gives up when ctx ends.
+func (c *QMPClient) CommandAndWait(ctx context.Context, command string, args interface{}, events ...string) (string, error) {
+	conn, err := net.DialTimeout("unix", c.socketPath, 2*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	var greeting map[string]interface{}
+	if err := dec.Decode(&greeting); err != nil {
+		return "", err
+	}
+	if err := enc.Encode(map[string]interface{}{"execute": "qmp_capabilities"}); err != nil {
+		return "", err
+	}
+	cmd := map[string]interface{}{"execute": command}
+	if args != nil {
+		cmd["arguments"] = args
+	}
+	replies := 0
+	for {
+		var msg map[string]interface{}
+		if err := dec.Decode(&msg); err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			if replies > 0 {
+				// QEMU may exit before even replying to quit.
+				return "", ErrQMPClosed
+			}
+			return "", err
+		}
+		if event, ok := msg["event"].(string); ok {
+			for _, e := range events {
+				if e == event && replies > 0 {
+					return event, nil
+				}
+			}
+			continue
+		}
+		if errRes, ok := msg["error"]; ok {
+			return "", fmt.Errorf("QMP error: %v", errRes)
+		}
+		// The first reply is to qmp_capabilities, the second to the command.
+		replies++
+		if replies == 1 {
+			if err := enc.Encode(cmd); err != nil {
+				return "", err
+			}
+		}
+	}
+}
+
 func (c *QMPClient) Pause() error {
 	_, err := c.execute("stop", nil)
 	return err
@@ -73,6 +136,35 @@ func (c *QMPClient) PowerDown() error {
 	return err
 }
 
+func (c *QMPClient) Reset() error {
+	_, err := c.execute("system_reset", nil)
+	return err
+}
+
+// Migrate starts sending the machine's state to uri, which QEMU does in the background.
+func (c *QMPClient) Migrate(uri string) error {
+	_, err := c.execute("migrate", map[string]string{"uri": uri})
+	return err
+}
+
+func (c *QMPClient) MigrateCancel() error {
+	_, err := c.execute("migrate_cancel", nil)
+	return err
+}
+
+// QueryMigrate returns the status of the last migration, such as active, completed or failed,
+// with QEMU's description of the error when it failed.
+func (c *QMPClient) QueryMigrate() (string, string, error) {
+	res, err := c.execute("query-migrate", nil)
+	if err != nil {
+		return "", "", err
+	}
+	ret, _ := res["return"].(map[string]interface{})
+	status, _ := ret["status"].(string)
+	desc, _ := ret["error-desc"].(string)
+	return status, desc, nil
+}
+
 func (c *QMPClient) SaveSnapshot(name string) error {
 	_, err := c.execute("savevm", map[string]string{"name": name})
 	return err
@@ -87,3 +179,100 @@ func (c *QMPClient) DeleteSnapshot(name string) error {
 	_, err := c.execute("delvm", map[string]string{"name": name})
 	return err
 }
+
+func (c *QMPClient) QueryStatus() (string, error) {
+	res, err := c.execute("query-status", nil)
+	if err != nil {
+		return "", err
+	}
+	ret, _ := res["return"].(map[string]interface{})
+	status, _ := ret["status"].(string)
+	return status, nil
+}
+
+// ObjectAdd creates a QOM object such as a netfilter. props are passed as flat arguments (QEMU 6.0+).
+func (c *QMPClient) ObjectAdd(qomType, id string, props map[string]interface{}) error {
+	args := map[string]interface{}{"qom-type": qomType, "id": id}
+	for k, v := range props {
+		args[k] = v
+	}
+	_, err := c.execute("object-add", args)
+	return err
+}
+
+func (c *QMPClient) ObjectDel(id string) error {
+	_, err := c.execute("object-del", map[string]string{"id": id})
+	return err
+}
+
+// ScreenDump writes the current display to filename. format is "png" (QEMU 7.1+) or "" for QEMU's PPM default.
+func (c *QMPClient) ScreenDump(filename, format string) error {
+	args := map[string]string{"filename": filename}
+	if format != "" {
+		args["format"] = format
+	}
+	_, err := c.execute("screendump", args)
+	return err
+}
+
+// SendKey presses keys (qcodes) together and releases them after holdMs, 0 for QEMU's default of 100ms.
+func (c *QMPClient) SendKey(keys []string, holdMs int) error {
+	list := make([]map[string]string, len(keys))
+	for i, k := range keys {
+		list[i] = map[string]string{"type": "qcode", "data": k}
+	}
+	args := map[string]interface{}{"keys": list}
+	if holdMs > 0 {
+		args["hold-time"] = holdMs
+	}
+	_, err := c.execute("send-key", args)
+	return err
+}
+
+// InputEvent is one entry of input-send-event: a button press or release, or an abs/rel axis move.
+type InputEvent struct {
+	Type string                 `json:"type"` // btn, abs or rel
+	Data map[string]interface{} `json:"data"`
+}
+
+func (c *QMPClient) InputSendEvent(events []InputEvent) error {
+	_, err := c.execute("input-send-event", map[string]interface{}{"events": events})
+	return err
+}
+
+// QueryChardev returns the host side of each character device by id, e.g. "pty:/dev/pts/3".
+func (c *QMPClient) QueryChardev() (map[string]string, error) {
+	res, err := c.execute("query-chardev", nil)
+	if err != nil {
+		return nil, err
+	}
+	devs := make(map[string]string)
+	list, _ := res["return"].([]interface{})
+	for _, item := range list {
+		dev, _ := item.(map[string]interface{})
+		label, _ := dev["label"].(string)
+		filename, _ := dev["filename"].(string)
+		devs[label] = filename
+	}
+	return devs, nil
+}
+
+// RingbufRead takes up to size bytes out of a ringbuf chardev.
+func (c *QMPClient) RingbufRead(device string, size int) (string, error) {
+	res, err := c.execute("ringbuf-read", map[string]interface{}{"device": device, "size": size, "format": "utf8"})
+	if err != nil {
+		return "", err
+	}
+	data, _ := res["return"].(string)
+	return data, nil
+}
+
+// HumanMonitorCommand runs a monitor command that has no QMP equivalent. HMP reports errors as output text.
+func (c *QMPClient) HumanMonitorCommand(line string) (string, error) {
+	res, err := c.execute("human-monitor-command", map[string]string{"command-line": line})
+	if err != nil {
+		return "", err
+	}
+	out, _ := res["return"].(string)
+	return out, nil
+}
@@ -0,0 +1,93 @@
+package qemu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startFakeQMP serves a QMP greeting and capabilities negotiation, then hands each command to handle.
+func startFakeQMP(t *testing.T, handle func(command string, enc *json.Encoder, conn net.Conn)) string {
+	path := filepath.Join(t.TempDir(), "qmp.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				dec, enc := json.NewDecoder(conn), json.NewEncoder(conn)
+				enc.Encode(map[string]interface{}{"QMP": map[string]interface{}{"version": map[string]interface{}{}}})
+				for {
+					var cmd struct {
+						Execute string `json:"execute"`
+					}
+					if err := dec.Decode(&cmd); err != nil {
+						return
+					}
+					if cmd.Execute == "qmp_capabilities" {
+						// Events may arrive at any time, also before replies.
+						enc.Encode(map[string]interface{}{"event": "RTC_CHANGE"})
+						enc.Encode(map[string]interface{}{"return": map[string]interface{}{}})
+						continue
+					}
+					handle(cmd.Execute, enc, conn)
+				}
+			}()
+		}
+	}()
+	return path
+}
+
+func TestCommandAndWait(t *testing.T) {
+	path := startFakeQMP(t, func(command string, enc *json.Encoder, conn net.Conn) {
+		switch command {
+		case "system_powerdown":
+			enc.Encode(map[string]interface{}{"return": map[string]interface{}{}})
+			time.Sleep(50 * time.Millisecond)
+			enc.Encode(map[string]interface{}{"event": "POWERDOWN"})
+			enc.Encode(map[string]interface{}{"event": "SHUTDOWN", "data": map[string]interface{}{"guest": true}})
+		case "quit":
+			enc.Encode(map[string]interface{}{"return": map[string]interface{}{}})
+			conn.Close()
+		case "cont":
+			enc.Encode(map[string]interface{}{"return": map[string]interface{}{}})
+		default:
+			enc.Encode(map[string]interface{}{"error": map[string]interface{}{"class": "CommandNotFound"}})
+		}
+	})
+	client := NewQMPClient(path)
+	ctx := context.Background()
+
+	event, err := client.CommandAndWait(ctx, "system_powerdown", nil, "SHUTDOWN")
+	if err != nil || event != "SHUTDOWN" {
+		t.Errorf("powerdown: got %q, %v", event, err)
+	}
+	if _, err := client.CommandAndWait(ctx, "quit", nil, "SHUTDOWN"); !errors.Is(err, ErrQMPClosed) {
+		t.Errorf("quit: got %v, want ErrQMPClosed", err)
+	}
+	if _, err := client.CommandAndWait(ctx, "bogus", nil, "SHUTDOWN"); err == nil || errors.Is(err, ErrQMPClosed) {
+		t.Errorf("bogus: got %v, want a QMP error", err)
+	}
+
+	// A command that never leads to the event runs into the context's deadline.
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.CommandAndWait(tctx, "cont", nil, "SHUTDOWN"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cont: got %v, want DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("waiting took %s", d)
+	}
+}
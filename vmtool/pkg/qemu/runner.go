@@ -2,10 +2,12 @@ package qemu
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"syscall"
+	"strings"
+	"time"
 
 	"github.com/utmapp/vmtool/pkg/config"
 )
@@ -14,6 +16,9 @@ type Runner struct {
 	config  *config.VMConfig
 	cmd     *exec.Cmd
 	cancel  context.CancelFunc
+	vnc     *VNCEndpoint
+	output  *os.File
+	incoming string // saved state to resume from
 }
 
 func NewRunner(cfg *config.VMConfig) *Runner {
@@ -21,33 +26,101 @@ func NewRunner(cfg *config.VMConfig) *Runner {
 }
 
 func (r *Runner) Start(ctx context.Context) error {
+	if err := r.config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration for VM %s: %v", r.config.Name, err)
+	}
+
+	// QEMU creates its serial and VNC sockets here.
+	if err := os.MkdirAll(RuntimeDir(r.config.Name), 0700); err != nil {
+		return err
+	}
+
 	builder := NewBuilder(r.config)
+	builder.vnc = r.vnc
 	args := builder.BuildArgs()
 
 	// Find qemu binary
 	qemuBin := r.findQemuBinary()
+	if err := checkVersion(qemuBin, r.config); err != nil {
+		return err
+	}
 
 	// Add QMP support
 	qmpSocket := r.getQMPSocketPath()
 	args = append(args, "-qmp", "unix:"+qmpSocket+",server,nowait")
+	if r.incoming != "" {
+		args = append(args, "-incoming", "exec:cat "+shellQuote(r.incoming))
+	}
 
 	r.cmd = exec.CommandContext(ctx, qemuBin, args...)
 	r.cmd.Stdout = os.Stdout
 	r.cmd.Stderr = os.Stderr
+	if r.output != nil {
+		r.cmd.Stdout = r.output
+		r.cmd.Stderr = r.output
+	}
+	r.cmd.SysProcAttr = detachedProcAttr()
 
 	return r.cmd.Start()
 }
 
-func (r *Runner) Stop() error {
-	client := NewQMPClient(r.getQMPSocketPath())
-	if err := client.PowerDown(); err == nil {
+// Shutdown presses the ACPI power button and waits until the guest has shut down and QEMU exits,
+// or ctx ends; guests without ACPI support, or ignoring it, never get there.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	return r.commandUntilExit(ctx, "system_powerdown")
+}
+
+// Quit makes QEMU exit right away, as pulling the plug would, and waits for it to.
+func (r *Runner) Quit(ctx context.Context) error {
+	return r.commandUntilExit(ctx, "quit")
+}
+
+func (r *Runner) commandUntilExit(ctx context.Context, command string) error {
+	_, err := NewQMPClient(r.getQMPSocketPath()).CommandAndWait(ctx, command, nil, "SHUTDOWN")
+	if err == ErrQMPClosed {
 		return nil
 	}
-	// Fallback to kill if QMP fails or isn't responsive
-	if r.cmd != nil && r.cmd.Process != nil {
-		return r.cmd.Process.Signal(syscall.SIGTERM)
+	return err
+}
+
+// Save writes the state of the machine to path and makes QEMU exit, like suspending to disk. The
+// guest is paused while its memory is written and doesn't notice; resume it with SetIncoming.
+func (r *Runner) Save(ctx context.Context, path string) error {
+	qmp := NewQMPClient(r.getQMPSocketPath())
+	if err := qmp.Migrate("exec:cat > " + shellQuote(path)); err != nil {
+		return err
 	}
-	return nil
+	for {
+		status, desc, err := qmp.QueryMigrate()
+		if err != nil {
+			return err
+		}
+		switch status {
+		case "completed":
+			return r.Quit(ctx)
+		case "failed", "cancelled":
+			return fmt.Errorf("saving state %s: %s", status, desc)
+		}
+		select {
+		case <-ctx.Done():
+			qmp.MigrateCancel()
+			return ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// Reset resets the machine like its reset button, without the guest shutting down.
+func (r *Runner) Reset() error {
+	return NewQMPClient(r.getQMPSocketPath()).Reset()
+}
+
+// Kill kills a QEMU process started by this runner.
+func (r *Runner) Kill() error {
+	if r.cmd == nil || r.cmd.Process == nil {
+		return fmt.Errorf("QEMU of VM %s was not started by this process", r.config.Name)
+	}
+	return r.cmd.Process.Kill()
 }
 
 func (r *Runner) Pause() error {
@@ -70,8 +143,118 @@ func (r *Runner) DeleteSnapshot(name string) error {
 	return NewQMPClient(r.getQMPSocketPath()).DeleteSnapshot(name)
 }
 
-func (r *Runner) GetVNCPort() int {
-	return r.config.Display.VNCPort
+// Status returns the run state QEMU reports, such as running, paused or prelaunch.
+func (r *Runner) Status() (string, error) {
+	return NewQMPClient(r.getQMPSocketPath()).QueryStatus()
+}
+
+// Alive reports whether QEMU answers on the VM's QMP socket.
+func (r *Runner) Alive() bool {
+	_, err := NewQMPClient(r.getQMPSocketPath()).QueryStatus()
+	return err == nil
+}
+
+// StartCapture attaches a filter-dump to the netdev of NIC index, writing pcap to path.
+// snapLen limits the bytes kept per packet, 0 keeps QEMU's default of 64 KiB.
+func (r *Runner) StartCapture(id string, index int, path string, snapLen int) error {
+	if index < 0 || index >= len(r.config.NICs()) {
+		return fmt.Errorf("VM %s has no nic %d", r.config.Name, index)
+	}
+	props := map[string]interface{}{
+		"netdev": NetdevID(index),
+		"file":   path,
+	}
+	if snapLen > 0 {
+		props["maxlen"] = snapLen
+	}
+	return NewQMPClient(r.getQMPSocketPath()).ObjectAdd("filter-dump", id, props)
+}
+
+func (r *Runner) StopCapture(id string) error {
+	return NewQMPClient(r.getQMPSocketPath()).ObjectDel(id)
+}
+
+// Screendump saves the display to path and returns the format QEMU wrote: png, or ppm on QEMU before 7.1.
+func (r *Runner) Screendump(path string) (string, error) {
+	client := NewQMPClient(r.getQMPSocketPath())
+	err := client.ScreenDump(path, "png")
+	if err == nil {
+		return "png", nil
+	}
+	if !strings.Contains(err.Error(), "format") {
+		return "", err
+	}
+	if err := client.ScreenDump(path, ""); err != nil {
+		return "", err
+	}
+	return "ppm", nil
+}
+
+func (r *Runner) SendKey(keys []string, holdMs int) error {
+	return NewQMPClient(r.getQMPSocketPath()).SendKey(keys, holdMs)
+}
+
+func (r *Runner) SendInput(events []InputEvent) error {
+	return NewQMPClient(r.getQMPSocketPath()).InputSendEvent(events)
+}
+
+// Chardevs returns the host side of each of QEMU's character devices by id.
+func (r *Runner) Chardevs() (map[string]string, error) {
+	return NewQMPClient(r.getQMPSocketPath()).QueryChardev()
+}
+
+// ReadRing takes what the guest wrote to a ring buffer serial port since the last read.
+func (r *Runner) ReadRing(id string, size int) (string, error) {
+	return NewQMPClient(r.getQMPSocketPath()).RingbufRead(id, size)
+}
+
+// AddHostForward forwards TCP hostAddr:hostPort to guestPort on a user mode NIC, until RemoveHostForward.
+func (r *Runner) AddHostForward(netdev, hostAddr string, hostPort, guestPort int) error {
+	out, err := NewQMPClient(r.getQMPSocketPath()).HumanMonitorCommand(
+		fmt.Sprintf("hostfwd_add %s tcp:%s:%d-:%d", netdev, hostAddr, hostPort, guestPort))
+	if err != nil {
+		return err
+	}
+	// Success is silent.
+	if out = strings.TrimSpace(out); out != "" {
+		return fmt.Errorf("hostfwd_add: %s", out)
+	}
+	return nil
+}
+
+func (r *Runner) RemoveHostForward(netdev, hostAddr string, hostPort int) error {
+	out, err := NewQMPClient(r.getQMPSocketPath()).HumanMonitorCommand(
+		fmt.Sprintf("hostfwd_remove %s tcp:%s:%d", netdev, hostAddr, hostPort))
+	if err != nil {
+		return err
+	}
+	if strings.Contains(out, "not found") {
+		return fmt.Errorf("hostfwd_remove: %s", strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// SetOutput sends QEMU's stdout and stderr to f instead of this process's own.
+// QEMU gets the file itself, so logging continues after this process exits.
+func (r *Runner) SetOutput(f *os.File) {
+	r.output = f
+}
+
+// SetIncoming makes the next Start resume the machine from state saved to path by Save.
+func (r *Runner) SetIncoming(path string) {
+	r.incoming = path
+}
+
+// SetVNC makes the next Start serve VNC on ep instead of what the display configuration says.
+func (r *Runner) SetVNC(ep *VNCEndpoint) {
+	r.vnc = ep
+}
+
+func (r *Runner) PID() int {
+	if r.cmd != nil && r.cmd.Process != nil {
+		return r.cmd.Process.Pid
+	}
+	return 0
 }
 
 func (r *Runner) Wait() error {
@@ -93,6 +276,31 @@ func (r *Runner) findQemuBinary() string {
 	return binName // Fallback to raw name
 }
 
+// checkVersion rejects QEMU versions too old for the VM's configuration: virtual network NICs use
+// the stream netdev, which QEMU 7.2 added.
+func checkVersion(bin string, cfg *config.VMConfig) error {
+	virtual := false
+	for _, nic := range cfg.NICs() {
+		virtual = virtual || nic.IsVirtual()
+	}
+	if !virtual {
+		return nil
+	}
+	major, minor, err := Version(bin)
+	if err != nil {
+		return err
+	}
+	if major < 7 || (major == 7 && minor < 2) {
+		return fmt.Errorf("VM %s is attached to a virtual network, which needs QEMU 7.2 or newer, but %s is %d.%d", cfg.Name, bin, major, minor)
+	}
+	return nil
+}
+
+// shellQuote quotes s for the shell QEMU runs exec: migration commands with.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 func (r *Runner) getQMPSocketPath() string {
 	dataDir := config.GetDefaultDataDir()
 	return filepath.Join(dataDir, r.config.UUID+".qmp")
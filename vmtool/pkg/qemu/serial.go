@@ -0,0 +1,117 @@
+package qemu
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+// DefaultRingSize is the buffer of a ring serial port without a configured size.
+const DefaultRingSize = 64 << 10
+
+// SerialSocketPath is the unix socket QEMU serves the VM's first serial port on.
+// Only one client can be connected at a time; vmtool's console server shares it.
+func SerialSocketPath(name string) string {
+	return filepath.Join(RuntimeDir(name), "serial.sock")
+}
+
+// SerialID is the chardev id of the i-th configured serial port. The built-in console is serial0.
+func SerialID(i int) string {
+	return fmt.Sprintf("serial%d", i+1)
+}
+
+// SerialTarget describes where the host side of a configured serial port is, empty for a pty,
+// whose device is only known once QEMU has opened it.
+func SerialTarget(name string, i int, s config.SerialConfig) string {
+	switch s.Backend {
+	case "file":
+		return s.Path
+	case "socket":
+		if s.Path != "" {
+			return s.Path
+		}
+		return filepath.Join(RuntimeDir(name), SerialID(i)+".sock")
+	case "tcp":
+		host := s.Host
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(s.Port))
+		if s.Telnet {
+			return "telnet://" + addr
+		}
+		return "tcp://" + addr
+	case "ring":
+		return fmt.Sprintf("ring buffer of %d bytes", RingSize(s))
+	}
+	return ""
+}
+
+// RingSize is the buffer size of a ring serial port.
+func RingSize(s config.SerialConfig) int64 {
+	if s.Size != "" {
+		if n, err := config.ParseSize(s.Size); err == nil {
+			return n
+		}
+	}
+	return DefaultRingSize
+}
+
+func chardevArg(name string, i int, s config.SerialConfig) string {
+	id := SerialID(i)
+	switch s.Backend {
+	case "pty":
+		return "pty,id=" + id
+	case "file":
+		return "file,id=" + id + ",path=" + s.Path + ",append=on"
+	case "socket":
+		return "socket,id=" + id + ",path=" + SerialTarget(name, i, s) + ",server=on,wait=off"
+	case "tcp":
+		host := s.Host
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		arg := fmt.Sprintf("socket,id=%s,host=%s,port=%d,server=on,wait=off", id, host, s.Port)
+		if s.Telnet {
+			arg += ",telnet=on"
+		}
+		return arg
+	case "ring":
+		return fmt.Sprintf("ringbuf,id=%s,size=%d", id, RingSize(s))
+	}
+	return "null,id=" + id
+}
+
+// serialArgs connects the built-in console to its socket, then adds the configured ports.
+// Virtio consoles and the guest agent channel share one virtio-serial controller.
+func serialArgs(cfg *config.VMConfig) []string {
+	args := []string{
+		"-chardev", "socket,id=serial0,path=" + SerialSocketPath(cfg.Name) + ",server=on,wait=off",
+		"-serial", "chardev:serial0",
+	}
+	virtio := false
+	for i, s := range cfg.Serials {
+		id := SerialID(i)
+		args = append(args, "-chardev", chardevArg(cfg.Name, i, s))
+		if s.Device != "virtio" {
+			args = append(args, "-serial", "chardev:"+id)
+			continue
+		}
+		if !virtio {
+			args = append(args, "-device", "virtio-serial-pci,id=virtio-serial0")
+			virtio = true
+		}
+		args = append(args, "-device", "virtconsole,bus=virtio-serial0.0,chardev="+id+",id="+strings.Replace(id, "serial", "console", 1))
+	}
+	if cfg.GuestAgent {
+		if !virtio {
+			args = append(args, "-device", "virtio-serial-pci,id=virtio-serial0")
+		}
+		args = append(args, guestAgentArgs(cfg.Name)...)
+	}
+	return args
+}
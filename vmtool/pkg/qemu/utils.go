@@ -3,6 +3,7 @@ package qemu
 import (
 	"fmt"
 	"os/exec"
+	"strings"
 )
 
 func CreateDisk(path string, size string, format string) error {
@@ -15,3 +16,25 @@ func CreateDisk(path string, size string, format string) error {
 	}
 	return nil
 }
+
+// Version runs bin --version and returns QEMU's major and minor version.
+func Version(bin string) (int, int, error) {
+	out, err := exec.Command(bin, "--version").Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to run %s --version: %v", bin, err)
+	}
+	return parseVersion(string(out))
+}
+
+// parseVersion reads the first line of `qemu-system-* --version`, like "QEMU emulator version 8.2.2 (Debian 1:8.2.2+ds-0ubuntu1)".
+func parseVersion(out string) (int, int, error) {
+	var major, minor int
+	i := strings.Index(out, "version ")
+	if i < 0 {
+		return 0, 0, fmt.Errorf("unexpected QEMU version output %q", out)
+	}
+	if _, err := fmt.Sscanf(out[i+len("version "):], "%d.%d", &major, &minor); err != nil {
+		return 0, 0, fmt.Errorf("unexpected QEMU version output %q", out)
+	}
+	return major, minor, nil
+}
@@ -0,0 +1,22 @@
+package qemu
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	for _, tc := range []struct {
+		out          string
+		major, minor int
+	}{
+		{"QEMU emulator version 7.2.0\nCopyright (c) 2003-2022 Fabrice Bellard and the QEMU Project developers\n", 7, 2},
+		{"QEMU emulator version 8.2.2 (Debian 1:8.2.2+ds-0ubuntu1.4)\n", 8, 2},
+		{"QEMU emulator version 10.0.0 (v10.0.0)\n", 10, 0},
+	} {
+		major, minor, err := parseVersion(tc.out)
+		if err != nil || major != tc.major || minor != tc.minor {
+			t.Errorf("%q: got %d.%d, %v", tc.out, major, minor, err)
+		}
+	}
+	if _, _, err := parseVersion("qemu-system-x86_64: command not found"); err == nil {
+		t.Error("garbage: no error")
+	}
+}
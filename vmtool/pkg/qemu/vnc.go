@@ -0,0 +1,103 @@
+package qemu
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+// QEMU numbers VNC displays from this TCP port.
+const vncBasePort = 5900
+
+// VNCEndpoint is where QEMU serves VNC for a running VM.
+type VNCEndpoint struct {
+	Host    string `json:"host,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	Socket  string `json:"socket,omitempty"`  // unix socket instead of TCP, reachable only through the proxy
+	Options string `json:"options,omitempty"` // extra -vnc options from vnc_addr, e.g. password=on
+}
+
+// Address is the host:port or socket path to connect to; wildcard listeners are reached over loopback.
+func (e *VNCEndpoint) Address() string {
+	if e.Socket != "" {
+		return e.Socket
+	}
+	host := e.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(e.Port))
+}
+
+func (e *VNCEndpoint) String() string {
+	if e.Socket != "" {
+		return "unix:" + e.Socket
+	}
+	return e.Address()
+}
+
+func (e *VNCEndpoint) Dial(timeout time.Duration) (net.Conn, error) {
+	if e.Socket != "" {
+		return net.DialTimeout("unix", e.Socket, timeout)
+	}
+	return net.DialTimeout("tcp", e.Address(), timeout)
+}
+
+func (e *VNCEndpoint) arg() string {
+	if e.Socket != "" {
+		return "unix:" + e.Socket
+	}
+	host := e.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	arg := fmt.Sprintf("%s:%d", host, e.Port-vncBasePort)
+	if e.Options != "" {
+		arg += "," + e.Options
+	}
+	return arg
+}
+
+// FixedVNCEndpoint returns the endpoint pinned by the display configuration, or nil when the
+// port should be allocated at start. An empty vnc_addr or the old default ":0" means allocate.
+func FixedVNCEndpoint(d config.DisplayConfig) (*VNCEndpoint, error) {
+	if !d.Enabled {
+		return nil, nil
+	}
+	if d.VNCSocket {
+		return nil, nil
+	}
+	if d.VNCPort != 0 {
+		return &VNCEndpoint{Host: "127.0.0.1", Port: d.VNCPort}, nil
+	}
+	if d.VNCAddr == "" || d.VNCAddr == ":0" {
+		return nil, nil
+	}
+
+	addr, options, _ := strings.Cut(d.VNCAddr, ",")
+	i := strings.LastIndex(addr, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid vnc_addr %q, expected host:display", d.VNCAddr)
+	}
+	display, err := strconv.Atoi(addr[i+1:])
+	if err != nil || display < 0 || display > 65535-vncBasePort {
+		return nil, fmt.Errorf("invalid display number in vnc_addr %q", d.VNCAddr)
+	}
+	host := strings.Trim(addr[:i], "[]")
+	return &VNCEndpoint{Host: host, Port: vncBasePort + display, Options: options}, nil
+}
+
+// VNCSocketPath is the unix socket QEMU serves VNC on when TCP is disabled.
+func VNCSocketPath(name string) string {
+	return filepath.Join(RuntimeDir(name), "vnc.sock")
+}
+
+// RuntimeDir holds the runtime state and sockets of a running VM.
+func RuntimeDir(name string) string {
+	return filepath.Join(config.GetDefaultRuntimeDir(), "vms", name)
+}
@@ -0,0 +1,246 @@
+// Package recording stores timestamped copies of the RFB stream QEMU sends to VNC viewers,
+// so that a session can be replayed later.
+package recording
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Each chunk is stored as an 8-byte offset in nanoseconds since the start, a 4-byte length and the data.
+const (
+	fileMagic   = "VMTOOLREC1\n"
+	chunkHeader = 12
+	maxChunk    = 1 << 24
+)
+
+// Info describes a recording; it is kept next to the data as <id>.json.
+type Info struct {
+	ID         string        `json:"id"`
+	VM         string        `json:"vm"`
+	Session    string        `json:"session"`
+	Mode       string        `json:"mode"`
+	RemoteAddr string        `json:"remote_addr"`
+	StartedAt  time.Time     `json:"started_at"`
+	EndedAt    time.Time     `json:"ended_at,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	Bytes      int64         `json:"bytes"`
+}
+
+type Chunk struct {
+	Offset time.Duration
+	Data   []byte
+}
+
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// DataPath is the file holding the chunks of a recording.
+func (s *Store) DataPath(id string) string {
+	return filepath.Join(s.dir, id+".rec")
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// Recorder appends server chunks of one session. It is safe for concurrent use.
+type Recorder struct {
+	store *Store
+	info  Info
+	file  *os.File
+	w     *bufio.Writer
+	mu    sync.Mutex
+}
+
+// Create starts a recording for a session. info.ID and info.StartedAt are filled in.
+func (s *Store) Create(info Info) (*Recorder, error) {
+	info.ID = uuid.New().String()
+	info.StartedAt = time.Now()
+	f, err := os.OpenFile(s.DataPath(info.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{store: s, info: info, file: f, w: bufio.NewWriter(f)}
+	if _, err := r.w.WriteString(fileMagic); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := r.store.saveInfo(&r.info); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) ID() string {
+	return r.info.ID
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var hdr [chunkHeader]byte
+	binary.BigEndian.PutUint64(hdr[0:8], uint64(time.Since(r.info.StartedAt)))
+	binary.BigEndian.PutUint32(hdr[8:12], uint32(len(p)))
+	if _, err := r.w.Write(hdr[:]); err != nil {
+		return 0, err
+	}
+	if _, err := r.w.Write(p); err != nil {
+		return 0, err
+	}
+	r.info.Bytes += int64(len(p))
+	return len(p), nil
+}
+
+// Close finishes the data file and records the end time.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.info.EndedAt = time.Now()
+	r.info.Duration = r.info.EndedAt.Sub(r.info.StartedAt)
+	err := r.w.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	if serr := r.store.saveInfo(&r.info); err == nil {
+		err = serr
+	}
+	return err
+}
+
+func (s *Store) saveInfo(info *Info) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.infoPath(info.ID), data, 0600)
+}
+
+// List returns recordings, newest first, optionally only those of one VM.
+func (s *Store) List(vmName string) ([]Info, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	list := []Info{}
+	for _, f := range files {
+		if filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		info, err := s.Get(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		if vmName == "" || info.VM == vmName {
+			list = append(list, *info)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.After(list[j].StartedAt) })
+	return list, nil
+}
+
+func (s *Store) Get(id string) (*Info, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("recording %s not found", id)
+	}
+	data, err := os.ReadFile(s.infoPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("recording %s not found", id)
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (s *Store) Delete(id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	os.Remove(s.DataPath(id))
+	return os.Remove(s.infoPath(id))
+}
+
+// Reader returns the chunks of a recording in order.
+type Reader struct {
+	f *os.File
+	r *bufio.Reader
+}
+
+func (s *Store) Open(id string) (*Reader, error) {
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.DataPath(id))
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(fileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != fileMagic {
+		f.Close()
+		return nil, fmt.Errorf("recording %s is not in a known format", id)
+	}
+	return &Reader{f: f, r: r}, nil
+}
+
+// Next returns the next chunk, or io.EOF at the end. A chunk cut short by a crash also ends the recording.
+func (rd *Reader) Next() (*Chunk, error) {
+	var hdr [chunkHeader]byte
+	if _, err := io.ReadFull(rd.r, hdr[:]); err != nil {
+		return nil, io.EOF
+	}
+	n := binary.BigEndian.Uint32(hdr[8:12])
+	if n > maxChunk {
+		return nil, fmt.Errorf("corrupt recording: chunk of %d bytes", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(rd.r, data); err != nil {
+		return nil, io.EOF
+	}
+	return &Chunk{Offset: time.Duration(binary.BigEndian.Uint64(hdr[0:8])), Data: data}, nil
+}
+
+func (rd *Reader) Close() error {
+	return rd.f.Close()
+}
+
+// ExportNoVNC writes a recording in the VNC_frame_data format of noVNC's playback tools.
+func ExportNoVNC(w io.Writer, rd *Reader) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("var VNC_frame_data = [\n")
+	for {
+		chunk, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "'{%d{%s',\n", chunk.Offset.Milliseconds(), base64.StdEncoding.EncodeToString(chunk.Data))
+	}
+	bw.WriteString("'EOF'\n];\n")
+	return bw.Flush()
+}
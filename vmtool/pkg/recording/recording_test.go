@@ -0,0 +1,53 @@
+package recording
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := store.Create(Info{VM: "web", Mode: "view"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Write([]byte("RFB 003.008\n"))
+	rec.Write([]byte{0, 0, 0, 1})
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := store.List("web")
+	if err != nil || len(list) != 1 || list[0].Bytes != 16 || list[0].EndedAt.IsZero() {
+		t.Fatalf("unexpected recordings %+v (%v)", list, err)
+	}
+
+	rd, err := store.Open(rec.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	first, err := rd.Next()
+	if err != nil || string(first.Data) != "RFB 003.008\n" {
+		t.Fatalf("first chunk %+v (%v)", first, err)
+	}
+	second, err := rd.Next()
+	if err != nil || !bytes.Equal(second.Data, []byte{0, 0, 0, 1}) || second.Offset < first.Offset {
+		t.Fatalf("second chunk %+v (%v)", second, err)
+	}
+	if _, err := rd.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	rd2, _ := store.Open(rec.ID())
+	defer rd2.Close()
+	var out bytes.Buffer
+	if err := ExportNoVNC(&out, rd2); err != nil || !strings.Contains(out.String(), "{UkZCIDAwMy4wMDgK'") {
+		t.Errorf("unexpected export %q (%v)", out.String(), err)
+	}
+}
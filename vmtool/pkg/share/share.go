@@ -0,0 +1,221 @@
+// Package share mints and verifies signed, expiring links to a single VM's console.
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const MaxTTL = 30 * 24 * time.Hour
+
+// Share is an issued console link. Only its metadata is kept; the token can't be recovered.
+type Share struct {
+	ID        string    `json:"id"`
+	VM        string    `json:"vm"`
+	ViewOnly  bool      `json:"view_only"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Store keeps the signing key and the active shares on disk, so that links minted
+// by the CLI are honoured by the server and revoking one takes effect everywhere.
+type Store struct {
+	dir string
+	key []byte
+	mu  sync.Mutex
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	keyPath := filepath.Join(dir, "share.key")
+	key, err := os.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(keyPath, key, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, key: key}, nil
+}
+
+// Create records a new share and returns it with its token.
+func (s *Store) Create(vmName string, ttl time.Duration, viewOnly bool) (*Share, string, error) {
+	if ttl <= 0 || ttl > MaxTTL {
+		return nil, "", fmt.Errorf("ttl must be between 0 and %s", MaxTTL)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	sh := Share{
+		ID:        uuid.New().String(),
+		VM:        vmName,
+		ViewOnly:  viewOnly,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shares, err := s.load()
+	if err != nil {
+		return nil, "", err
+	}
+	shares = append(shares, sh)
+	if err := s.save(shares); err != nil {
+		return nil, "", err
+	}
+	token, err := s.sign(&sh)
+	if err != nil {
+		return nil, "", err
+	}
+	return &sh, token, nil
+}
+
+// Verify checks that token is a valid, unexpired and unrevoked share for vmName.
+func (s *Store) Verify(token, vmName string) (*Share, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, fmt.Errorf("malformed share token")
+	}
+	want := s.mac(payload)
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, want) {
+		return nil, fmt.Errorf("invalid share token")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed share token")
+	}
+	var claimed Share
+	if err := json.Unmarshal(data, &claimed); err != nil {
+		return nil, fmt.Errorf("malformed share token")
+	}
+	if claimed.VM != vmName {
+		return nil, fmt.Errorf("share is for another VM")
+	}
+	if time.Now().After(claimed.ExpiresAt) {
+		return nil, fmt.Errorf("share has expired")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shares, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, sh := range shares {
+		if sh.ID == claimed.ID {
+			return &sh, nil
+		}
+	}
+	return nil, fmt.Errorf("share has been revoked")
+}
+
+// List returns the shares that have not expired, optionally only those of one VM.
+func (s *Store) List(vmName string) ([]Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shares, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := []Share{}
+	for _, sh := range shares {
+		if vmName == "" || sh.VM == vmName {
+			list = append(list, sh)
+		}
+	}
+	return list, nil
+}
+
+func (s *Store) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shares, err := s.load()
+	if err != nil {
+		return err
+	}
+	for i, sh := range shares {
+		if sh.ID == id {
+			return s.save(append(shares[:i], shares[i+1:]...))
+		}
+	}
+	return fmt.Errorf("share %s not found", id)
+}
+
+func (s *Store) sign(sh *Share) (string, error) {
+	data, err := json.Marshal(sh)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload)), nil
+}
+
+func (s *Store) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// load reads the shares file, dropping expired entries. Must be called with s.mu held.
+func (s *Store) load() ([]Share, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, "shares.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var all []Share
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var shares []Share
+	for _, sh := range all {
+		if now.Before(sh.ExpiresAt) {
+			shares = append(shares, sh)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].CreatedAt.Before(shares[j].CreatedAt) })
+	return shares, nil
+}
+
+func (s *Store) save(shares []Share) error {
+	data, err := json.MarshalIndent(shares, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, "shares.json.tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, "shares.json"))
+}
+
+// Link is the dashboard URL that opens the shared console.
+func Link(baseURL string, sh *Share, token string) string {
+	q := url.Values{"vm": {sh.VM}, "share": {token}}
+	if sh.ViewOnly {
+		q.Set("mode", "view")
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/ui/index.html?" + q.Encode()
+}
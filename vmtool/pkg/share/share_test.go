@@ -0,0 +1,40 @@
+package share
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareLifecycle(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh, token, err := store.Create("web", time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := store.Verify(token, "web"); err != nil || !got.ViewOnly {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if _, err := store.Verify(token, "db"); err == nil {
+		t.Error("token accepted for another VM")
+	}
+	payload, sig, _ := strings.Cut(token, ".")
+	tampered := payload[:len(payload)-2] + "xx." + sig
+	if _, err := store.Verify(tampered, "web"); err == nil {
+		t.Error("tampered token accepted")
+	}
+
+	if err := store.Revoke(sh.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Verify(token, "web"); err == nil {
+		t.Error("revoked token accepted")
+	}
+	if _, _, err := store.Create("web", 0, false); err == nil {
+		t.Error("zero ttl accepted")
+	}
+}
@@ -0,0 +1,18 @@
+package vm
+
+import (
+	"net"
+
+	"github.com/utmapp/vmtool/pkg/console"
+)
+
+// Console attaches to a running VM's serial console, restarting the console server if it has gone away.
+func (m *Manager) Console(name string) (net.Conn, error) {
+	if _, err := m.lookup(name); err != nil {
+		return nil, err
+	}
+	if err := console.EnsureRunning(name); err != nil {
+		return nil, err
+	}
+	return console.Dial(name)
+}
@@ -0,0 +1,282 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// How often Up checks whether a dependency meets its condition.
+const readyInterval = time.Second
+
+// Step is one thing Up or Down did, for progress reports.
+type Step struct {
+	VM     string `json:"vm"`
+	Action string `json:"action"` // waiting, starting, started, running, stopping, stopped, suspending, suspended, not-running, failed or skipped
+	Detail string `json:"detail,omitempty"`
+}
+
+// Groups returns the members of every group, sorted by name.
+func (m *Manager) Groups() map[string][]string {
+	groups := make(map[string][]string)
+	for _, cfg := range m.store.ListVMs() {
+		for _, g := range cfg.Groups {
+			groups[g] = append(groups[g], cfg.Name)
+		}
+	}
+	for _, members := range groups {
+		sort.Strings(members)
+	}
+	return groups
+}
+
+// members expands names, each a group or a single VM, into VM names.
+func (m *Manager) members(names []string) ([]string, error) {
+	groups := m.Groups()
+	var vms []string
+	for _, name := range names {
+		if members, ok := groups[name]; ok {
+			vms = append(vms, members...)
+		} else if _, ok := m.store.GetVM(name); ok {
+			vms = append(vms, name)
+		} else {
+			return nil, fmt.Errorf("no group or VM named %s", name)
+		}
+	}
+	return vms, nil
+}
+
+// startOrder returns the VMs named, each after the VMs it depends on. With deps, those are added
+// when missing; otherwise only the order among the VMs named counts.
+func startOrder(lookup func(string) (*config.VMConfig, bool), names []string, deps bool) ([]string, error) {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	var order, path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s -> %s", strings.Join(path, " -> "), name)
+		}
+		cfg, ok := lookup(name)
+		if !ok {
+			return fmt.Errorf("VM %s not found, but %s depends on it", name, path[len(path)-1])
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range cfg.DependsOn {
+			if deps || wanted[dep.VM] {
+				if err := visit(dep.VM); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// Up starts the VMs named, each a group or a single VM, together with the VMs they depend on. Every
+// VM starts once its dependencies meet their conditions; when one fails, those depending on it are
+// skipped and the others still start.
+func (m *Manager) Up(ctx context.Context, names []string, progress func(Step)) error {
+	vms, err := m.members(names)
+	if err != nil {
+		return err
+	}
+	order, err := startOrder(m.store.GetVM, vms, true)
+	if err != nil {
+		return err
+	}
+	// Conditions that can never be met fail before anything starts.
+	for _, name := range order {
+		cfg, _ := m.store.GetVM(name)
+		for _, dep := range cfg.DependsOn {
+			depCfg, _ := m.store.GetVM(dep.VM)
+			if err := checkCondition(depCfg, dep); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	failed := make(map[string]bool)
+	var errs []error
+	for _, name := range order {
+		cfg, _ := m.store.GetVM(name)
+		var depErr error
+		for _, dep := range cfg.DependsOn {
+			if failed[dep.VM] {
+				depErr = fmt.Errorf("dependency %s failed", dep.VM)
+			} else {
+				depErr = m.waitReady(ctx, dep, progress)
+			}
+			if depErr != nil {
+				break
+			}
+		}
+		if depErr != nil {
+			failed[name] = true
+			progress(Step{VM: name, Action: "skipped", Detail: depErr.Error()})
+			errs = append(errs, fmt.Errorf("%s: %v", name, depErr))
+			continue
+		}
+		if m.GetStatus(name) == "running" {
+			progress(Step{VM: name, Action: "running"})
+			continue
+		}
+		progress(Step{VM: name, Action: "starting"})
+		if err := m.StartVM(ctx, name); err != nil {
+			failed[name] = true
+			progress(Step{VM: name, Action: "failed", Detail: err.Error()})
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+			continue
+		}
+		progress(Step{VM: name, Action: "started"})
+	}
+	return errors.Join(errs...)
+}
+
+// Down stops the VMs named, each a group or a single VM, every VM before those it depends on.
+// VMs outside the ones named are left alone, also when they depend on one of them.
+func (m *Manager) Down(ctx context.Context, names []string, opts StopOptions, progress func(Step)) error {
+	vms, err := m.members(names)
+	if err != nil {
+		return err
+	}
+	order, err := startOrder(m.store.GetVM, vms, false)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i := len(order) - 1; i >= 0; i-- {
+		name := order[i]
+		if m.GetStatus(name) != "running" {
+			progress(Step{VM: name, Action: "not-running"})
+			continue
+		}
+		progress(Step{VM: name, Action: "stopping"})
+		res, err := m.StopVM(ctx, name, opts)
+		if err != nil {
+			progress(Step{VM: name, Action: "failed", Detail: err.Error()})
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		progress(Step{VM: name, Action: "stopped", Detail: res.Method})
+	}
+	return errors.Join(errs...)
+}
+
+// checkCondition rejects conditions the dependency's configuration can never meet.
+func checkCondition(cfg *config.VMConfig, dep config.Dependency) error {
+	switch dep.Condition {
+	case config.ConditionPort:
+		if forwardedRoute(cfg, dep.Port) == nil {
+			return fmt.Errorf("VM %s has no TCP port forward to guest port %d", dep.VM, dep.Port)
+		}
+	case config.ConditionGuestAgent:
+		if !cfg.GuestAgent {
+			return fmt.Errorf("VM %s has no guest agent channel", dep.VM)
+		}
+	}
+	return nil
+}
+
+// waitReady waits until the dependency meets its condition, up to its timeout.
+func (m *Manager) waitReady(ctx context.Context, dep config.Dependency, progress func(Step)) error {
+	condition := dep.Condition
+	if condition == "" {
+		condition = config.ConditionStarted
+	}
+	timeout := dep.TimeoutDuration()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	waiting := false
+	for {
+		if m.ready(dep) {
+			return nil
+		}
+		if !waiting {
+			detail := condition
+			if dep.Condition == config.ConditionPort {
+				detail = fmt.Sprintf("port %d", dep.Port)
+			}
+			progress(Step{VM: dep.VM, Action: "waiting", Detail: detail})
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("VM %s did not meet condition %s within %s", dep.VM, condition, timeout)
+			}
+			return ctx.Err()
+		case <-time.After(readyInterval):
+		}
+	}
+}
+
+func (m *Manager) ready(dep config.Dependency) bool {
+	runner, err := m.lookup(dep.VM)
+	if err != nil {
+		return false
+	}
+	switch dep.Condition {
+	case config.ConditionRunning:
+		status, err := runner.Status()
+		return err == nil && status == "running"
+	case config.ConditionPort:
+		cfg, ok := m.store.GetVM(dep.VM)
+		if !ok {
+			return false
+		}
+		route := forwardedRoute(cfg, dep.Port)
+		return route != nil && portOpen(route.Host, route.Port)
+	case config.ConditionGuestAgent:
+		agent := qemu.NewGuestAgent(qemu.GuestAgentSocketPath(dep.VM))
+		agent.Timeout = 2 * time.Second
+		return agent.Ping() == nil
+	}
+	return true
+}
+
+// portOpen tells whether a forwarded port reaches a server in the guest. QEMU's user mode network
+// accepts connections to a forward even when nothing listens in the guest and closes them right away,
+// so a connection only counts when it stays open or the server sends something.
+func portOpen(host string, port int) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), 2*time.Second)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	return err == nil || (errors.As(err, &netErr) && netErr.Timeout())
+}
@@ -0,0 +1,95 @@
+package vm
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+func TestStartOrder(t *testing.T) {
+	vms := map[string]*config.VMConfig{}
+	add := func(name string, deps ...string) {
+		cfg := &config.VMConfig{Name: name}
+		for _, d := range deps {
+			cfg.DependsOn = append(cfg.DependsOn, config.Dependency{VM: d})
+		}
+		vms[name] = cfg
+	}
+	lookup := func(name string) (*config.VMConfig, bool) {
+		cfg, ok := vms[name]
+		return cfg, ok
+	}
+	add("router")
+	add("dns", "router")
+	add("db", "dns")
+	add("web", "db", "dns")
+
+	order, err := startOrder(lookup, []string{"web"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, " "); got != "router dns db web" {
+		t.Errorf("with dependencies: got %s", got)
+	}
+	// Only the VMs named, ordered among themselves.
+	order, err = startOrder(lookup, []string{"web", "router", "db"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, " "); got != "db web router" {
+		t.Errorf("without dependencies: got %s", got)
+	}
+
+	add("a", "b")
+	add("b", "c")
+	add("c", "a")
+	if _, err := startOrder(lookup, []string{"a"}, true); err == nil || !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Errorf("cycle: got %v", err)
+	}
+	add("orphan", "missing")
+	if _, err := startOrder(lookup, []string{"orphan"}, true); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("missing dependency: got %v", err)
+	}
+}
+
+func TestPortOpen(t *testing.T) {
+	serve := func(hold bool) int {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				if hold {
+					// A server waiting for the client, like sshd before its banner or a database.
+					go func() {
+						time.Sleep(2 * time.Second)
+						conn.Close()
+					}()
+				} else {
+					// What QEMU does on a forward with nothing listening in the guest.
+					conn.Close()
+				}
+			}
+		}()
+		return ln.Addr().(*net.TCPAddr).Port
+	}
+	if !portOpen("127.0.0.1", serve(true)) {
+		t.Error("port with a server: not open")
+	}
+	if portOpen("127.0.0.1", serve(false)) {
+		t.Error("port closing connections at once: open")
+	}
+	port, _ := freePort()
+	if portOpen("127.0.0.1", port) {
+		t.Error("port without a listener: open")
+	}
+}
@@ -0,0 +1,170 @@
+package vm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/logs"
+)
+
+// Event types
+const (
+	EventAutostart     = "autostart"      // started with the daemon
+	EventExit          = "exit"           // QEMU exited cleanly, e.g. the guest powered off
+	EventCrash         = "crash"          // QEMU exited with an error or was killed
+	EventRestart       = "restart"        // a restart was scheduled by the VM's policy
+	EventRestartFailed = "restart-failed" // starting the VM again failed
+	EventCrashLoop     = "crash-loop"     // the VM keeps exiting shortly after starting
+	EventGaveUp        = "gave-up"        // on-failure reached max_retries
+	EventStartFailed   = "start-failed"   // an autostart failed
+	EventShutdown      = "shutdown"       // stopped when the daemon shut down
+	EventSuspend       = "suspend"        // saved to disk when the daemon shut down
+	EventResume        = "resume"         // resumed from the state saved then
+	EventResumeFailed  = "resume-failed"  // the saved state could not be loaded
+)
+
+// Events are kept in one file for all VMs, which is rotated once it reaches this size.
+const (
+	maxEventsSize  = 1 << 20
+	eventsInterval = 250 * time.Millisecond
+)
+
+// Event is something the daemon did or noticed on its own, like restarting a crashed VM.
+type Event struct {
+	Time    time.Time `json:"time"`
+	VM      string    `json:"vm"`
+	Type    string    `json:"type"`
+	Message string    `json:"message"`
+}
+
+var eventsMu sync.Mutex
+
+func (m *Manager) eventsPath() string {
+	return filepath.Join(m.dataDir, "events.log")
+}
+
+// emit records an event in the events file and the VM's QEMU log.
+func (m *Manager) emit(name, typ, format string, args ...interface{}) {
+	e := Event{Time: time.Now().UTC(), VM: name, Type: typ, Message: fmt.Sprintf(format, args...)}
+	m.logEvent(name, "%s: %s", typ, e.Message)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	path := m.eventsPath()
+	if st, err := os.Stat(path); err == nil && st.Size() >= maxEventsSize {
+		logs.Rotate(path, 1)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(append(data, '\n'))
+}
+
+// EventOptions select the events Events reports.
+type EventOptions struct {
+	VM     string    // only this VM's, all when empty
+	Since  time.Time // only newer ones
+	Tail   int       // only the last n recorded so far, 0 for all
+	Follow bool      // keep reporting new events until ctx ends
+}
+
+// Events sends recorded events to fn, oldest first.
+func (m *Manager) Events(ctx context.Context, opts EventOptions, fn func(Event) error) error {
+	path := m.eventsPath()
+	match := func(e Event) bool {
+		return (opts.VM == "" || e.VM == opts.VM) && !e.Time.Before(opts.Since)
+	}
+	var events []Event
+	for _, p := range []string{path + ".1", path} {
+		read, _, err := readEvents(p, 0)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, e := range read {
+			if match(e) {
+				events = append(events, e)
+			}
+		}
+	}
+	if opts.Tail > 0 && len(events) > opts.Tail {
+		events = events[len(events)-opts.Tail:]
+	}
+	for _, e := range events {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if !opts.Follow {
+		return nil
+	}
+
+	var offset int64
+	if st, err := os.Stat(path); err == nil {
+		offset = st.Size()
+	}
+	ticker := time.NewTicker(eventsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if st, err := os.Stat(path); err == nil && st.Size() < offset {
+			// Rotated: what was appended before is in path.1 and has been missed, start over.
+			offset = 0
+		}
+		read, next, err := readEvents(path, offset)
+		if err != nil {
+			continue
+		}
+		offset = next
+		for _, e := range read {
+			if match(e) {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// readEvents reads the complete lines from offset on, returning where the next read starts.
+func readEvents(path string, offset int64) ([]Event, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, 0); err != nil {
+		return nil, offset, err
+	}
+	var events []Event
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// A partial line is still being written.
+			return events, offset, nil
+		}
+		offset += int64(len(line))
+		var e Event
+		if json.Unmarshal(line, &e) == nil {
+			events = append(events, e)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// How often a program started in the guest is checked for having exited.
+const guestExecPoll = 100 * time.Millisecond
+
+// GuestAgent returns a client for the qemu-ga inside a running VM that has the agent channel enabled.
+func (m *Manager) GuestAgent(name string) (*qemu.GuestAgent, error) {
+	cfg, ok := m.store.GetVM(name)
+	if !ok {
+		return nil, fmt.Errorf("VM %s not found", name)
+	}
+	if !cfg.GuestAgent {
+		return nil, fmt.Errorf("VM %s has no guest agent channel, set guest_agent: true and restart it", name)
+	}
+	if _, err := m.lookup(name); err != nil {
+		return nil, err
+	}
+	return qemu.NewGuestAgent(qemu.GuestAgentSocketPath(name)), nil
+}
+
+// GuestAddresses lists the guest's global IP addresses as the guest agent reports them.
+// It gives up quickly, as the agent may not be installed or not started yet.
+func (m *Manager) GuestAddresses(name string) ([]string, error) {
+	agent, err := m.GuestAgent(name)
+	if err != nil {
+		return nil, err
+	}
+	agent.Timeout = 2 * time.Second
+	ifaces, err := agent.NetworkInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, iface := range ifaces {
+		for _, addr := range iface.IPAddresses {
+			if ip := net.ParseIP(addr.Address); ip != nil && ip.IsGlobalUnicast() {
+				addrs = append(addrs, addr.Address)
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// GuestExecResult is how a program run in the guest ended and what it printed.
+type GuestExecResult struct {
+	ExitCode  int    `json:"exit_code"`
+	Signal    int    `json:"signal,omitempty"` // set when the program was killed by a signal
+	Stdout    []byte `json:"stdout"`
+	Stderr    []byte `json:"stderr"`
+	Truncated bool   `json:"truncated,omitempty"` // the agent keeps at most 16 MiB of each
+}
+
+// GuestExec runs a program in the guest and waits until it exits, or ctx ends.
+func (m *Manager) GuestExec(ctx context.Context, name string, req qemu.GuestExecRequest) (*GuestExecResult, error) {
+	agent, err := m.GuestAgent(name)
+	if err != nil {
+		return nil, err
+	}
+	req.CaptureOutput = true
+	pid, err := agent.Exec(req)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		st, err := agent.ExecStatus(pid)
+		if err != nil {
+			return nil, err
+		}
+		if st.Exited {
+			return &GuestExecResult{
+				ExitCode:  st.ExitCode,
+				Signal:    st.Signal,
+				Stdout:    st.Stdout,
+				Stderr:    st.Stderr,
+				Truncated: st.OutTruncated || st.ErrTruncated,
+			}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s (pid %d in the guest) did not exit in time", req.Path, pid)
+		case <-time.After(guestExecPoll):
+		}
+	}
+}
+
+// GuestSyncTime sets the guest clock to the host's, which drifts after a pause or snapshot restore.
+func (m *Manager) GuestSyncTime(name string) error {
+	agent, err := m.GuestAgent(name)
+	if err != nil {
+		return err
+	}
+	return agent.SetTime(time.Now())
+}
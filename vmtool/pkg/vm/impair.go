@@ -0,0 +1,76 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/network"
+)
+
+// SetImpairment changes the simulated link conditions of a NIC on a virtual network.
+// A running switch picks the change up immediately; with persist it is also saved to the VM configuration.
+// nil drops a runtime override and goes back to the saved configuration.
+func (m *Manager) SetImpairment(name string, index int, imp *config.ImpairmentConfig, persist bool) error {
+	cfg, nic, err := m.virtualNIC(name, index)
+	if err != nil {
+		return err
+	}
+	if imp != nil {
+		if err := imp.Validate(); err != nil {
+			return err
+		}
+	} else if persist {
+		return fmt.Errorf("resetting to the saved impairment can't be saved")
+	}
+
+	running := network.IsRunning(nic.Network)
+	if !running && !persist {
+		return fmt.Errorf("network %s is not running, save the impairment to the configuration instead", nic.Network)
+	}
+	if persist {
+		// The stored configuration is shared with concurrent readers, so a copy is changed and swapped in.
+		updated := *cfg
+		updated.AdditionalNetworks = append([]config.NetworkConfig(nil), cfg.AdditionalNetworks...)
+		nic := updated.NIC(index)
+		if *imp == (config.ImpairmentConfig{}) {
+			nic.Impairment = nil
+		} else {
+			saved := *imp
+			nic.Impairment = &saved
+		}
+		if err := m.store.SaveVM(&updated); err != nil {
+			return err
+		}
+	}
+	if running {
+		return network.SetImpairment(nic.Network, cfg.MACAddress(index), imp)
+	}
+	return nil
+}
+
+// GetImpairment returns the impairment in effect for a NIC, falling back to the configuration when its network is not running.
+func (m *Manager) GetImpairment(name string, index int) (*config.ImpairmentConfig, error) {
+	cfg, nic, err := m.virtualNIC(name, index)
+	if err != nil {
+		return nil, err
+	}
+	if network.IsRunning(nic.Network) {
+		return network.GetImpairment(nic.Network, cfg.MACAddress(index))
+	}
+	return nic.Impairment, nil
+}
+
+func (m *Manager) virtualNIC(name string, index int) (*config.VMConfig, *config.NetworkConfig, error) {
+	cfg, ok := m.store.GetVM(name)
+	if !ok {
+		return nil, nil, fmt.Errorf("VM %s not found", name)
+	}
+	nic := cfg.NIC(index)
+	if nic == nil {
+		return nil, nil, fmt.Errorf("VM %s has no nic %d", name, index)
+	}
+	if !nic.IsVirtual() {
+		return nil, nil, fmt.Errorf("nic %d of VM %s is not on a virtual network", index, name)
+	}
+	return cfg, nic, nil
+}
@@ -62,7 +62,6 @@ func ImportUTM(bundlePath string) (*config.VMConfig, []string, error) {
 		},
 		Display: config.DisplayConfig{
 			Enabled: true,
-			VNCAddr: ":0",
 		},
 		Boot: config.BootConfig{
 			Order: utmCfg.System.BootOrder,
@@ -82,13 +81,13 @@ func ImportUTM(bundlePath string) (*config.VMConfig, []string, error) {
 	if len(utmCfg.Networks) > 0 {
 		n := utmCfg.Networks[0]
 		vmCfg.Network = config.NetworkConfig{
-			Mode:     n.NetworkMode,
-			Hardware: n.Hardware,
+			Mode:       n.NetworkMode,
+			Hardware:   n.Hardware,
+			MACAddress: n.MACAddress,
 		}
 		if n.NetworkMode == "shared" {
 			vmCfg.Network.Mode = "user"
 		}
-		// MAC address could be added to NetworkConfig if needed
 	}
 
 	warnings = append(warnings, "Converted SPICE to VNC (SPICE not supported)")
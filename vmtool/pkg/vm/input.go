@@ -0,0 +1,153 @@
+package vm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/keymap"
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// DefaultKeyDelay is the pause between keystrokes when typing text.
+const DefaultKeyDelay = 20 * time.Millisecond
+
+// Absolute pointer coordinates QEMU expects range from 0 to this.
+const absMax = 0x7fff
+
+// TypeText types text on the guest keyboard, as laid out by layout (us when empty).
+// The whole text is checked before the first key is sent.
+func (m *Manager) TypeText(name, text, layout string, delay time.Duration) error {
+	l, err := keymap.Lookup(layout)
+	if err != nil {
+		return err
+	}
+	combos, err := l.Type(text)
+	if err != nil {
+		return err
+	}
+	return m.SendKeys(name, combos, 0, delay)
+}
+
+// SendKeys presses each combination in turn, holding it for hold (QEMU's default when 0)
+// and pausing delay between them.
+func (m *Manager) SendKeys(name string, combos []keymap.Combo, hold, delay time.Duration) error {
+	runner, err := m.lookup(name)
+	if err != nil {
+		return err
+	}
+	for i, combo := range combos {
+		if i > 0 && delay > 0 {
+			time.Sleep(delay)
+		}
+		if err := runner.SendKey(combo, int(hold.Milliseconds())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MouseAction is one pointer operation.
+type MouseAction struct {
+	Action   string `json:"action"`      // move, click, double-click, down, up or scroll
+	X        int    `json:"x,omitempty"` // move target in screen pixels, or the distance with relative
+	Y        int    `json:"y,omitempty"`
+	Relative bool   `json:"relative,omitempty"` // move relative to the current position
+	Button   string `json:"button,omitempty"`   // left (default), right, middle, side or extra; scroll: up (default) or down
+	Amount   int    `json:"amount,omitempty"`   // scroll steps, 1 when 0
+}
+
+// Mouse performs pointer actions in order. Absolute moves need a tablet device
+// (display.tablet) in the guest; QEMU maps them onto the current screen size.
+func (m *Manager) Mouse(name string, actions []MouseAction) error {
+	runner, err := m.lookup(name)
+	if err != nil {
+		return err
+	}
+	var width, height int
+	for _, a := range actions {
+		var events []qemu.InputEvent
+		switch a.Action {
+		case "move":
+			if a.Relative {
+				events = append(events, axisEvent("rel", "x", a.X), axisEvent("rel", "y", a.Y))
+				break
+			}
+			if width == 0 {
+				img, err := m.CaptureScreen(name)
+				if err != nil {
+					return fmt.Errorf("failed to get the screen size: %v", err)
+				}
+				width, height = img.Bounds().Dx(), img.Bounds().Dy()
+			}
+			if a.X < 0 || a.Y < 0 || a.X >= width || a.Y >= height {
+				return fmt.Errorf("position %d,%d is outside the %dx%d screen", a.X, a.Y, width, height)
+			}
+			events = append(events,
+				axisEvent("abs", "x", a.X*absMax/max(1, width-1)),
+				axisEvent("abs", "y", a.Y*absMax/max(1, height-1)))
+		case "click", "double-click", "down", "up":
+			button, err := mouseButton(a.Button)
+			if err != nil {
+				return err
+			}
+			switch a.Action {
+			case "down":
+				events = append(events, buttonEvent(button, true))
+			case "up":
+				events = append(events, buttonEvent(button, false))
+			default:
+				clicks := 1
+				if a.Action == "double-click" {
+					clicks = 2
+				}
+				for i := 0; i < clicks; i++ {
+					if err := runner.SendInput([]qemu.InputEvent{buttonEvent(button, true)}); err != nil {
+						return err
+					}
+					time.Sleep(DefaultKeyDelay)
+					if err := runner.SendInput([]qemu.InputEvent{buttonEvent(button, false)}); err != nil {
+						return err
+					}
+					time.Sleep(DefaultKeyDelay)
+				}
+				continue
+			}
+		case "scroll":
+			button := "wheel-up"
+			switch a.Button {
+			case "", "up":
+			case "down":
+				button = "wheel-down"
+			default:
+				return fmt.Errorf("scroll direction must be up or down, not %q", a.Button)
+			}
+			for i := 0; i < max(1, a.Amount); i++ {
+				events = append(events, buttonEvent(button, true), buttonEvent(button, false))
+			}
+		default:
+			return fmt.Errorf("unknown mouse action %q", a.Action)
+		}
+		if err := runner.SendInput(events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mouseButton(b string) (string, error) {
+	switch b {
+	case "":
+		return "left", nil
+	case "left", "right", "middle", "side", "extra":
+		return b, nil
+	}
+	return "", fmt.Errorf("unknown mouse button %q", b)
+}
+
+func axisEvent(kind, axis string, value int) qemu.InputEvent {
+	return qemu.InputEvent{Type: kind, Data: map[string]interface{}{"axis": axis, "value": value}}
+}
+
+func buttonEvent(button string, down bool) qemu.InputEvent {
+	return qemu.InputEvent{Type: "btn", Data: map[string]interface{}{"button": button, "down": down}}
+}
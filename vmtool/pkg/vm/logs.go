@@ -0,0 +1,42 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/logs"
+)
+
+// openQEMULog rotates the VM's QEMU log if it has grown too big and opens it for QEMU to append to.
+func (m *Manager) openQEMULog(name string) (*os.File, error) {
+	path := logs.Path(m.dataDir, name, logs.SourceQEMU)
+	if err := os.MkdirAll(logs.Dir(m.dataDir, name), 0700); err != nil {
+		return nil, err
+	}
+	if st, err := os.Stat(path); err == nil && st.Size() > m.logs.MaxBytes() {
+		if err := logs.Rotate(path, m.logs.MaxFiles); err != nil {
+			return nil, err
+		}
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+}
+
+// logEvent notes what vmtool did with the VM in its QEMU log.
+func (m *Manager) logEvent(name, format string, args ...interface{}) {
+	f, err := os.OpenFile(logs.Path(m.dataDir, name, logs.SourceQEMU), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s vmtool: %s\n", time.Now().UTC().Format(logs.TimeFormat), fmt.Sprintf(format, args...))
+}
+
+// Logs sends the VM's QEMU and serial console log lines to fn; see logs.Tail.
+func (m *Manager) Logs(ctx context.Context, name string, opts logs.Options, fn func(logs.Entry) error) error {
+	if _, ok := m.store.GetVM(name); !ok {
+		return fmt.Errorf("VM %s not found", name)
+	}
+	return logs.Tail(ctx, m.dataDir, name, opts, fn)
+}
@@ -3,22 +3,48 @@ package vm
 import (
 	"context"
 	"fmt"
+	"log"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/console"
+	"github.com/utmapp/vmtool/pkg/logs"
+	"github.com/utmapp/vmtool/pkg/network"
 	"github.com/utmapp/vmtool/pkg/qemu"
 )
 
 type Manager struct {
 	store    *Store
+	networks *network.Store
 	running  map[string]*qemu.Runner
 	mu       sync.Mutex
+
+	vncRange config.VNCConfig
+	vncPorts map[string]int // allocated by this process, until QEMU has bound them
+	vncMu    sync.Mutex
+
+	logs config.LogConfig
+	// dataDir keeps what the manager remembers about VMs across restarts of the daemon, the
+	// directory the VM configurations are in.
+	dataDir string
+
+	restarts map[string]*restartState // guarded by mu
+	closing  bool                     // the daemon is shutting down, guarded by mu
 }
 
-func NewManager(store *Store) *Manager {
+func NewManager(store *Store, networks *network.Store, appCfg *config.AppConfig) *Manager {
 	return &Manager{
-		store:   store,
-		running: make(map[string]*qemu.Runner),
+		store:    store,
+		networks: networks,
+		running:  make(map[string]*qemu.Runner),
+		vncRange: appCfg.VNC,
+		vncPorts: make(map[string]int),
+		logs:     appCfg.Logs,
+		dataDir:  appCfg.DataDir(),
+		restarts: make(map[string]*restartState),
 	}
 }
 
@@ -41,96 +67,195 @@ func (m *Manager) StartVM(ctx context.Context, name string) error {
 		return fmt.Errorf("VM %s not found", name)
 	}
 
+	if qemu.NewRunner(cfg).Alive() {
+		m.mu.Lock()
+		delete(m.running, name)
+		m.mu.Unlock()
+		return fmt.Errorf("VM %s is already running", name)
+	}
+
+	if err := m.startNetworks(cfg); err != nil {
+		m.mu.Lock()
+		delete(m.running, name)
+		m.mu.Unlock()
+		return err
+	}
+
 	runner := qemu.NewRunner(cfg)
-	if err := runner.Start(ctx); err != nil {
+	m.vncMu.Lock()
+	vnc, err := m.allocateVNC(cfg)
+	if err == nil && vnc != nil {
+		m.vncPorts[name] = vnc.Port
+	}
+	m.vncMu.Unlock()
+	if err != nil {
+		m.mu.Lock()
+		delete(m.running, name)
+		m.mu.Unlock()
+		return err
+	}
+	runner.SetVNC(vnc)
+	resuming := m.prepareResume(name, runner)
+
+	output, err := m.openQEMULog(name)
+	if err != nil {
+		log.Printf("Failed to open the QEMU log of VM %s: %v", name, err)
+	} else {
+		fmt.Fprintf(output, "%s vmtool: starting VM %s\n", time.Now().UTC().Format(logs.TimeFormat), name)
+		runner.SetOutput(output)
+	}
+	// QEMU outlives the request or command that started it.
+	err = runner.Start(context.WithoutCancel(ctx))
+	if output != nil {
+		output.Close()
+	}
+	if err != nil {
 		// Clean up reservation on start failure.
+		m.logEvent(name, "failed to start: %v", err)
+		if resuming != "" {
+			os.Rename(resuming, m.suspendedPath(name))
+		}
+		m.releaseVNC(name)
 		m.mu.Lock()
 		delete(m.running, name)
 		m.mu.Unlock()
 		return err
 	}
 
+	m.clearStopped(name)
+	startedAt := time.Now()
+	if err := saveState(name, &RuntimeState{PID: runner.PID(), StartedAt: startedAt, VNC: vnc}); err != nil {
+		log.Printf("Failed to save runtime state of VM %s: %v", name, err)
+	}
+	if err := console.EnsureRunning(name); err != nil {
+		log.Printf("Serial console of VM %s is unavailable: %v", name, err)
+	}
+
 	m.mu.Lock()
 	m.running[name] = runner
 	m.mu.Unlock()
+	if resuming != "" {
+		go m.watchResume(name, runner, resuming)
+	}
 
 	go func() {
-		runner.Wait()
+		err := runner.Wait()
+		if err != nil {
+			m.logEvent(name, "VM exited: %v", err)
+		} else {
+			m.logEvent(name, "VM exited")
+		}
+		removeState(name)
+		m.releaseVNC(name)
 		m.mu.Lock()
 		delete(m.running, name)
 		m.mu.Unlock()
+		m.exited(name, time.Since(startedAt), err)
 	}()
 
 	return nil
 }
 
-func (m *Manager) StopVM(name string) error {
-	m.mu.Lock()
-	runner, ok := m.running[name]
-	m.mu.Unlock()
+func (m *Manager) releaseVNC(name string) {
+	m.vncMu.Lock()
+	delete(m.vncPorts, name)
+	m.vncMu.Unlock()
+}
 
-	if !ok {
-		return fmt.Errorf("VM %s is not running", name)
+// startNetworks makes sure the switch of every virtual network the VM is attached to is up.
+func (m *Manager) startNetworks(cfg *config.VMConfig) error {
+	for i, nic := range cfg.NICs() {
+		if !nic.IsVirtual() {
+			continue
+		}
+		netCfg, ok := m.networks.GetNetwork(nic.Network)
+		if !ok {
+			return fmt.Errorf("network %s not found", nic.Network)
+		}
+		if nic.IPAddress != "" {
+			if err := netCfg.CheckReservation(nic.IPAddress); err != nil {
+				return err
+			}
+			for _, h := range m.NetworkHosts(nic.Network) {
+				if h.IP == nic.IPAddress && !strings.EqualFold(h.MAC, cfg.MACAddress(i)) {
+					return fmt.Errorf("ip_address %s on network %s is also reserved for VM %s", nic.IPAddress, nic.Network, h.Name)
+				}
+			}
+		}
+		if err := network.EnsureRunning(nic.Network); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return runner.Stop()
+// AttachedVMs returns the VMs with at least one NIC on the named virtual network.
+func (m *Manager) AttachedVMs(networkName string) []*config.VMConfig {
+	var list []*config.VMConfig
+	for _, cfg := range m.store.ListVMs() {
+		for _, nic := range cfg.NICs() {
+			if nic.IsVirtual() && nic.Network == networkName {
+				list = append(list, cfg)
+				break
+			}
+		}
+	}
+	return list
 }
 
-func (m *Manager) PauseVM(name string) error {
-	m.mu.Lock()
-	runner, ok := m.running[name]
-	m.mu.Unlock()
+// NetworkHosts lists the NICs attached to the named virtual network, for DHCP reservations and DNS.
+func (m *Manager) NetworkHosts(networkName string) []network.Host {
+	var hosts []network.Host
+	for _, cfg := range m.AttachedVMs(networkName) {
+		for i, nic := range cfg.NICs() {
+			if nic.IsVirtual() && nic.Network == networkName {
+				hosts = append(hosts, network.Host{Name: cfg.Name, MAC: cfg.MACAddress(i), IP: nic.IPAddress, Impairment: nic.Impairment})
+			}
+		}
+	}
+	return hosts
+}
 
-	if !ok {
-		return fmt.Errorf("VM %s is not running", name)
+func (m *Manager) PauseVM(name string) error {
+	runner, err := m.lookup(name)
+	if err != nil {
+		return err
 	}
 
 	return runner.Pause()
 }
 
 func (m *Manager) ResumeVM(name string) error {
-	m.mu.Lock()
-	runner, ok := m.running[name]
-	m.mu.Unlock()
-
-	if !ok {
-		return fmt.Errorf("VM %s is not running", name)
+	runner, err := m.lookup(name)
+	if err != nil {
+		return err
 	}
 
 	return runner.Resume()
 }
 
 func (m *Manager) CreateSnapshot(vmName string, snapName string) error {
-	m.mu.Lock()
-	runner, ok := m.running[vmName]
-	m.mu.Unlock()
-
-	if !ok {
-		return fmt.Errorf("VM %s is not running", vmName)
+	runner, err := m.lookup(vmName)
+	if err != nil {
+		return err
 	}
 
 	return runner.CreateSnapshot(snapName)
 }
 
 func (m *Manager) RestoreSnapshot(vmName string, snapName string) error {
-	m.mu.Lock()
-	runner, ok := m.running[vmName]
-	m.mu.Unlock()
-
-	if !ok {
-		return fmt.Errorf("VM %s is not running", vmName)
+	runner, err := m.lookup(vmName)
+	if err != nil {
+		return err
 	}
 
 	return runner.RestoreSnapshot(snapName)
 }
 
 func (m *Manager) DeleteSnapshot(vmName string, snapName string) error {
-	m.mu.Lock()
-	runner, ok := m.running[vmName]
-	m.mu.Unlock()
-
-	if !ok {
-		return fmt.Errorf("VM %s is not running", vmName)
+	runner, err := m.lookup(vmName)
+	if err != nil {
+		return err
 	}
 
 	return runner.DeleteSnapshot(snapName)
@@ -138,25 +263,45 @@ func (m *Manager) DeleteSnapshot(vmName string, snapName string) error {
 
 func (m *Manager) GetStatus(name string) string {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	if _, ok := m.running[name]; ok {
+	_, ok := m.running[name]
+	m.mu.Unlock()
+	if ok {
 		return "running"
 	}
-	return "stopped"
+	if _, err := m.lookup(name); err != nil {
+		return "stopped"
+	}
+	return "running"
 }
 
-func (m *Manager) GetVNCPort(name string) int {
+// lookup returns the runner of a running VM. VMs started by another vmtool process,
+// such as the CLI or an earlier daemon, are attached through their QMP socket. Only VMs
+// with a runtime state, which StartVM leaves behind, are dialed, so that listing stopped
+// VMs stays cheap.
+func (m *Manager) lookup(name string) (*qemu.Runner, error) {
 	m.mu.Lock()
 	runner, ok := m.running[name]
 	m.mu.Unlock()
-
-	if !ok {
-		return 0
+	if ok && runner != nil {
+		return runner, nil
+	}
+	if _, err := loadState(name); err != nil {
+		return nil, fmt.Errorf("VM %s is not running", name)
 	}
 
-	return runner.GetVNCPort()
+	cfg, found := m.store.GetVM(name)
+	if !ok && found {
+		if runner := qemu.NewRunner(cfg); runner.Alive() {
+			return runner, nil
+		}
+	}
+	return nil, fmt.Errorf("VM %s is not running", name)
 }
 
 func (m *Manager) ListVMs() []*config.VMConfig {
 	return m.store.ListVMs()
 }
+
+func (m *Manager) GetVM(name string) (*config.VMConfig, bool) {
+	return m.store.GetVM(name)
+}
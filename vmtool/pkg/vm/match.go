@@ -0,0 +1,206 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultMatchTolerance = 0.05
+	DefaultMatchInterval  = time.Second
+
+	// candidates from the downscaled search that are checked at full resolution
+	matchCandidates = 8
+)
+
+type MatchOptions struct {
+	Region    image.Rectangle // search only here, the whole screen when empty
+	Tolerance float64         // mean colour difference accepted, 0..1
+	Interval  time.Duration   // time between screen captures
+}
+
+// ScreenMatch is where a template was found.
+type ScreenMatch struct {
+	X       int           `json:"x"`
+	Y       int           `json:"y"`
+	Width   int           `json:"width"`
+	Height  int           `json:"height"`
+	Score   float64       `json:"score"` // mean colour difference, 0 is identical
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// WaitScreen captures the VM's display every opts.Interval until tmpl appears, or ctx ends.
+// On timeout the error reports the closest match seen.
+func (m *Manager) WaitScreen(ctx context.Context, name string, tmpl image.Image, opts MatchOptions) (*ScreenMatch, error) {
+	if opts.Tolerance <= 0 {
+		opts.Tolerance = DefaultMatchTolerance
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultMatchInterval
+	}
+	start := time.Now()
+	best := -1.0
+	for {
+		screen, err := m.CaptureScreen(name)
+		if err != nil {
+			return nil, err
+		}
+		match, err := FindTemplate(screen, tmpl, opts.Region)
+		if err != nil {
+			return nil, err
+		}
+		if match.Score <= opts.Tolerance {
+			match.Elapsed = time.Since(start)
+			return match, nil
+		}
+		if best < 0 || match.Score < best {
+			best = match.Score
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("screen did not match after %s (closest difference %.3f, tolerance %.3f)",
+				time.Since(start).Round(time.Second), best, opts.Tolerance)
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+// pixels holds an image as RGB bytes. Template pixels that are mostly transparent are masked out of comparisons.
+type pixels struct {
+	w, h int
+	rgb  []uint8
+	mask []bool
+}
+
+func toPixels(img image.Image) *pixels {
+	b := img.Bounds()
+	p := &pixels{w: b.Dx(), h: b.Dy(), rgb: make([]uint8, b.Dx()*b.Dy()*3), mask: make([]bool, b.Dx()*b.Dy())}
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			i := y*p.w + x
+			p.rgb[i*3], p.rgb[i*3+1], p.rgb[i*3+2] = uint8(r>>8), uint8(g>>8), uint8(bl>>8)
+			p.mask[i] = a < 0x8000
+		}
+	}
+	return p
+}
+
+// diff sums the colour differences of tmpl placed at x,y, giving up once the sum exceeds limit.
+func diff(screen, tmpl *pixels, x, y int, limit int) (int, bool) {
+	sum := 0
+	for ty := 0; ty < tmpl.h; ty++ {
+		row := ((y+ty)*screen.w + x) * 3
+		for tx := 0; tx < tmpl.w; tx++ {
+			if tmpl.mask[ty*tmpl.w+tx] {
+				continue
+			}
+			s, t := screen.rgb[row+tx*3:], tmpl.rgb[(ty*tmpl.w+tx)*3:]
+			sum += absDiff(s[0], t[0]) + absDiff(s[1], t[1]) + absDiff(s[2], t[2])
+		}
+		if sum > limit {
+			return sum, false
+		}
+	}
+	return sum, true
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func (p *pixels) visible() int {
+	n := 0
+	for _, m := range p.mask {
+		if !m {
+			n++
+		}
+	}
+	return n
+}
+
+// FindTemplate returns the position in region where tmpl matches screen best. Larger templates are
+// searched on downscaled copies first and only the most promising places are checked at full resolution.
+func FindTemplate(screen, tmpl image.Image, region image.Rectangle) (*ScreenMatch, error) {
+	sb, tb := screen.Bounds(), tmpl.Bounds()
+	if region.Empty() {
+		region = image.Rect(0, 0, sb.Dx(), sb.Dy())
+	}
+	region = region.Intersect(image.Rect(0, 0, sb.Dx(), sb.Dy()))
+	if tb.Dx() > region.Dx() || tb.Dy() > region.Dy() {
+		return nil, fmt.Errorf("template of %dx%d does not fit into the %dx%d search area", tb.Dx(), tb.Dy(), region.Dx(), region.Dy())
+	}
+
+	full, ft := toPixels(screen), toPixels(tmpl)
+	n := ft.visible()
+	if n == 0 {
+		return nil, fmt.Errorf("template is fully transparent")
+	}
+	// Positions to check at full resolution, with the window around each.
+	type candidate struct {
+		x, y int
+		sum  int
+	}
+	var candidates []candidate
+	factor := min(8, min(tb.Dx(), tb.Dy())/16)
+	radius := 0
+	if factor > 1 {
+		radius = factor
+		cw, ch := max(1, sb.Dx()/factor), max(1, sb.Dy()/factor)
+		cs := toPixels(scaleImage(screen, cw, ch))
+		ct := toPixels(scaleImage(tmpl, max(1, tb.Dx()/factor), max(1, tb.Dy()/factor)))
+		if cn := ct.visible(); cn > 0 {
+			// Keep the best few; once there are enough, anything worse than the last is skipped early.
+			limit := cn*3*255 + 1
+			x0, y0 := (region.Min.X+factor-1)/factor, (region.Min.Y+factor-1)/factor
+			x1, y1 := min(cw-ct.w, (region.Max.X-tb.Dx())/factor), min(ch-ct.h, (region.Max.Y-tb.Dy())/factor)
+			for y := y0; y <= y1; y++ {
+				for x := x0; x <= x1; x++ {
+					sum, ok := diff(cs, ct, x, y, limit)
+					if !ok {
+						continue
+					}
+					i := sort.Search(len(candidates), func(i int) bool { return candidates[i].sum > sum })
+					candidates = append(candidates, candidate{})
+					copy(candidates[i+1:], candidates[i:])
+					candidates[i] = candidate{x * factor, y * factor, sum}
+					if len(candidates) > matchCandidates {
+						candidates = candidates[:matchCandidates]
+					}
+					if len(candidates) == matchCandidates {
+						limit = candidates[len(candidates)-1].sum - 1
+					}
+				}
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		// Small templates are searched everywhere at full size.
+		candidates = []candidate{{region.Min.X, region.Min.Y, 0}}
+		radius = max(region.Dx(), region.Dy())
+	}
+
+	best := &ScreenMatch{Width: tb.Dx(), Height: tb.Dy(), Score: 2}
+	bestSum := n*3*255 + 1
+	for _, c := range candidates {
+		for y := max(region.Min.Y, c.y-radius); y <= min(region.Max.Y-tb.Dy(), c.y+radius); y++ {
+			for x := max(region.Min.X, c.x-radius); x <= min(region.Max.X-tb.Dx(), c.x+radius); x++ {
+				if sum, ok := diff(full, ft, x, y, bestSum-1); ok && sum < bestSum {
+					bestSum = sum
+					best.X, best.Y, best.Score = x, y, float64(sum)/float64(n*3*255)
+				}
+			}
+		}
+	}
+	if best.Score > 1 {
+		best.Score = 1
+	}
+	return best, nil
+}
@@ -0,0 +1,60 @@
+package vm
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func testScreen(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 7), uint8(y * 5), uint8((x ^ y) * 3), 0xff})
+		}
+	}
+	return img
+}
+
+func TestFindTemplate(t *testing.T) {
+	screen := testScreen(320, 240)
+	for _, size := range []image.Point{{12, 10}, {64, 48}} {
+		at := image.Pt(137, 91)
+		tmpl := screen.SubImage(image.Rectangle{at, at.Add(size)})
+		match, err := FindTemplate(screen, tmpl, image.Rectangle{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match.X != at.X || match.Y != at.Y || match.Score != 0 {
+			t.Errorf("%v template: got %+v, want exact match at %v", size, match, at)
+		}
+	}
+
+	// Transparent template pixels are ignored.
+	tmpl := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	for y := 10; y < 30; y++ {
+		for x := 10; x < 30; x++ {
+			tmpl.Set(x, y, screen.At(200+x, 50+y))
+		}
+	}
+	match, err := FindTemplate(screen, tmpl, image.Rectangle{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match.X != 200 || match.Y != 50 || match.Score != 0 {
+		t.Errorf("masked template: got %+v", match)
+	}
+
+	// Outside the region it is not found.
+	match, err = FindTemplate(screen, screen.SubImage(image.Rect(10, 10, 50, 50)), image.Rect(100, 100, 300, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match.Score < DefaultMatchTolerance {
+		t.Errorf("template outside region matched: %+v", match)
+	}
+
+	if _, err := FindTemplate(screen, testScreen(400, 10), image.Rectangle{}); err == nil {
+		t.Error("template wider than the screen should fail")
+	}
+}
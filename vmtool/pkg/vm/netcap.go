@@ -0,0 +1,139 @@
+package vm
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type CaptureOptions struct {
+	NIC      int
+	Path     string        // pcap output, written by QEMU itself
+	Duration time.Duration // 0 runs until ctx is cancelled
+	MaxBytes int64         // 0 for no limit, packets past it are cut from the file
+	SnapLen  int           // bytes kept per packet, 0 for QEMU's default
+}
+
+type CaptureResult struct {
+	Path     string        `json:"path"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"`
+	Reason   string        `json:"reason"` // duration, size, cancelled, stopped
+}
+
+// CaptureNetwork records the traffic of a NIC into a pcap file with QEMU's filter-dump.
+// The filter is attached over QMP and always removed again before returning.
+func (m *Manager) CaptureNetwork(ctx context.Context, name string, opts CaptureOptions) (*CaptureResult, error) {
+	runner, err := m.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	// QEMU opens the file itself, make sure it is ours to overwrite and QEMU can create it.
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		return nil, err
+	}
+
+	id := fmt.Sprintf("vmtool-netcap%d-%d", opts.NIC, time.Now().UnixNano())
+	if err := runner.StartCapture(id, opts.NIC, path, opts.SnapLen); err != nil {
+		return nil, fmt.Errorf("failed to start capture: %v", err)
+	}
+
+	result := &CaptureResult{Path: path}
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+		// The size is only polled, QEMU may have written past the limit by then.
+		if opts.MaxBytes > 0 {
+			if err := truncatePcap(path, opts.MaxBytes); err != nil {
+				m.logEvent(name, "could not cut the capture to %d bytes: %v", opts.MaxBytes, err)
+			}
+		}
+		if info, err := os.Stat(path); err == nil {
+			result.Bytes = info.Size()
+		}
+	}()
+
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for result.Reason == "" {
+		select {
+		case <-ctx.Done():
+			result.Reason = "cancelled"
+			if ctx.Err() == context.DeadlineExceeded {
+				result.Reason = "duration"
+			}
+		case <-ticker.C:
+			if !runner.Alive() {
+				result.Reason = "stopped"
+				return result, nil
+			}
+			if opts.MaxBytes > 0 {
+				if info, err := os.Stat(path); err == nil && info.Size() >= opts.MaxBytes {
+					result.Reason = "size"
+				}
+			}
+		}
+	}
+
+	if err := runner.StopCapture(id); err != nil {
+		return result, fmt.Errorf("failed to remove capture filter %s: %v", id, err)
+	}
+	return result, nil
+}
+
+// truncatePcap cuts a pcap file after the last whole packet that ends within maxBytes.
+// The file header is always kept.
+func truncatePcap(path string, maxBytes int64) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() <= maxBytes {
+		return err
+	}
+
+	var header [24]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return err
+	}
+	// QEMU writes the file in host byte order, the magic number tells which that was.
+	var order binary.ByteOrder
+	switch binary.LittleEndian.Uint32(header[:4]) {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		order = binary.LittleEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		order = binary.BigEndian
+	default:
+		return fmt.Errorf("not a pcap file")
+	}
+
+	end := int64(len(header))
+	for {
+		var record [16]byte
+		if _, err := f.ReadAt(record[:], end); err != nil {
+			break
+		}
+		next := end + int64(len(record)) + int64(order.Uint32(record[8:12]))
+		if next > maxBytes || next > info.Size() {
+			break
+		}
+		end = next
+	}
+	return f.Truncate(end)
+}
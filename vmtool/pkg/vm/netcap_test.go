@@ -0,0 +1,46 @@
+package vm
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTruncatePcap(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		header := make([]byte, 24)
+		order.PutUint32(header, 0xa1b2c3d4)
+		data := header
+		for i := 0; i < 3; i++ {
+			record := make([]byte, 16+100)
+			order.PutUint32(record[8:], 100)
+			order.PutUint32(record[12:], 100)
+			data = append(data, record...)
+		}
+		path := filepath.Join(t.TempDir(), "out.pcap")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range []struct{ max, want int64 }{
+			{1000, 372}, // already within the limit
+			{24 + 2*116 + 50, 24 + 2*116},
+			{24 + 116, 24 + 116},
+			{10, 24},
+		} {
+			if err := truncatePcap(path, c.max); err != nil {
+				t.Fatal(err)
+			}
+			if info, _ := os.Stat(path); info.Size() != c.want {
+				t.Errorf("%v: cut to %d bytes, got %d, want %d", order, c.max, info.Size(), c.want)
+			}
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "out.pcap")
+	os.WriteFile(path, make([]byte, 100), 0644)
+	if err := truncatePcap(path, 50); err == nil {
+		t.Error("cut a file that isn't a pcap")
+	}
+}
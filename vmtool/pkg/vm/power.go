@@ -0,0 +1,157 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/keymap"
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// DefaultStopTimeout is how long the guest gets to power off before QEMU is quit.
+const DefaultStopTimeout = time.Minute
+
+// How long QEMU gets to exit after quit, or after being killed.
+const exitTimeout = 10 * time.Second
+
+// How a VM was stopped, in StopResult.
+const (
+	StopPowerdown = "powerdown" // the guest shut down after the ACPI power button
+	StopQuit      = "quit"      // QEMU exited on the QMP quit command
+	StopKill      = "kill"      // the QEMU process was killed
+)
+
+// StopOptions control StopVM.
+type StopOptions struct {
+	Timeout time.Duration // for the guest to power off, DefaultStopTimeout when 0
+	Force   bool          // skip the guest and quit QEMU right away
+}
+
+// StopResult tells which step stopped the VM.
+type StopResult struct {
+	Method  string        `json:"method"`
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// StopVM stops a VM and returns once QEMU is gone. The guest is asked to power off first, then
+// QEMU is told to quit, and killed as a last resort; Force starts at quit. The VM's restart
+// policy doesn't apply to this stop.
+func (m *Manager) StopVM(ctx context.Context, name string, opts StopOptions) (*StopResult, error) {
+	// Also when the VM isn't running, as a restart may be pending. Without the mark the VM would be
+	// restarted by its policy, so don't stop it if it can't be written.
+	if err := m.markStopped(name); err != nil {
+		return nil, fmt.Errorf("failed to record the stop of VM %s: %v", name, err)
+	}
+	m.resetRestarts(name)
+	runner, err := m.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	start := time.Now()
+	stopped := func(method string) (*StopResult, error) {
+		m.logEvent(name, "stopped by %s after %s", method, time.Since(start).Round(time.Millisecond))
+		return &StopResult{Method: method, Elapsed: time.Since(start)}, nil
+	}
+
+	if !opts.Force {
+		m.logEvent(name, "powering down, waiting up to %s for the guest", timeout)
+		sctx, cancel := context.WithTimeout(ctx, timeout)
+		err := runner.Shutdown(sctx)
+		cancel()
+		if err == nil && waitExit(ctx, runner) {
+			return stopped(StopPowerdown)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		m.logEvent(name, "guest did not power off (%v), quitting QEMU", err)
+	}
+
+	qctx, cancel := context.WithTimeout(ctx, exitTimeout)
+	err = runner.Quit(qctx)
+	cancel()
+	if err == nil && waitExit(ctx, runner) {
+		return stopped(StopQuit)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	m.logEvent(name, "QEMU did not quit (%v), killing it", err)
+
+	if err := killQEMU(name, runner); err != nil {
+		return nil, fmt.Errorf("failed to kill QEMU of VM %s: %v", name, err)
+	}
+	if waitExit(ctx, runner) {
+		return stopped(StopKill)
+	}
+	return nil, fmt.Errorf("VM %s is still running after QEMU was killed", name)
+}
+
+// waitExit polls until QEMU no longer answers, for up to exitTimeout.
+func waitExit(ctx context.Context, runner *qemu.Runner) bool {
+	deadline := time.Now().Add(exitTimeout)
+	for runner.Alive() {
+		if time.Now().After(deadline) || ctx.Err() != nil {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// killQEMU kills QEMU, which another vmtool process may have started; its PID is then in the runtime state.
+func killQEMU(name string, runner *qemu.Runner) error {
+	if runner.PID() != 0 {
+		return runner.Kill()
+	}
+	st, err := loadState(name)
+	if err != nil || st.PID == 0 {
+		return fmt.Errorf("PID unknown")
+	}
+	p, err := os.FindProcess(st.PID)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
+
+// ResetVM resets a VM like its reset button; the guest does not shut down.
+func (m *Manager) ResetVM(name string) error {
+	runner, err := m.lookup(name)
+	if err != nil {
+		return err
+	}
+	m.logEvent(name, "reset")
+	return runner.Reset()
+}
+
+// RebootVM asks the guest to reboot: through the guest agent when it answers, else by pressing
+// ctrl-alt-delete. It returns which was used, and doesn't wait for the reboot.
+func (m *Manager) RebootVM(name string) (string, error) {
+	runner, err := m.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if cfg, ok := m.store.GetVM(name); ok && cfg.GuestAgent {
+		agent := qemu.NewGuestAgent(qemu.GuestAgentSocketPath(name))
+		agent.Timeout = 2 * time.Second
+		if agent.Ping() == nil {
+			if err := agent.Shutdown("reboot"); err != nil {
+				return "", err
+			}
+			m.logEvent(name, "reboot requested through the guest agent")
+			return "guest-agent", nil
+		}
+	}
+	if err := runner.SendKey(keymap.Combo{"ctrl", "alt", "delete"}, 0); err != nil {
+		return "", err
+	}
+	m.logEvent(name, "reboot requested with ctrl-alt-delete")
+	return "ctrl-alt-delete", nil
+}
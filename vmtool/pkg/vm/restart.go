@@ -0,0 +1,159 @@
+package vm
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+const (
+	maxRestartBackoff = 5 * time.Minute
+	// A VM that ran this long started fine: its restarts in a row start over.
+	stableUptime = time.Minute
+	// This many exits in a row, each within stableUptime of starting, are a crash loop.
+	crashLoopExits = 3
+)
+
+// restartState tracks the restarts of a VM in a row.
+type restartState struct {
+	count int
+	timer *time.Timer
+}
+
+// stoppedPath marks a VM stopped through vmtool, so that restart policies leave it alone. It is kept
+// with the VM's data rather than the runtime state, as unless-stopped must remember it across reboots.
+func (m *Manager) stoppedPath(name string) string {
+	return filepath.Join(m.dataDir, "stopped", name)
+}
+
+func (m *Manager) markStopped(name string) error {
+	if err := os.MkdirAll(filepath.Dir(m.stoppedPath(name)), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(m.stoppedPath(name), []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0600)
+}
+
+func (m *Manager) clearStopped(name string) {
+	os.Remove(m.stoppedPath(name))
+}
+
+func (m *Manager) wasStopped(name string) bool {
+	_, err := os.Stat(m.stoppedPath(name))
+	return err == nil
+}
+
+// restartBackoff doubles base for each restart after the first, up to maxRestartBackoff.
+func restartBackoff(base time.Duration, restart int) time.Duration {
+	d := base
+	for i := 1; i < restart && d < maxRestartBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxRestartBackoff)
+}
+
+// shouldRestart tells whether policy restarts a VM that exited, with an error if failed.
+func shouldRestart(policy string, failed bool) bool {
+	switch policy {
+	case config.RestartAlways, config.RestartUnlessStopped:
+		return true
+	case config.RestartOnFailure:
+		return failed
+	}
+	return false
+}
+
+// exited applies the VM's restart policy after QEMU exited on its own, having run for uptime.
+func (m *Manager) exited(name string, uptime time.Duration, exitErr error) {
+	m.mu.Lock()
+	closing := m.closing
+	m.mu.Unlock()
+	if closing || m.wasStopped(name) {
+		return
+	}
+	if exitErr != nil {
+		m.emit(name, EventCrash, "QEMU exited after %s: %v", uptime.Round(time.Second), exitErr)
+	} else {
+		m.emit(name, EventExit, "QEMU exited after %s", uptime.Round(time.Second))
+	}
+	m.scheduleRestart(name, uptime, exitErr != nil)
+}
+
+func (m *Manager) scheduleRestart(name string, uptime time.Duration, failed bool) {
+	cfg, ok := m.store.GetVM(name)
+	if !ok || !shouldRestart(cfg.Restart.Policy, failed) {
+		m.resetRestarts(name)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.restarts[name]
+	if st == nil || uptime >= stableUptime {
+		st = &restartState{}
+		m.restarts[name] = st
+	}
+	if limit := cfg.Restart.MaxRetries; cfg.Restart.Policy == config.RestartOnFailure && limit > 0 && st.count >= limit {
+		m.emit(name, EventGaveUp, "not restarting after %d restarts in a row", st.count)
+		delete(m.restarts, name)
+		return
+	}
+	st.count++
+	delay := restartBackoff(cfg.Restart.BackoffDuration(), st.count)
+	if uptime < stableUptime && st.count == crashLoopExits {
+		m.emit(name, EventCrashLoop, "exited %d times in a row within %s of starting, backing off up to %s", st.count, stableUptime, maxRestartBackoff)
+	}
+	m.emit(name, EventRestart, "restart %d by policy %s in %s", st.count, cfg.Restart.Policy, delay)
+	st.timer = time.AfterFunc(delay, func() {
+		// Stopped or started through vmtool meanwhile.
+		if m.wasStopped(name) || m.GetStatus(name) == "running" {
+			return
+		}
+		if err := m.StartVM(context.Background(), name); err != nil {
+			m.emit(name, EventRestartFailed, "%v", err)
+			m.scheduleRestart(name, 0, true)
+		}
+	})
+}
+
+// resetRestarts forgets the restarts of a VM in a row and cancels a scheduled one.
+func (m *Manager) resetRestarts(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if st := m.restarts[name]; st != nil && st.timer != nil {
+		st.timer.Stop()
+	}
+	delete(m.restarts, name)
+}
+
+// Autostart starts the VMs that come up with the daemon: those with autostart or the always policy,
+// those with unless-stopped that weren't last stopped through vmtool, and those suspended when the
+// last daemon shut down. They start in dependency order, together with the VMs they depend on.
+func (m *Manager) Autostart(ctx context.Context) {
+	var names []string
+	for _, cfg := range m.store.ListVMs() {
+		policy := cfg.Restart.Policy
+		if cfg.Autostart || policy == config.RestartAlways || (policy == config.RestartUnlessStopped && !m.wasStopped(cfg.Name)) || m.wasSuspended(cfg.Name) {
+			names = append(names, cfg.Name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	err := m.Up(ctx, names, func(s Step) {
+		switch s.Action {
+		case "started":
+			m.emit(s.VM, EventAutostart, "started with the daemon")
+		case "failed", "skipped":
+			m.emit(s.VM, EventStartFailed, "%s", s.Detail)
+		}
+	})
+	if err != nil {
+		log.Printf("Autostart: %v", err)
+	}
+}
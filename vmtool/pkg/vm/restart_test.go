@@ -0,0 +1,108 @@
+package vm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		restart int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{20, maxRestartBackoff},
+	} {
+		if got := restartBackoff(time.Second, tc.restart); got != tc.want {
+			t.Errorf("restart %d: got %s, want %s", tc.restart, got, tc.want)
+		}
+	}
+}
+
+func TestScheduleRestart(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("VMTOOL_HOME", home)
+	// paths.vms moved out of the default data directory, events follow it.
+	data := t.TempDir()
+	store, err := NewStore(filepath.Join(data, "machines"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Long backoffs, so that no restart actually happens during the test.
+	for _, cfg := range []*config.VMConfig{
+		{Name: "flaky", Restart: config.RestartConfig{Policy: config.RestartOnFailure, MaxRetries: 2, Backoff: "5m"}},
+		{Name: "looping", Restart: config.RestartConfig{Policy: config.RestartAlways, Backoff: "5m"}},
+		{Name: "plain"},
+	} {
+		if err := store.SaveVM(cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := NewManager(store, nil, &config.AppConfig{Paths: config.PathConfig{VMs: filepath.Join(data, "machines")}})
+	defer func() {
+		for name := range m.restarts {
+			m.resetRestarts(name)
+		}
+	}()
+
+	m.exited("plain", time.Second, context.Canceled)
+	m.exited("flaky", time.Hour, nil) // clean exit, not restarted by on-failure
+	for i := 0; i < 3; i++ {
+		m.exited("flaky", time.Second, context.Canceled)
+	}
+	for i := 0; i < 3; i++ {
+		m.exited("looping", time.Second, nil)
+	}
+	// A stable run starts the count over.
+	m.exited("looping", 2*stableUptime, nil)
+	if err := m.markStopped("looping"); err != nil {
+		t.Fatal(err)
+	}
+	m.exited("looping", time.Second, nil)
+
+	types := map[string][]string{}
+	err = m.Events(context.Background(), EventOptions{}, func(e Event) error {
+		types[e.VM] = append(types[e.VM], e.Type)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, want ...string) {
+		t.Helper()
+		got := types[name]
+		if len(got) != len(want) {
+			t.Fatalf("%s: got events %v, want %v", name, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s: got events %v, want %v", name, got, want)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(data, "events.log")); err != nil {
+		t.Errorf("events not kept in the data directory: %v", err)
+	}
+	check("plain", EventCrash)
+	check("flaky", EventExit, EventCrash, EventRestart, EventCrash, EventRestart, EventCrash, EventGaveUp)
+	check("looping", EventExit, EventRestart, EventExit, EventRestart, EventExit, EventCrashLoop, EventRestart, EventExit, EventRestart)
+	if st := m.restarts["looping"]; st == nil || st.count != 1 {
+		t.Errorf("looping: restart count not reset after a stable run: %+v", st)
+	}
+
+	var tail []Event
+	m.Events(context.Background(), EventOptions{VM: "looping", Tail: 1}, func(e Event) error {
+		tail = append(tail, e)
+		return nil
+	})
+	if len(tail) != 1 || tail[0].Type != EventRestart || tail[0].Message != "restart 1 by policy always in 5m0s" {
+		t.Errorf("tail: got %+v", tail)
+	}
+}
@@ -0,0 +1,177 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// Thumbnails for the dashboard grid are this wide.
+const ThumbnailWidth = 320
+
+// Screenshots are never scaled beyond this in either direction.
+const maxScreenshotSize = 8192
+
+type ScreenshotOptions struct {
+	Width  int     // fit into this width, keeping the aspect ratio
+	Height int     // fit into this height, keeping the aspect ratio
+	Scale  float64 // factor applied when no width or height is given, 0 for 1
+}
+
+// CaptureScreen grabs the VM's display with QMP screendump.
+func (m *Manager) CaptureScreen(name string) (image.Image, error) {
+	runner, err := m.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	// QEMU writes the file itself, so use the VM's runtime directory which it can reach.
+	dir := qemu.RuntimeDir(name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "screendump-"+uuid.New().String())
+	defer os.Remove(path)
+
+	format, err := runner.Screendump(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if format == "ppm" {
+		return decodePPM(bufio.NewReader(f))
+	}
+	return png.Decode(f)
+}
+
+// Screenshot returns the VM's display as PNG, scaled according to opts.
+func (m *Manager) Screenshot(name string, opts ScreenshotOptions) ([]byte, error) {
+	img, err := m.CaptureScreen(name)
+	if err != nil {
+		return nil, err
+	}
+	if w, h := fitSize(img.Bounds().Dx(), img.Bounds().Dy(), opts); w != img.Bounds().Dx() || h != img.Bounds().Dy() {
+		img = scaleImage(img, w, h)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func fitSize(w, h int, opts ScreenshotOptions) (int, int) {
+	scale := 1.0
+	switch {
+	case opts.Width > 0 || opts.Height > 0:
+		scale = 1e9
+		if opts.Width > 0 {
+			scale = float64(opts.Width) / float64(w)
+		}
+		if opts.Height > 0 && float64(opts.Height)/float64(h) < scale {
+			scale = float64(opts.Height) / float64(h)
+		}
+	case opts.Scale > 0:
+		scale = opts.Scale
+	}
+	if s := float64(maxScreenshotSize) / float64(max(w, h)); scale > s {
+		scale = s
+	}
+	return max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5))
+}
+
+// scaleImage resizes with a box filter when shrinking, which keeps text legible in thumbnails,
+// and nearest neighbour when enlarging.
+func scaleImage(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/h)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/w)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// decodePPM reads the binary (P6) PPM older QEMU versions write for screendump.
+func decodePPM(r *bufio.Reader) (image.Image, error) {
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "P6" {
+		return nil, fmt.Errorf("not a binary PPM image")
+	}
+	var header [3]int
+	for i := range header {
+		v, err := ppmInt(r)
+		if err != nil {
+			return nil, err
+		}
+		header[i] = v
+	}
+	w, h, maxVal := header[0], header[1], header[2]
+	if w <= 0 || h <= 0 || w*h > 1<<26 || maxVal != 255 {
+		return nil, fmt.Errorf("unsupported PPM image %dx%d with max value %d", w, h, maxVal)
+	}
+	pix := make([]byte, w*h*3)
+	if _, err := io.ReadFull(r, pix); err != nil {
+		return nil, fmt.Errorf("truncated PPM image: %v", err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < w*h; i++ {
+		copy(img.Pix[i*4:i*4+3], pix[i*3:i*3+3])
+		img.Pix[i*4+3] = 0xff
+	}
+	return img, nil
+}
+
+// ppmInt reads a header number, skipping whitespace and comments. The single whitespace after it is consumed.
+func ppmInt(r *bufio.Reader) (int, error) {
+	var digits []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("truncated PPM header")
+		}
+		switch {
+		case c == '#' && len(digits) == 0:
+			if _, err := r.ReadString('\n'); err != nil {
+				return 0, fmt.Errorf("truncated PPM header")
+			}
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if len(digits) > 0 {
+				return strconv.Atoi(string(digits))
+			}
+		default:
+			return 0, fmt.Errorf("invalid PPM header")
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package vm
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestDecodePPMAndScale(t *testing.T) {
+	// 2x2 image: red, green / blue, white
+	ppm := "P6\n# from QEMU\n2 2\n255\n" + "\xff\x00\x00\x00\xff\x00\x00\x00\xff\xff\xff\xff"
+	img, err := decodePPM(bufio.NewReader(strings.NewReader(ppm)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := img.At(1, 0).RGBA(); r != 0 || g != 0xffff || b != 0 {
+		t.Errorf("pixel (1,0) = %x %x %x, want green", r, g, b)
+	}
+
+	small := scaleImage(img, 1, 1)
+	if got := small.Pix[:4]; got[0] != 0x7f || got[1] != 0x7f || got[2] != 0x7f || got[3] != 0xff {
+		t.Errorf("averaged pixel %v, want mid grey", got)
+	}
+	if w, h := fitSize(1024, 768, ScreenshotOptions{Width: ThumbnailWidth}); w != 320 || h != 240 {
+		t.Errorf("thumbnail size %dx%d", w, h)
+	}
+}
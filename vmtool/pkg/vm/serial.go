@@ -0,0 +1,65 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/utmapp/vmtool/pkg/console"
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// SerialPort is one of a VM's serial ports and where its host side is.
+type SerialPort struct {
+	ID      string `json:"id"`
+	Device  string `json:"device"`           // serial or virtio
+	Backend string `json:"backend"`          // console for the built-in one, or the configured backend
+	Target  string `json:"target,omitempty"` // pty device, file, socket or tcp address
+}
+
+// SerialPorts lists the VM's serial ports, starting with the built-in console.
+// The device of a pty port is only known while the VM runs.
+func (m *Manager) SerialPorts(name string) ([]SerialPort, error) {
+	cfg, ok := m.store.GetVM(name)
+	if !ok {
+		return nil, fmt.Errorf("VM %s not found", name)
+	}
+	var chardevs map[string]string
+	if runner, err := m.lookup(name); err == nil {
+		chardevs, _ = runner.Chardevs()
+	}
+
+	ports := []SerialPort{{ID: "serial0", Device: "serial", Backend: "console", Target: console.SocketPath(name)}}
+	for i, s := range cfg.Serials {
+		p := SerialPort{ID: qemu.SerialID(i), Device: s.Device, Backend: s.Backend, Target: qemu.SerialTarget(name, i, s)}
+		if p.Device == "" {
+			p.Device = "serial"
+		}
+		if s.Backend == "pty" {
+			p.Target = strings.TrimPrefix(chardevs[p.ID], "pty:")
+		}
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+// ReadSerial takes what the guest wrote to a ring buffer port since the last read.
+func (m *Manager) ReadSerial(name, id string) (string, error) {
+	cfg, ok := m.store.GetVM(name)
+	if !ok {
+		return "", fmt.Errorf("VM %s not found", name)
+	}
+	for i, s := range cfg.Serials {
+		if qemu.SerialID(i) != id {
+			continue
+		}
+		if s.Backend != "ring" {
+			return "", fmt.Errorf("serial port %s of VM %s is not a ring buffer", id, name)
+		}
+		runner, err := m.lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return runner.ReadRing(id, int(qemu.RingSize(s)))
+	}
+	return "", fmt.Errorf("VM %s has no serial port %s", name, id)
+}
@@ -0,0 +1,144 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// How often a resuming VM is checked for having loaded its saved state.
+const resumeInterval = 250 * time.Millisecond
+
+// suspendedPath holds the state of a VM saved when the daemon shut down. While it exists, the
+// next start resumes the VM from it rather than booting.
+func (m *Manager) suspendedPath(name string) string {
+	return filepath.Join(m.dataDir, "suspended", name)
+}
+
+func (m *Manager) wasSuspended(name string) bool {
+	_, err := os.Stat(m.suspendedPath(name))
+	return err == nil
+}
+
+// Shutdown applies the daemon's shutdown policy to the running VMs: detach leaves them running,
+// poweroff stops them and suspend saves them to disk, each before the VMs it depends on. Pending
+// restarts are cancelled and VMs exiting from now on are not restarted.
+func (m *Manager) Shutdown(ctx context.Context, cfg config.ShutdownConfig, progress func(Step)) error {
+	m.mu.Lock()
+	m.closing = true
+	for name, st := range m.restarts {
+		if st.timer != nil {
+			st.timer.Stop()
+		}
+		delete(m.restarts, name)
+	}
+	m.mu.Unlock()
+	if cfg.Policy != config.ShutdownPoweroff && cfg.Policy != config.ShutdownSuspend {
+		return nil
+	}
+
+	var names []string
+	for _, vm := range m.store.ListVMs() {
+		if m.GetStatus(vm.Name) == "running" {
+			names = append(names, vm.Name)
+		}
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range m.stopOrder(names) {
+		if cfg.Policy == config.ShutdownSuspend {
+			progress(Step{VM: name, Action: "suspending"})
+			err := m.suspendVM(ctx, name, cfg.TimeoutDuration())
+			if err == nil {
+				progress(Step{VM: name, Action: "suspended"})
+				continue
+			}
+			if ctx.Err() != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", name, err))
+				break
+			}
+			m.logEvent(name, "saving state failed (%v), stopping instead", err)
+		}
+		progress(Step{VM: name, Action: "stopping"})
+		res, err := m.StopVM(ctx, name, StopOptions{Timeout: cfg.TimeoutDuration()})
+		if err != nil {
+			progress(Step{VM: name, Action: "failed", Detail: err.Error()})
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		// Stopping with the daemon doesn't count as being stopped through vmtool, so unless-stopped
+		// VMs come back with the next daemon.
+		m.clearStopped(name)
+		m.emit(name, EventShutdown, "stopped by %s with the daemon", res.Method)
+		progress(Step{VM: name, Action: "stopped", Detail: res.Method})
+	}
+	return errors.Join(errs...)
+}
+
+// suspendVM saves the state of a running VM to disk and makes QEMU exit.
+func (m *Manager) suspendVM(ctx context.Context, name string, timeout time.Duration) error {
+	runner, err := m.lookup(name)
+	if err != nil {
+		return err
+	}
+	path := m.suspendedPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	start := time.Now()
+	sctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := runner.Save(sctx, path+".part"); err != nil {
+		os.Remove(path + ".part")
+		return err
+	}
+	if !waitExit(ctx, runner) {
+		os.Remove(path + ".part")
+		return fmt.Errorf("QEMU did not exit after saving the state")
+	}
+	if err := os.Rename(path+".part", path); err != nil {
+		return err
+	}
+	m.emit(name, EventSuspend, "state saved in %s with the daemon", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// prepareResume makes the runner of a suspended VM load its saved state. The state is moved aside
+// first, so that it is never loaded twice, even when this process exits before the VM has resumed.
+func (m *Manager) prepareResume(name string, runner *qemu.Runner) string {
+	path := m.suspendedPath(name)
+	if os.Rename(path, path+".resuming") != nil {
+		return ""
+	}
+	runner.SetIncoming(path + ".resuming")
+	return path + ".resuming"
+}
+
+// watchResume removes the saved state once the VM has loaded it, or QEMU has given up on it.
+func (m *Manager) watchResume(name string, runner *qemu.Runner, path string) {
+	defer os.Remove(path)
+	for {
+		time.Sleep(resumeInterval)
+		m.mu.Lock()
+		current := m.running[name]
+		m.mu.Unlock()
+		if current != runner {
+			m.emit(name, EventResumeFailed, "QEMU exited while loading the saved state, which was discarded; see the QEMU log")
+			return
+		}
+		if status, err := runner.Status(); err == nil && status != "inmigrate" {
+			m.emit(name, EventResume, "resumed from the state saved with the daemon")
+			return
+		}
+	}
+}
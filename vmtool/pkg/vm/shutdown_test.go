@@ -0,0 +1,264 @@
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// qmpCalls records the commands fake QEMUs were sent, as "vm command".
+type qmpCalls struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (c *qmpCalls) add(call string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.list = append(c.list, call)
+}
+
+func (c *qmpCalls) get() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.list...)
+}
+
+// fakeQEMU answers QMP for a running VM like QEMU would, and goes away on system_powerdown or quit.
+// A migration writes the state file at once, or fails with failMigrate.
+func fakeQEMU(t *testing.T, cfg *config.VMConfig, calls *qmpCalls, failMigrate bool) {
+	t.Helper()
+	ln, err := net.Listen("unix", filepath.Join(config.GetDefaultDataDir(), cfg.UUID+".qmp"))
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	if err := saveState(cfg.Name, &RuntimeState{PID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	reply := func(enc *json.Encoder, ret map[string]interface{}) {
+		enc.Encode(map[string]interface{}{"return": ret})
+	}
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		dec := json.NewDecoder(conn)
+		enc := json.NewEncoder(conn)
+		enc.Encode(map[string]interface{}{"QMP": map[string]interface{}{}})
+		for {
+			var cmd struct {
+				Execute   string                 `json:"execute"`
+				Arguments map[string]interface{} `json:"arguments"`
+			}
+			if dec.Decode(&cmd) != nil {
+				return
+			}
+			switch cmd.Execute {
+			case "query-status":
+				reply(enc, map[string]interface{}{"status": "running"})
+			case "query-migrate":
+				reply(enc, map[string]interface{}{"status": "completed"})
+			case "migrate":
+				calls.add(cfg.Name + " migrate")
+				if failMigrate {
+					enc.Encode(map[string]interface{}{"error": map[string]interface{}{"desc": "migration is not supported"}})
+					continue
+				}
+				uri, _ := cmd.Arguments["uri"].(string)
+				os.WriteFile(strings.Trim(strings.TrimPrefix(uri, "exec:cat > "), "'"), []byte("state"), 0600)
+				reply(enc, nil)
+			case "system_powerdown", "quit":
+				calls.add(cfg.Name + " " + cmd.Execute)
+				reply(enc, nil)
+				enc.Encode(map[string]interface{}{"event": "SHUTDOWN"})
+				ln.Close()
+				return
+			default:
+				reply(enc, nil)
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+}
+
+// shutdownTestManager has VMs db, web depending on db, and idle, which isn't running.
+func shutdownTestManager(t *testing.T) (*Manager, map[string]*config.VMConfig) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("VMTOOL_HOME", home)
+	store, err := NewStore(filepath.Join(home, "machines"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vms := make(map[string]*config.VMConfig)
+	for _, cfg := range []*config.VMConfig{
+		{Name: "db", UUID: "33333333-3333-3333-3333-333333333333"},
+		{Name: "web", UUID: "44444444-4444-4444-4444-444444444444", DependsOn: []config.Dependency{{VM: "db"}}},
+		{Name: "idle", UUID: "55555555-5555-5555-5555-555555555555"},
+	} {
+		if err := store.SaveVM(cfg); err != nil {
+			t.Fatal(err)
+		}
+		vms[cfg.Name] = cfg
+	}
+	return NewManager(store, nil, &config.AppConfig{}), vms
+}
+
+func eventTypes(t *testing.T, m *Manager) map[string][]string {
+	t.Helper()
+	types := map[string][]string{}
+	err := m.Events(context.Background(), EventOptions{}, func(e Event) error {
+		types[e.VM] = append(types[e.VM], e.Type)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return types
+}
+
+func TestShutdownDetach(t *testing.T) {
+	m, vms := shutdownTestManager(t)
+	calls := &qmpCalls{}
+	fakeQEMU(t, vms["db"], calls, false)
+
+	err := m.Shutdown(context.Background(), config.ShutdownConfig{Policy: config.ShutdownDetach}, func(s Step) {
+		t.Errorf("unexpected step %+v", s)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := calls.get(); len(got) != 0 {
+		t.Errorf("detach sent %v", got)
+	}
+	if m.GetStatus("db") != "running" {
+		t.Error("detach stopped db")
+	}
+}
+
+func TestShutdownPoweroff(t *testing.T) {
+	m, vms := shutdownTestManager(t)
+	calls := &qmpCalls{}
+	fakeQEMU(t, vms["db"], calls, false)
+	fakeQEMU(t, vms["web"], calls, false)
+
+	var steps []string
+	err := m.Shutdown(context.Background(), config.ShutdownConfig{Policy: config.ShutdownPoweroff}, func(s Step) {
+		steps = append(steps, s.VM+" "+s.Action)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Dependents first, so that web never runs without its database.
+	if want := []string{"web system_powerdown", "db system_powerdown"}; !reflect.DeepEqual(calls.get(), want) {
+		t.Errorf("calls %v, want %v", calls.get(), want)
+	}
+	if want := []string{"web stopping", "web stopped", "db stopping", "db stopped"}; !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps %v, want %v", steps, want)
+	}
+	for _, name := range []string{"db", "web"} {
+		if m.wasStopped(name) {
+			t.Errorf("%s is marked stopped through vmtool, unless-stopped would not bring it back", name)
+		}
+	}
+	types := eventTypes(t, m)
+	if !reflect.DeepEqual(types["db"], []string{EventShutdown}) || !reflect.DeepEqual(types["web"], []string{EventShutdown}) {
+		t.Errorf("events %v", types)
+	}
+}
+
+func TestShutdownSuspend(t *testing.T) {
+	m, vms := shutdownTestManager(t)
+	calls := &qmpCalls{}
+	fakeQEMU(t, vms["db"], calls, false)
+	fakeQEMU(t, vms["web"], calls, true)
+
+	var steps []string
+	err := m.Shutdown(context.Background(), config.ShutdownConfig{Policy: config.ShutdownSuspend}, func(s Step) {
+		steps = append(steps, s.VM+" "+s.Action)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// web can't be saved and is powered off instead.
+	if want := []string{"web migrate", "web system_powerdown", "db migrate", "db quit"}; !reflect.DeepEqual(calls.get(), want) {
+		t.Errorf("calls %v, want %v", calls.get(), want)
+	}
+	if want := []string{"web suspending", "web stopping", "web stopped", "db suspending", "db suspended"}; !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps %v, want %v", steps, want)
+	}
+	if !m.wasSuspended("db") {
+		t.Error("db was not suspended")
+	}
+	if m.wasSuspended("web") {
+		t.Error("web was suspended")
+	}
+	for _, name := range []string{"db", "web"} {
+		if _, err := os.Stat(m.suspendedPath(name) + ".part"); !os.IsNotExist(err) {
+			t.Errorf("partial state of %s left behind", name)
+		}
+	}
+}
+
+func TestResumeStateFile(t *testing.T) {
+	m, vms := shutdownTestManager(t)
+	path := m.suspendedPath("db")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("state"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The runner never got to run, as when QEMU failed to load the state.
+	runner := qemu.NewRunner(vms["db"])
+	resuming := m.prepareResume("db", runner)
+	if resuming != path+".resuming" {
+		t.Fatalf("resuming from %q", resuming)
+	}
+	if m.wasSuspended("db") {
+		t.Error("state still in place, it could be loaded twice")
+	}
+	if again := m.prepareResume("db", qemu.NewRunner(vms["db"])); again != "" {
+		t.Errorf("second resume from %q", again)
+	}
+	m.watchResume("db", runner, resuming)
+	if _, err := os.Stat(resuming); !os.IsNotExist(err) {
+		t.Error("state not removed after QEMU exited")
+	}
+
+	// A runner that came up and left the incoming state.
+	if err := os.WriteFile(path, []byte("state"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fakeQEMU(t, vms["db"], &qmpCalls{}, false)
+	runner = qemu.NewRunner(vms["db"])
+	resuming = m.prepareResume("db", runner)
+	m.mu.Lock()
+	m.running["db"] = runner
+	m.mu.Unlock()
+	m.watchResume("db", runner, resuming)
+	if _, err := os.Stat(resuming); !os.IsNotExist(err) {
+		t.Error("state not removed after resuming")
+	}
+
+	if want := []string{EventResumeFailed, EventResume}; !reflect.DeepEqual(eventTypes(t, m)["db"], want) {
+		t.Errorf("events %v, want %v", eventTypes(t, m)["db"], want)
+	}
+}
@@ -0,0 +1,140 @@
+package vm
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// SSHRoute is where the host reaches a guest's SSH server.
+type SSHRoute struct {
+	Host string
+	Port int
+	Via  string // how the route was found, for messages
+
+	close func() error
+}
+
+// Close removes the port forward a route may have added for the session.
+func (r *SSHRoute) Close() error {
+	if r.close != nil {
+		return r.close()
+	}
+	return nil
+}
+
+// SSHRoute finds a way to the guest's SSH server: a configured forward to its port on a user mode NIC,
+// else a temporary forward added to one, else the address the guest agent reports for a bridged NIC.
+func (m *Manager) SSHRoute(name string) (*SSHRoute, error) {
+	cfg, ok := m.store.GetVM(name)
+	if !ok {
+		return nil, fmt.Errorf("VM %s not found", name)
+	}
+	runner, err := m.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	guestPort := cfg.SSH.Port
+	if guestPort == 0 {
+		guestPort = 22
+	}
+	nics := cfg.NICs()
+
+	if route := forwardedRoute(cfg, guestPort); route != nil {
+		return route, nil
+	}
+
+	for i, nic := range nics {
+		if !isUserMode(nic) {
+			continue
+		}
+		port, err := freePort()
+		if err != nil {
+			return nil, err
+		}
+		netdev := qemu.NetdevID(i)
+		if err := runner.AddHostForward(netdev, "127.0.0.1", port, guestPort); err != nil {
+			return nil, fmt.Errorf("failed to forward a port to the guest: %v", err)
+		}
+		return &SSHRoute{Host: "127.0.0.1", Port: port, Via: "temporary port forward", close: func() error {
+			return runner.RemoveHostForward(netdev, "127.0.0.1", port)
+		}}, nil
+	}
+
+	for i, nic := range nics {
+		if nic.Mode != "bridged" {
+			continue
+		}
+		if !cfg.GuestAgent {
+			return nil, fmt.Errorf("VM %s is bridged, enable guest_agent so that its address can be found", name)
+		}
+		ip, err := m.guestIPv4(name, nic.MACAddress)
+		if err != nil {
+			return nil, err
+		}
+		if ip != "" {
+			return &SSHRoute{Host: ip, Port: guestPort, Via: fmt.Sprintf("guest agent (nic %d)", i)}, nil
+		}
+	}
+	return nil, fmt.Errorf("no route to the SSH server of VM %s: it has neither a user mode NIC nor a bridged one with an address", name)
+}
+
+// forwardedRoute returns the configured TCP forward to guestPort, if any.
+func forwardedRoute(cfg *config.VMConfig, guestPort int) *SSHRoute {
+	for _, nic := range cfg.NICs() {
+		if !isUserMode(nic) {
+			continue
+		}
+		for _, fw := range nic.PortForwards {
+			if (fw.Protocol == "" || fw.Protocol == "tcp") && fw.GuestPort == guestPort {
+				host := fw.HostIP
+				if host == "" || host == "0.0.0.0" {
+					host = "127.0.0.1"
+				}
+				return &SSHRoute{Host: host, Port: fw.HostPort, Via: "port forward"}
+			}
+		}
+	}
+	return nil
+}
+
+func isUserMode(nic config.NetworkConfig) bool {
+	return !nic.IsVirtual() && (nic.Mode == "" || nic.Mode == "user")
+}
+
+// guestIPv4 returns the guest's IPv4 address on the interface with mac, or its first global
+// one when mac is empty, as QEMU then picks the NIC's MAC itself.
+func (m *Manager) guestIPv4(name, mac string) (string, error) {
+	agent, err := m.GuestAgent(name)
+	if err != nil {
+		return "", err
+	}
+	ifaces, err := agent.NetworkInterfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		if mac != "" && !strings.EqualFold(iface.HardwareAddress, mac) {
+			continue
+		}
+		for _, addr := range iface.IPAddresses {
+			ip := net.ParseIP(addr.Address)
+			if addr.Type == "ipv4" && ip != nil && ip.IsGlobalUnicast() {
+				return addr.Address, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,32 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+func TestForwardedRoute(t *testing.T) {
+	cfg := &config.VMConfig{
+		Network: config.NetworkConfig{Mode: "virtual", Network: "lab"},
+		AdditionalNetworks: []config.NetworkConfig{{
+			Mode: "user",
+			PortForwards: []config.PortForward{
+				{Protocol: "udp", HostPort: 5353, GuestPort: 22},
+				{HostPort: 2222, GuestPort: 22, HostIP: "0.0.0.0"},
+				{Protocol: "tcp", HostPort: 8022, GuestPort: 8022, HostIP: "127.0.0.2"},
+			},
+		}},
+	}
+	route := forwardedRoute(cfg, 22)
+	if route == nil || route.Host != "127.0.0.1" || route.Port != 2222 {
+		t.Errorf("port 22 routed to %+v", route)
+	}
+	route = forwardedRoute(cfg, 8022)
+	if route == nil || route.Host != "127.0.0.2" || route.Port != 8022 {
+		t.Errorf("port 8022 routed to %+v", route)
+	}
+	if route := forwardedRoute(cfg, 2200); route != nil {
+		t.Errorf("expected no forward to port 2200, got %+v", route)
+	}
+}
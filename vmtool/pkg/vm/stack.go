@@ -0,0 +1,336 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/network"
+	"github.com/utmapp/vmtool/pkg/qemu"
+	"gopkg.in/yaml.v3"
+)
+
+// Stack change actions
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// VM settings that are read when used rather than when QEMU starts, so changing them needs no restart.
+var liveFields = map[string]bool{
+	"autostart":  true,
+	"restart":    true,
+	"depends_on": true,
+	"groups":     true,
+	"ssh":        true,
+	"stack":      true,
+}
+
+// Change is one step of a stack plan.
+type Change struct {
+	Kind    string   `json:"kind"` // vm, network or disk
+	Name    string   `json:"name"` // the path of a disk
+	Action  string   `json:"action"`
+	Fields  []string `json:"fields,omitempty"`  // top level settings an update changes
+	Running bool     `json:"running,omitempty"` // the VM or network is running now
+	Restart bool     `json:"restart,omitempty"` // the update takes effect once the running VM or network restarts
+
+	vm      *config.VMConfig
+	network *config.VirtualNetworkConfig
+	disk    *config.DiskConfig
+}
+
+// StackPlan lists what applying a stack changes, in the order it is applied.
+type StackPlan struct {
+	Stack   string   `json:"stack"`
+	Changes []Change `json:"changes"`
+}
+
+// StackOptions control how a stack is planned and applied.
+type StackOptions struct {
+	Adopt   bool        // take over VMs and networks of the same name that no stack manages
+	Restart bool        // restart running VMs whose update needs it
+	Stop    StopOptions // for VMs stopped to be deleted or restarted
+}
+
+// PlanStack compares a stack with the stores: what is missing is created, what differs updated,
+// and what the stack managed but no longer lists deleted. Disk images are only ever created.
+func (m *Manager) PlanStack(s *config.StackConfig, opts StackOptions) (*StackPlan, error) {
+	plan := &StackPlan{Stack: s.Name}
+	wantNets := make(map[string]*config.VirtualNetworkConfig)
+	for i := range s.Networks {
+		want := s.Networks[i]
+		wantNets[want.Name] = &want
+		have, ok := m.networks.GetNetwork(want.Name)
+		if !ok {
+			if want.UUID == "" {
+				want.UUID = uuid.New().String()
+			}
+			plan.Changes = append(plan.Changes, Change{Kind: "network", Name: want.Name, Action: ActionCreate, network: &want})
+			continue
+		}
+		if err := m.owned("network", want.Name, have.Stack, s.Name, opts); err != nil {
+			return nil, err
+		}
+		if want.UUID == "" {
+			want.UUID = have.UUID
+		}
+		if fields := changedFields(have, &want); len(fields) > 0 {
+			running := network.IsRunning(want.Name)
+			plan.Changes = append(plan.Changes, Change{Kind: "network", Name: want.Name, Action: ActionUpdate, Fields: fields, Running: running, Restart: running, network: &want})
+		}
+	}
+
+	for i := range s.Disks {
+		d := s.Disks[i]
+		if _, err := os.Stat(d.Path); os.IsNotExist(err) {
+			plan.Changes = append(plan.Changes, Change{Kind: "disk", Name: d.Path, Action: ActionCreate, disk: &d})
+		}
+	}
+
+	wantVMs := make(map[string]*config.VMConfig)
+	for i := range s.VMs {
+		want := s.VMs[i]
+		wantVMs[want.Name] = &want
+		have, ok := m.store.GetVM(want.Name)
+		if !ok {
+			if want.UUID == "" {
+				want.UUID = uuid.New().String()
+			}
+			plan.Changes = append(plan.Changes, Change{Kind: "vm", Name: want.Name, Action: ActionCreate, vm: &want})
+			continue
+		}
+		if err := m.owned("VM", want.Name, have.Stack, s.Name, opts); err != nil {
+			return nil, err
+		}
+		if want.UUID == "" {
+			want.UUID = have.UUID
+		}
+		fields := changedFields(have, &want)
+		if len(fields) == 0 {
+			continue
+		}
+		c := Change{Kind: "vm", Name: want.Name, Action: ActionUpdate, Fields: fields, Running: m.GetStatus(want.Name) == "running", vm: &want}
+		for _, f := range fields {
+			c.Restart = c.Restart || (c.Running && !liveFields[f])
+		}
+		plan.Changes = append(plan.Changes, c)
+	}
+
+	var deletedVMs []string
+	for _, have := range m.store.ListVMs() {
+		if have.Stack == s.Name && wantVMs[have.Name] == nil {
+			deletedVMs = append(deletedVMs, have.Name)
+		}
+	}
+	sort.Strings(deletedVMs)
+	for _, name := range m.stopOrder(deletedVMs) {
+		plan.Changes = append(plan.Changes, Change{Kind: "vm", Name: name, Action: ActionDelete, Running: m.GetStatus(name) == "running"})
+	}
+	var deletedNets []string
+	deletedNet := make(map[string]bool)
+	for _, have := range m.networks.ListNetworks() {
+		if have.Stack == s.Name && wantNets[have.Name] == nil {
+			deletedNets = append(deletedNets, have.Name)
+			deletedNet[have.Name] = true
+		}
+	}
+	sort.Strings(deletedNets)
+	for _, name := range deletedNets {
+		plan.Changes = append(plan.Changes, Change{Kind: "network", Name: name, Action: ActionDelete, Running: network.IsRunning(name)})
+	}
+
+	// The VMs as they will be, to check what they refer to.
+	deleted := make(map[string]bool)
+	for _, name := range deletedVMs {
+		deleted[name] = true
+	}
+	lookup := func(name string) (*config.VMConfig, bool) {
+		if cfg, ok := wantVMs[name]; ok {
+			return cfg, true
+		}
+		if deleted[name] {
+			return nil, false
+		}
+		return m.store.GetVM(name)
+	}
+	var all []string
+	for _, cfg := range m.store.ListVMs() {
+		if !deleted[cfg.Name] && wantVMs[cfg.Name] == nil {
+			all = append(all, cfg.Name)
+		}
+	}
+	for i := range s.VMs {
+		all = append(all, s.VMs[i].Name)
+	}
+	sort.Strings(all)
+	if _, err := startOrder(lookup, all, true); err != nil {
+		return nil, err
+	}
+	for _, name := range all {
+		cfg, _ := lookup(name)
+		for _, nic := range cfg.NICs() {
+			if !nic.IsVirtual() {
+				continue
+			}
+			netCfg, exists := m.networks.GetNetwork(nic.Network)
+			if wantNets[nic.Network] == nil && (!exists || deletedNet[nic.Network]) {
+				return nil, fmt.Errorf("VM %s is attached to network %s, which the stack doesn't provide", name, nic.Network)
+			}
+			if want := wantNets[nic.Network]; want != nil {
+				netCfg = want
+			}
+			if nic.IPAddress != "" {
+				if err := netCfg.CheckReservation(nic.IPAddress); err != nil {
+					return nil, fmt.Errorf("VM %s: %v", name, err)
+				}
+			}
+		}
+	}
+	return plan, nil
+}
+
+// PlanDestroy lists the deletion of everything stack manages, and of disks when given.
+func (m *Manager) PlanDestroy(stack string, disks []config.DiskConfig) *StackPlan {
+	plan := &StackPlan{Stack: stack}
+	var vms, nets []string
+	for _, cfg := range m.store.ListVMs() {
+		if cfg.Stack == stack {
+			vms = append(vms, cfg.Name)
+		}
+	}
+	for _, cfg := range m.networks.ListNetworks() {
+		if cfg.Stack == stack {
+			nets = append(nets, cfg.Name)
+		}
+	}
+	sort.Strings(vms)
+	sort.Strings(nets)
+	for _, name := range m.stopOrder(vms) {
+		plan.Changes = append(plan.Changes, Change{Kind: "vm", Name: name, Action: ActionDelete, Running: m.GetStatus(name) == "running"})
+	}
+	for _, name := range nets {
+		plan.Changes = append(plan.Changes, Change{Kind: "network", Name: name, Action: ActionDelete, Running: network.IsRunning(name)})
+	}
+	for i := range disks {
+		if _, err := os.Stat(disks[i].Path); err == nil {
+			plan.Changes = append(plan.Changes, Change{Kind: "disk", Name: disks[i].Path, Action: ActionDelete})
+		}
+	}
+	return plan
+}
+
+// ApplyStack carries out a plan, calling progress before each change. It stops at the first error.
+func (m *Manager) ApplyStack(ctx context.Context, plan *StackPlan, opts StackOptions, progress func(Change)) error {
+	for _, c := range plan.Changes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		progress(c)
+		if err := m.applyChange(ctx, c, opts); err != nil {
+			return fmt.Errorf("%s %s %s: %v", c.Action, c.Kind, c.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) applyChange(ctx context.Context, c Change, opts StackOptions) error {
+	switch {
+	case c.Kind == "network" && c.Action == ActionDelete:
+		if c.Running {
+			if err := network.Stop(c.Name); err != nil {
+				return err
+			}
+		}
+		if err := m.networks.DeleteNetwork(c.Name); err != nil {
+			return err
+		}
+		os.RemoveAll(network.RuntimeDir(c.Name))
+	case c.Kind == "network":
+		return m.networks.SaveNetwork(c.network)
+
+	case c.Kind == "disk" && c.Action == ActionDelete:
+		return os.Remove(c.Name)
+	case c.Kind == "disk":
+		size, _ := config.ParseSize(c.disk.Size)
+		if err := os.MkdirAll(filepath.Dir(c.Name), 0755); err != nil {
+			return err
+		}
+		return qemu.CreateDisk(c.Name, strconv.FormatInt(size, 10), c.disk.Format)
+
+	case c.Kind == "vm" && c.Action == ActionDelete:
+		if c.Running {
+			if _, err := m.StopVM(ctx, c.Name, opts.Stop); err != nil {
+				return err
+			}
+		}
+		return m.store.DeleteVM(c.Name)
+	case c.Kind == "vm":
+		if err := m.store.SaveVM(c.vm); err != nil {
+			return err
+		}
+		if c.Restart && opts.Restart {
+			if _, err := m.StopVM(ctx, c.Name, opts.Stop); err != nil {
+				return err
+			}
+			return m.StartVM(ctx, c.Name)
+		}
+	}
+	return nil
+}
+
+// stopOrder orders VMs to be stopped, each before those it depends on.
+func (m *Manager) stopOrder(names []string) []string {
+	order, err := startOrder(m.store.GetVM, names, false)
+	if err != nil {
+		return names
+	}
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// owned checks that an existing VM or network of the same name may be taken over by stack.
+func (m *Manager) owned(kind, name, owner, stack string, opts StackOptions) error {
+	switch {
+	case owner == stack:
+		return nil
+	case owner != "":
+		return fmt.Errorf("%s %s belongs to stack %s", kind, name, owner)
+	case !opts.Adopt:
+		return fmt.Errorf("%s %s exists outside the stack, use --adopt to manage it with the stack", kind, name)
+	}
+	return nil
+}
+
+// changedFields returns the top level YAML keys whose values differ between a and b.
+func changedFields(a, b interface{}) []string {
+	toMap := func(v interface{}) map[string]interface{} {
+		data, _ := yaml.Marshal(v)
+		var m map[string]interface{}
+		yaml.Unmarshal(data, &m)
+		return m
+	}
+	ma, mb := toMap(a), toMap(b)
+	var fields []string
+	for k, v := range mb {
+		if !reflect.DeepEqual(ma[k], v) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range ma {
+		if _, ok := mb[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,143 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/network"
+)
+
+func TestStack(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("VMTOOL_HOME", home)
+	store, err := NewStore(filepath.Join(home, "machines"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	networks, err := network.NewStore(filepath.Join(home, "networks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(store, networks, &config.AppConfig{})
+	store.SaveVM(&config.VMConfig{Name: "manual", UUID: "11111111-1111-1111-1111-111111111111"})
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "existing.qcow2"), nil, 0644)
+	load := func(content string) *config.StackConfig {
+		t.Helper()
+		path := filepath.Join(dir, "stack.yaml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		s, err := config.LoadStack(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	summary := func(plan *StackPlan) string {
+		var lines []string
+		for _, c := range plan.Changes {
+			line := fmt.Sprintf("%s %s %s", c.Action, c.Kind, filepath.Base(c.Name))
+			if len(c.Fields) > 0 {
+				line += " " + strings.Join(c.Fields, ",")
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "; ")
+	}
+
+	stack := load(`
+name: lab
+networks:
+  - name: labnet
+    subnet: 10.77.0.0/24
+disks:
+  - {path: existing.qcow2, size: 1G}
+  - {path: new.qcow2, size: 1G}
+vms:
+  - name: router
+    network: labnet
+    drives:
+      - {id: 0, interface: virtio, image_path: existing.qcow2, image_type: disk}
+  - name: web
+    depends_on: [router]
+    system: {memory: 1024}
+`)
+	if got := stack.VMs[0].Drives[0].ImagePath; got != filepath.Join(dir, "existing.qcow2") {
+		t.Errorf("drive path not resolved: %s", got)
+	}
+	plan, err := m.PlanStack(stack, StackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := summary(plan), "create network labnet; create disk new.qcow2; create vm router; create vm web"; got != want {
+		t.Errorf("first plan:\n got %s\nwant %s", got, want)
+	}
+	// Apply all but the disk, which would need qemu-img.
+	plan.Changes = append(plan.Changes[:1], plan.Changes[2:]...)
+	if err := m.ApplyStack(context.Background(), plan, StackOptions{}, func(Change) {}); err != nil {
+		t.Fatal(err)
+	}
+	web, _ := store.GetVM("web")
+	if web.Stack != "lab" || web.UUID == "" {
+		t.Errorf("web saved without stack or UUID: %+v", web)
+	}
+	uuid := web.UUID
+
+	// Changed memory, a new group instead of the dependency, and router gone.
+	stack = load(`
+name: lab
+networks:
+  - name: labnet
+    subnet: 10.77.0.0/24
+vms:
+  - name: web
+    groups: [lab]
+    system: {memory: 2048}
+`)
+	plan, err = m.PlanStack(stack, StackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := summary(plan), "update vm web depends_on,groups,system; delete vm router"; got != want {
+		t.Errorf("second plan:\n got %s\nwant %s", got, want)
+	}
+	if plan.Changes[0].vm.UUID != uuid {
+		t.Errorf("update lost the UUID")
+	}
+
+	// A VM of the same name made by hand is only taken over when asked.
+	stack = load(`
+name: lab
+vms:
+  - name: manual
+`)
+	if _, err := m.PlanStack(stack, StackOptions{}); err == nil || !strings.Contains(err.Error(), "--adopt") {
+		t.Errorf("conflict: got %v", err)
+	}
+	if _, err := m.PlanStack(stack, StackOptions{Adopt: true}); err != nil {
+		t.Errorf("adopt: %v", err)
+	}
+
+	// Dropping the network web is attached to is refused.
+	stack = load(`
+name: lab
+vms:
+  - name: router
+    network: labnet
+`)
+	if _, err := m.PlanStack(stack, StackOptions{}); err == nil || !strings.Contains(err.Error(), "labnet") {
+		t.Errorf("missing network: got %v", err)
+	}
+
+	// web depends on router, so it is deleted first.
+	if got, want := summary(m.PlanDestroy("lab", nil)), "delete vm web; delete vm router; delete network labnet"; got != want {
+		t.Errorf("destroy plan:\n got %s\nwant %s", got, want)
+	}
+}
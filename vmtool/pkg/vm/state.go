@@ -0,0 +1,64 @@
+package vm
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// RuntimeState records what was decided when a VM started, for any vmtool process to pick up.
+type RuntimeState struct {
+	PID       int               `json:"pid"`
+	StartedAt time.Time         `json:"started_at"`
+	VNC       *qemu.VNCEndpoint `json:"vnc,omitempty"`
+}
+
+func statePath(name string) string {
+	return filepath.Join(qemu.RuntimeDir(name), "state.json")
+}
+
+func loadState(name string) (*RuntimeState, error) {
+	data, err := os.ReadFile(statePath(name))
+	if err != nil {
+		return nil, err
+	}
+	var st RuntimeState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func saveState(name string, st *RuntimeState) error {
+	if err := os.MkdirAll(qemu.RuntimeDir(name), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statePath(name), data, 0600)
+}
+
+func removeState(name string) {
+	os.Remove(statePath(name))
+}
+
+// State returns the runtime state of a running VM.
+func (m *Manager) State(name string) (*RuntimeState, error) {
+	if _, err := m.lookup(name); err != nil {
+		return nil, err
+	}
+	st, err := loadState(name)
+	if err != nil {
+		// Started before runtime state existed: only fixed endpoints are known.
+		st = &RuntimeState{}
+		if cfg, ok := m.store.GetVM(name); ok {
+			st.VNC, _ = qemu.FixedVNCEndpoint(cfg.Display)
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,50 @@
+package vm
+
+import (
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/utmapp/vmtool/pkg/config"
+)
+
+func TestStatusDialsOnlyVMsWithState(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("VMTOOL_HOME", home)
+	store, err := NewStore(filepath.Join(home, "machines"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.VMConfig{Name: "web", UUID: "22222222-2222-2222-2222-222222222222"}
+	store.SaveVM(cfg)
+	m := NewManager(store, nil, &config.AppConfig{})
+
+	// Stands in for a QMP socket left behind by a QEMU that is gone.
+	ln, err := net.Listen("unix", filepath.Join(home, cfg.UUID+".qmp"))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	var dials atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			dials.Add(1)
+			conn.Close()
+		}
+	}()
+
+	if got := m.GetStatus("web"); got != "stopped" || dials.Load() != 0 {
+		t.Errorf("without runtime state: got %s after %d dials", got, dials.Load())
+	}
+	if err := saveState("web", &RuntimeState{PID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetStatus("web"); got != "stopped" || dials.Load() != 1 {
+		t.Errorf("with runtime state: got %s after %d dials", got, dials.Load())
+	}
+}
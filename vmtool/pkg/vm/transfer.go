@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// Each guest agent read or write moves at most this much; the data travels base64 encoded in JSON.
+const transferChunk = 1 << 20
+
+// TransferOptions control a copy to or from the guest.
+type TransferOptions struct {
+	// Offset resumes an interrupted copy: an upload appends from here, which must be the
+	// size of the partial file in the guest, and a download starts reading here.
+	Offset int64
+	// Progress is called after each chunk with the bytes copied so far, including Offset.
+	Progress func(done int64)
+}
+
+// GuestFileSize returns the size of a file in the guest, -1 if it doesn't exist.
+func (m *Manager) GuestFileSize(name, path string) (int64, error) {
+	agent, err := m.GuestAgent(name)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := agent.FileOpen(path, "rb")
+	if err != nil {
+		if strings.Contains(err.Error(), "No such file") || strings.Contains(err.Error(), "cannot find") {
+			return -1, nil
+		}
+		return 0, err
+	}
+	defer agent.FileClose(handle)
+	return agent.FileSeek(handle, 0, "end")
+}
+
+// UploadFile copies r into a file in the guest, replacing it, or appending to it from opts.Offset.
+func (m *Manager) UploadFile(ctx context.Context, name, path string, r io.Reader, opts TransferOptions) (int64, error) {
+	agent, err := m.GuestAgent(name)
+	if err != nil {
+		return 0, err
+	}
+	mode := "wb"
+	if opts.Offset > 0 {
+		size, err := m.GuestFileSize(name, path)
+		if err != nil {
+			return 0, err
+		}
+		if size != opts.Offset {
+			return 0, fmt.Errorf("cannot resume at %d bytes, %s has %d in the guest", opts.Offset, path, size)
+		}
+		mode = "ab"
+	}
+	handle, err := agent.FileOpen(path, mode)
+	if err != nil {
+		return 0, err
+	}
+	done := opts.Offset
+	buf := make([]byte, transferChunk)
+	for {
+		if err := ctx.Err(); err != nil {
+			agent.FileClose(handle)
+			return done, err
+		}
+		n, rerr := io.ReadFull(r, buf)
+		for p := buf[:n]; len(p) > 0; {
+			w, err := agent.FileWrite(handle, p)
+			if err == nil && w == 0 {
+				err = fmt.Errorf("guest accepted no data")
+			}
+			if err != nil {
+				agent.FileClose(handle)
+				return done, fmt.Errorf("write to %s failed after %d bytes: %v", path, done, err)
+			}
+			p = p[w:]
+			done += int64(w)
+		}
+		if n > 0 && opts.Progress != nil {
+			opts.Progress(done)
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			agent.FileClose(handle)
+			return done, rerr
+		}
+	}
+	// Closing flushes the guest's buffers, so only then is the file complete.
+	if err := agent.FileClose(handle); err != nil {
+		return done, err
+	}
+	return done, nil
+}
+
+// DownloadFile copies a file in the guest to w, starting at opts.Offset.
+func (m *Manager) DownloadFile(ctx context.Context, name, path string, w io.Writer, opts TransferOptions) (int64, error) {
+	agent, err := m.GuestAgent(name)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := agent.FileOpen(path, "rb")
+	if err != nil {
+		return 0, err
+	}
+	defer agent.FileClose(handle)
+	if opts.Offset > 0 {
+		if _, err := agent.FileSeek(handle, opts.Offset, "set"); err != nil {
+			return 0, err
+		}
+	}
+	done := opts.Offset
+	for {
+		if err := ctx.Err(); err != nil {
+			return done, err
+		}
+		data, eof, err := agent.FileRead(handle, transferChunk)
+		if err != nil {
+			return done, fmt.Errorf("read from %s failed after %d bytes: %v", path, done, err)
+		}
+		if len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				return done, err
+			}
+			done += int64(len(data))
+			if opts.Progress != nil {
+				opts.Progress(done)
+			}
+		}
+		if eof || len(data) == 0 {
+			return done, nil
+		}
+	}
+}
+
+// GuestFileMode returns a file's permission bits. It runs stat in the guest, so it only works
+// in guests with a POSIX stat and guest-exec allowed.
+func (m *Manager) GuestFileMode(name, path string) (os.FileMode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	res, err := m.GuestExec(ctx, name, qemu.GuestExecRequest{Path: "stat", Args: []string{"-c", "%a", path}})
+	if err != nil {
+		return 0, err
+	}
+	if res.ExitCode != 0 {
+		return 0, fmt.Errorf("stat failed: %s", strings.TrimSpace(string(res.Stderr)))
+	}
+	mode, err := strconv.ParseUint(strings.TrimSpace(string(res.Stdout)), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected stat output %q", res.Stdout)
+	}
+	return os.FileMode(mode), nil
+}
+
+// GuestChmod sets a file's permission bits by running chmod in the guest.
+func (m *Manager) GuestChmod(name, path string, mode os.FileMode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	res, err := m.GuestExec(ctx, name, qemu.GuestExecRequest{Path: "chmod", Args: []string{fmt.Sprintf("%o", mode.Perm()), path}})
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("chmod failed: %s", strings.TrimSpace(string(res.Stderr)))
+	}
+	return nil
+}
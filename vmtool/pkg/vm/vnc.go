@@ -0,0 +1,82 @@
+package vm
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/utmapp/vmtool/pkg/config"
+	"github.com/utmapp/vmtool/pkg/qemu"
+)
+
+// allocateVNC picks the VNC endpoint for a starting VM: the one pinned in its configuration,
+// a unix socket in its runtime directory, or the first port of the configured range that no other VM uses and nothing else listens on.
+// Must be called with m.vncMu held so that concurrent starts don't pick the same port.
+func (m *Manager) allocateVNC(cfg *config.VMConfig) (*qemu.VNCEndpoint, error) {
+	if !cfg.Display.Enabled {
+		return nil, nil
+	}
+	fixed, err := qemu.FixedVNCEndpoint(cfg.Display)
+	if err != nil {
+		return nil, err
+	}
+	if fixed != nil {
+		if !portFree(fixed.Host, fixed.Port) {
+			return nil, fmt.Errorf("VNC port %d is already in use", fixed.Port)
+		}
+		return fixed, nil
+	}
+	if cfg.Display.VNCSocket || m.vncRange.Socket {
+		path := qemu.VNCSocketPath(cfg.Name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		os.Remove(path)
+		return &qemu.VNCEndpoint{Socket: path}, nil
+	}
+
+	used := make(map[int]bool)
+	for name, port := range m.vncPorts {
+		if name != cfg.Name {
+			used[port] = true
+		}
+	}
+	for _, other := range m.store.ListVMs() {
+		if other.Name == cfg.Name {
+			continue
+		}
+		if st, err := loadState(other.Name); err == nil && st.VNC != nil && st.VNC.Socket == "" && qemu.NewRunner(other).Alive() {
+			used[st.VNC.Port] = true
+		}
+	}
+
+	for port := m.vncRange.PortMin; port <= m.vncRange.PortMax; port++ {
+		if !used[port] && portFree("127.0.0.1", port) {
+			return &qemu.VNCEndpoint{Host: "127.0.0.1", Port: port}, nil
+		}
+	}
+	return nil, fmt.Errorf("no free VNC port between %d and %d", m.vncRange.PortMin, m.vncRange.PortMax)
+}
+
+func portFree(host string, port int) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// VNCEndpoint returns where the running VM serves VNC.
+func (m *Manager) VNCEndpoint(name string) (*qemu.VNCEndpoint, error) {
+	st, err := m.State(name)
+	if err != nil {
+		return nil, err
+	}
+	if st.VNC == nil {
+		return nil, fmt.Errorf("VNC is not enabled for VM %s", name)
+	}
+	return st.VNC, nil
+}